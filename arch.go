@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// archLabel is the well-known node label carrying the CPU architecture.
+const archLabel = "kubernetes.io/arch"
+
+// knownImageArchs lists the architectures published for the official images the stack uses,
+// as of their current tags. The check is a heuristic: images not listed here are not checked.
+var knownImageArchs = map[string][]string{
+	"mysql":     {"amd64", "arm64"},
+	"mariadb":   {"amd64", "arm64", "ppc64le", "s390x"},
+	"wordpress": {"amd64", "arm64", "arm", "386", "ppc64le", "s390x", "mips64le"},
+	"busybox":   {"amd64", "arm64", "arm", "386", "ppc64le", "s390x", "riscv64"},
+	"redis":     {"amd64", "arm64", "arm", "386", "ppc64le", "s390x"},
+}
+
+// imageArchs returns the architectures image is likely published for, and whether it is known.
+func imageArchs(image string) ([]string, bool) {
+	repo := strings.TrimPrefix(strings.TrimPrefix(imageRepository(image), "docker.io/"), "library/")
+
+	// Oracle only ever published MySQL 5.7 for amd64.
+	if repo == "mysql" && strings.HasPrefix(mysqlVersionFromImage(image), "5.") {
+		return []string{"amd64"}, true
+	}
+	archs, ok := knownImageArchs[repo]
+	return archs, ok
+}
+
+// stackImages returns every image the stack's pods will pull.
+func stackImages(payload RequestPayload) []string {
+	var images []string
+	if payload.ExternalDatabase == nil || payload.CheckExternalDatabase {
+		images = append(images, payload.MySQLImage)
+	}
+	images = append(images, payload.WordPressImage)
+	if payload.Canary != nil {
+		images = append(images, payload.Canary.Image)
+	}
+	if payload.WPCLISidecar || payload.AutoInstall {
+		images = append(images, payload.WPCLIImage)
+	}
+	if payload.WaitForDB {
+		images = append(images, payload.WaitForDBImage)
+	}
+	if payload.RedisCache {
+		images = append(images, defaultRedisImage)
+	}
+	return images
+}
+
+// imageArchWarnings compares the architectures of the cluster's nodes with those the stack's
+// images are published for, and describes every image that likely can't run on some of them.
+func imageArchWarnings(ctx context.Context, clientSet *kubernetes.Clientset, payload RequestPayload) ([]string, error) {
+	nodes, err := clientSet.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list nodes: %w", err)
+	}
+	nodeArchs := map[string][]string{}
+	for _, node := range nodes.Items {
+		if arch := node.Labels[archLabel]; arch != "" {
+			nodeArchs[arch] = append(nodeArchs[arch], node.Name)
+		}
+	}
+
+	var warnings []string
+	for _, image := range stackImages(payload) {
+		archs, ok := imageArchs(image)
+		if !ok {
+			continue
+		}
+		var missing []string
+		for arch := range nodeArchs {
+			if !containsString(archs, arch) {
+				missing = append(missing, arch)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		sort.Strings(missing)
+		if len(missing) == len(nodeArchs) {
+			warnings = append(warnings, fmt.Sprintf("image %s is likely not published for %s, the architecture of every node; "+
+				"pods may fail to pull or run emulated", image, strings.Join(missing, "/")))
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("image %s is likely not published for %s; pin its pods to %s nodes",
+			image, strings.Join(missing, "/"), strings.Join(archs, "/")))
+	}
+	return warnings, nil
+}
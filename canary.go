@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CanaryConfig adds a second WordPress deployment behind the same Service. Traffic splits by
+// replica count, so the canary gets roughly replicas / (replicas + main replicas) of requests.
+type CanaryConfig struct {
+	Image    string `json:"image"`              // WordPress image under test
+	Replicas int32  `json:"replicas,omitempty"` // Defaults to 1
+}
+
+// canaryWeightPercent returns the approximate share of traffic the canary receives.
+func canaryWeightPercent(payload RequestPayload) int32 {
+	return payload.Canary.Replicas * 100 / (payload.Canary.Replicas + payload.Replicas)
+}
+
+// canaryCoreVolume is the canary's own docroot. WordPress core lives on the shared volume, and
+// the image only copies its core into an empty docroot, so a canary mounting the main
+// deployment's docroot would keep serving the old core whatever its image.
+const canaryCoreVolume = "canary-core"
+
+// buildWordPressCanaryDeployment returns the canary deployment: the main WordPress deployment
+// with its own name and selector, the canary image and replica count, sharing the secret and
+// wp-content. Its core comes from the canary image, see useCanaryCore.
+func buildWordPressCanaryDeployment(payload RequestPayload, names stackNames) *appsv1.Deployment {
+	deployment := buildWordPressDeployment(payload, names)
+
+	deployName := names.WPCanaryDeployment
+	deployment.Name = deployName
+	deployment.Labels = stackLabels(deployName, names, componentWordPress)
+	deployment.Spec.Replicas = int32Ptr(payload.Canary.Replicas)
+	deployment.Spec.Selector.MatchLabels = map[string]string{"app": deployName}
+	deployment.Spec.Template.Labels = wordPressPodLabels(deployName, names)
+	deployment.Spec.Template.Spec.Containers[0].Image = payload.Canary.Image
+	deployment.Spec.Template.Spec.Containers[0].ImagePullPolicy = imagePullPolicy(payload, payload.Canary.Image)
+	useCanaryCore(&deployment.Spec.Template.Spec, payload)
+
+	for i := range deployment.Spec.Template.Spec.TopologySpreadConstraints {
+		deployment.Spec.Template.Spec.TopologySpreadConstraints[i].LabelSelector.MatchLabels = map[string]string{
+			"app": deployName,
+		}
+	}
+	return deployment
+}
+
+// useCanaryCore gives every container of spec that mounts the WordPress volume as its docroot an
+// emptyDir there instead, with only wp-content mounted from the volume. The image's entrypoint
+// then copies in the canary's core while plugins, themes and uploads stay shared.
+func useCanaryCore(spec *corev1.PodSpec, payload RequestPayload) {
+	for i := range spec.Containers {
+		var mounts []corev1.VolumeMount
+		for _, mount := range spec.Containers[i].VolumeMounts {
+			if mount.Name == "wordpress-persistent-storage" && mount.MountPath == payload.WordPressDataPath {
+				// The docroot must be mounted before wp-content, which lies inside it.
+				mounts = append(mounts, corev1.VolumeMount{Name: canaryCoreVolume, MountPath: mount.MountPath})
+				mount.MountPath = path.Join(mount.MountPath, "wp-content")
+				mount.SubPath = path.Join(mount.SubPath, "wp-content")
+			}
+			mounts = append(mounts, mount)
+		}
+		spec.Containers[i].VolumeMounts = mounts
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         canaryCoreVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+}
+
+// createWordPressCanaryDeployment creates the Deployment described by buildWordPressCanaryDeployment.
+func createWordPressCanaryDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	deployment := buildWordPressCanaryDeployment(payload, names)
+	_, err := clientSet.AppsV1().Deployments(payload.Namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create WordPress canary deployment %s: %w", names.WPCanaryDeployment, err)
+	}
+	return nil
+}
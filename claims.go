@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Values of wp_admin_delivery.
+const (
+	adminDeliveryResponse = "response"
+	adminDeliveryClaim    = "claim"
+)
+
+// adminDeliveries are the values of wp_admin_delivery.
+var adminDeliveries = []string{adminDeliveryResponse, adminDeliveryClaim}
+
+// adminClaimTTL is how long the admin credentials of a deploy can be claimed.
+const adminClaimTTL = time.Hour
+
+// adminClaimTokenLength gives claim tokens about 165 bits of entropy.
+const adminClaimTokenLength = 32
+
+// AdminClaim is returned instead of the admin credentials with wp_admin_delivery "claim". POST
+// to ClaimURL returns the credentials once; later claims, and claims after ExpiresAt, fail.
+type AdminClaim struct {
+	Token     string    `json:"token"`
+	ClaimURL  string    `json:"claim_url"` // Path on this API
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// adminClaim holds the credentials behind a token until claimed or expired.
+type adminClaim struct {
+	Credentials AdminCredentials
+	ExpiresAt   time.Time
+}
+
+var (
+	adminClaimsMu sync.Mutex
+	adminClaims   = map[string]adminClaim{}
+)
+
+// newAdminClaim stores creds behind a fresh token, pruning expired claims. The credentials
+// only live in memory, so a restart of the deployer invalidates every unclaimed token.
+func newAdminClaim(creds AdminCredentials) (AdminClaim, error) {
+	token, err := generateRandomSuffix(adminClaimTokenLength)
+	if err != nil {
+		return AdminClaim{}, fmt.Errorf("unable to generate claim token: %w", err)
+	}
+	expiresAt := time.Now().Add(adminClaimTTL).UTC().Truncate(time.Second)
+
+	adminClaimsMu.Lock()
+	defer adminClaimsMu.Unlock()
+	for t, claim := range adminClaims {
+		if time.Now().After(claim.ExpiresAt) {
+			delete(adminClaims, t)
+		}
+	}
+	adminClaims[token] = adminClaim{Credentials: creds, ExpiresAt: expiresAt}
+	return AdminClaim{Token: token, ClaimURL: "/claim/" + token, ExpiresAt: expiresAt}, nil
+}
+
+// takeAdminClaim returns the credentials behind token and forgets them, so each token is
+// redeemed at most once.
+func takeAdminClaim(token string) (AdminCredentials, bool) {
+	adminClaimsMu.Lock()
+	defer adminClaimsMu.Unlock()
+	claim, ok := adminClaims[token]
+	if !ok {
+		return AdminCredentials{}, false
+	}
+	delete(adminClaims, token)
+	if time.Now().After(claim.ExpiresAt) {
+		return AdminCredentials{}, false
+	}
+	return claim.Credentials, true
+}
+
+// handleClaim returns the admin credentials behind a claim token exactly once. It is a POST
+// so that link previews and prefetchers, which only GET, cannot use the token up.
+func handleClaim(w http.ResponseWriter, r *http.Request) {
+	creds, ok := takeAdminClaim(r.PathValue("token"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Unknown claim token: it was already claimed, has expired or never existed",
+		})
+		return
+	}
+	log.Printf("[INFO] Admin credentials for %s claimed", creds.LoginURL)
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Admin credentials claimed; this token no longer works",
+		WPAdmin: &creds,
+	})
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DeleteRequest is the body of the delete endpoints.
+type DeleteRequest struct {
+	Kubeconfig  string `json:"kubeconfig,omitempty"` // As in the create request
+	KubeContext string `json:"context,omitempty"`    // As in the create request
+	Namespace   string `json:"namespace,omitempty"`  // Required
+
+	// Identify the stack for /delete; ignored by /delete-all.
+	DeploymentName string `json:"deployment_name,omitempty"` // Defaults to "wp"
+	Suffix         string `json:"suffix,omitempty"`
+
+	// SnapshotBeforeDelete takes a VolumeSnapshot of every MySQL PVC first and aborts the
+	// delete if any snapshot fails. SnapshotClass defaults to the cluster's default class.
+	SnapshotBeforeDelete bool   `json:"snapshot_before_delete,omitempty"`
+	SnapshotClass        string `json:"snapshot_class,omitempty"`
+
+	// KeepData, true unless set to false, keeps the PVs, PVCs and database credentials so the
+	// data survives. To reattach it, POST the original create payload and the stack's suffix to
+	// /reconcile: it recreates the workloads, which mount the kept claims and log in to MySQL
+	// with the kept credentials.
+	KeepData *bool `json:"keep_data,omitempty"`
+}
+
+// keepData reports whether the delete preserves the stack's data, which is the default.
+func (req DeleteRequest) keepData() bool {
+	return req.KeepData == nil || *req.KeepData
+}
+
+// DeletionResult reports the outcome of deleting one resource.
+type DeletionResult struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// managedKind lists and deletes one kind of resource the deployer creates.
+type managedKind struct {
+	Kind   string
+	List   func(ctx context.Context, namespace, selector string) ([]string, error)
+	Delete func(ctx context.Context, namespace, name string) error
+}
+
+// managedKinds returns the namespaced kinds the deployer creates, in deletion order:
+// workloads first so nothing keeps using the config, secrets and claims removed after them.
+func managedKinds(clientSet *kubernetes.Clientset) []managedKind {
+	// Background propagation also removes the ReplicaSets and pods of Deployments and Jobs.
+	background := metaV1.DeletePropagationBackground
+	opts := metaV1.DeleteOptions{PropagationPolicy: &background}
+	listOpts := func(selector string) metaV1.ListOptions { return metaV1.ListOptions{LabelSelector: selector} }
+
+	return []managedKind{
+		{
+			Kind: "HTTPRoute",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				return listHTTPRoutes(ctx, clientSet, ns, selector)
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return deleteHTTPRoute(ctx, clientSet, ns, name)
+			},
+		},
+		{
+			Kind: "Job",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.BatchV1().Jobs(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.BatchV1().Jobs(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			// Only left behind by an interrupted prepull_images.
+			Kind: "DaemonSet",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.AppsV1().DaemonSets(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.AppsV1().DaemonSets(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "Deployment",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.AppsV1().Deployments(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.AppsV1().Deployments(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "Service",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.CoreV1().Services(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.CoreV1().Services(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "ConfigMap",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.CoreV1().ConfigMaps(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.CoreV1().ConfigMaps(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			// The Secret it synced is orphaned, and deleted as the stack's own below.
+			Kind: "ExternalSecret",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				return listExternalSecrets(ctx, clientSet, ns, selector)
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return deleteExternalSecret(ctx, clientSet, ns, name)
+			},
+		},
+		{
+			Kind: "Secret",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.CoreV1().Secrets(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.CoreV1().Secrets(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "RoleBinding",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.RbacV1().RoleBindings(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.RbacV1().RoleBindings(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "Role",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.RbacV1().Roles(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.RbacV1().Roles(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "ServiceAccount",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.CoreV1().ServiceAccounts(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.CoreV1().ServiceAccounts(ns).Delete(ctx, name, opts)
+			},
+		},
+		{
+			Kind: "PersistentVolumeClaim",
+			List: func(ctx context.Context, ns, selector string) ([]string, error) {
+				list, err := clientSet.CoreV1().PersistentVolumeClaims(ns).List(ctx, listOpts(selector))
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(list.Items))
+				for _, item := range list.Items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			},
+			Delete: func(ctx context.Context, ns, name string) error {
+				return clientSet.CoreV1().PersistentVolumeClaims(ns).Delete(ctx, name, opts)
+			},
+		},
+	}
+}
+
+// pvBelongsTo reports whether a PV was created for the namespace: it says so in its namespace
+// annotation or, for PVs created before it was recorded, it is claimed from there or, if
+// unclaimed, its hostPath is the one a PV of that name gets in the namespace.
+func pvBelongsTo(pv corev1.PersistentVolume, namespace string) bool {
+	if ns, ok := pv.Annotations[pvNamespaceAnnotation]; ok {
+		return ns == namespace
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		return ref.Namespace == namespace
+	}
+	return pv.Spec.HostPath != nil && pv.Spec.HostPath.Path == hostPathFor(namespace, pv.Name)
+}
+
+// deleteManagedResources deletes everything in the namespace matching selector, then the PVs
+// of the namespace matching it, and reports each resource. A PV is only released once its
+// claim is gone, which the pvc-protection finalizer takes care of.
+// With keepData, PVCs, PVs and the database Secrets are left in place.
+func deleteManagedResources(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, selector string, keepData bool) ([]DeletionResult, error) {
+
+	var results []DeletionResult
+	record := func(kind, name string, err error) {
+		if err != nil && apierrors.IsNotFound(err) {
+			err = nil // already gone
+		}
+		result := DeletionResult{Kind: kind, Name: name, Deleted: err == nil}
+		if err != nil {
+			log.Printf("[ERROR] Failed to delete %s %s/%s: %v", kind, namespace, name, err)
+			result.Error = err.Error()
+		} else {
+			log.Printf("[INFO] Deleted %s %s/%s", kind, namespace, name)
+		}
+		results = append(results, result)
+	}
+
+	for _, kind := range managedKinds(clientSet) {
+		kindSelector := selector
+		if keepData {
+			switch kind.Kind {
+			case "PersistentVolumeClaim":
+				continue
+			case "Secret", "ExternalSecret":
+				// MySQL only reads its credentials when initialising an empty data directory.
+				kindSelector += "," + componentLabel + "!=" + componentDatabase
+			}
+		}
+		names, err := kind.List(ctx, namespace, kindSelector)
+		if err != nil {
+			return results, fmt.Errorf("unable to list %ss in %s: %w", kind.Kind, namespace, err)
+		}
+		for _, name := range names {
+			record(kind.Kind, name, kind.Delete(ctx, namespace, name))
+		}
+	}
+
+	if keepData {
+		return results, nil
+	}
+	pvs, err := clientSet.CoreV1().PersistentVolumes().List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return results, fmt.Errorf("unable to list PVs: %w", err)
+	}
+	for _, pv := range pvs.Items {
+		if !pvBelongsTo(pv, namespace) {
+			continue
+		}
+		record("PersistentVolume", pv.Name, clientSet.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metaV1.DeleteOptions{}))
+	}
+	return results, nil
+}
+
+// deleteOrphanedPVs deletes the deployer's PVs whose namespace no longer exists. Deleting a
+// namespace removes its claims but not the cluster-scoped PVs, which would otherwise pile up
+// as Released. Data on the node's hostPath is left alone, as with every PV deletion.
+func deleteOrphanedPVs(ctx context.Context, clientSet *kubernetes.Clientset) ([]DeletionResult, error) {
+	selector := labels.Set{managedByLabel: managedByValue}.String()
+	pvs, err := clientSet.CoreV1().PersistentVolumes().List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list PVs: %w", err)
+	}
+
+	var results []DeletionResult
+	namespaceExists := map[string]bool{}
+	for _, pv := range pvs.Items {
+		namespace, ok := pv.Annotations[pvNamespaceAnnotation]
+		if !ok || pv.Status.Phase == corev1.VolumeBound {
+			continue
+		}
+		exists, checked := namespaceExists[namespace]
+		if !checked {
+			_, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metaV1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return results, fmt.Errorf("unable to get namespace %s: %w", namespace, err)
+			}
+			exists = err == nil
+			namespaceExists[namespace] = exists
+		}
+		if exists {
+			continue
+		}
+
+		err := clientSet.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metaV1.DeleteOptions{})
+		if err != nil && apierrors.IsNotFound(err) {
+			err = nil
+		}
+		result := DeletionResult{Kind: "PersistentVolume", Name: pv.Name, Deleted: err == nil}
+		if err != nil {
+			log.Printf("[ERROR] Failed to delete orphaned PV %s: %v", pv.Name, err)
+			result.Error = err.Error()
+		} else {
+			log.Printf("[INFO] Deleted PV %s of deleted namespace %s", pv.Name, namespace)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// sharedDataCleanupTimeout bounds the wait for the Job removing a stack's shared volume data.
+const sharedDataCleanupTimeout = 2 * time.Minute
+
+// buildSharedDataCleanupJob returns a Job that removes the stack's <stack> directory, which
+// holds the data of all its components, from the namespace's shared claim.
+func buildSharedDataCleanupJob(namespace string, names stackNames) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      names.SharedDataCleanupJob,
+			Namespace: namespace,
+			Labels:    sharedVolumeLabels(names.SharedDataCleanupJob),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32Ptr(1),
+			TTLSecondsAfterFinished: int32Ptr(600),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels: sharedVolumeLabels(names.SharedDataCleanupJob),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadJob),
+					Containers: []corev1.Container{
+						{
+							Name:    "data-rm",
+							Image:   defaultWaitForDBImage,
+							Command: []string{"sh", "-c", `rm -rf -- "/shared/$STACK_DIR"`},
+							Env:     []corev1.EnvVar{{Name: "STACK_DIR", Value: names.ID()}},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "shared", MountPath: "/shared"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "shared",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: sharedPVCName},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// deleteSharedVolumeData removes the stack's directory from the namespace's shared volume with
+// buildSharedDataCleanupJob, reporting false when the namespace has no shared volume. It runs
+// for stacks with claims of their own too, whose directory simply does not exist.
+func deleteSharedVolumeData(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace string, names stackNames) (DeletionResult, bool) {
+
+	_, err := clientSet.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, sharedPVCName, metaV1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return DeletionResult{}, false
+	}
+	result := DeletionResult{Kind: "SharedVolumeData", Name: sharedPVCName + "/" + names.ID()}
+	if err == nil && len(validation.IsDNS1123Label(names.ID())) > 0 {
+		// Only a plain name is safe to hand to rm in the shared volume.
+		err = fmt.Errorf("stack %q is not a valid directory name", names.ID())
+	}
+	if err == nil {
+		jobs := clientSet.BatchV1().Jobs(namespace)
+		_, err = jobs.Create(ctx, buildSharedDataCleanupJob(namespace, names), metaV1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// A delete repeated within the Job's TTL waits for the earlier run instead.
+			err = nil
+		}
+		if err == nil {
+			err = waitForJobComplete(ctx, clientSet, namespace, names.SharedDataCleanupJob, sharedDataCleanupTimeout)
+		}
+	}
+	result.Deleted = err == nil
+	if err != nil {
+		log.Printf("[ERROR] Failed to delete shared volume data of stack %s: %v", names.ID(), err)
+		result.Error = err.Error()
+	} else {
+		log.Printf("[INFO] Deleted shared volume data of stack %s", names.ID())
+	}
+	return result, true
+}
+
+// rollbackStack deletes whatever a failed deploy had created of the stack. It runs on a fresh
+// context, since it is typically called after the request's own context was cancelled.
+// Shared resources and the namespace are left alone.
+func rollbackStack(payload RequestPayload, names stackNames) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	clientSet, err := InitKubeClient(payload.Kubeconfig, payload.KubeContext)
+	if err != nil {
+		log.Printf("[ERROR] Rollback of stack %s failed: %v", names.ID(), err)
+		return
+	}
+	selector := labels.Set{managedByLabel: managedByValue, stackLabel: names.ID()}.String()
+	results, err := deleteManagedResources(ctx, clientSet, payload.Namespace, selector, false)
+	if err != nil {
+		log.Printf("[ERROR] Rollback of stack %s failed: %v", names.ID(), err)
+		return
+	}
+	log.Printf("[INFO] Rolled back stack %s: %d resource(s) deleted", names.ID(), len(results))
+}
+
+// handleDeleteAll deletes every resource the deployer created in a namespace, stack by stack
+// resources and shared ones alike. The namespace itself is kept.
+func handleDeleteAll(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDeleteRequest(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("[INFO] Deleting all managed resources in namespace %s", req.Namespace)
+	deleteMatching(w, r, req, labels.Set{managedByLabel: managedByValue}.String(), nil)
+}
+
+// handleDeleteStack deletes the resources of one stack, identified by deployment name and suffix.
+// Resources shared with other stacks, like a shared volume, are kept, though without keep_data
+// the stack's directory on the shared volume is removed.
+func handleDeleteStack(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDeleteRequest(w, r)
+	if !ok {
+		return
+	}
+	if !suffixPattern.MatchString(req.Suffix) {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "suffix must be the suffix returned when the stack was created",
+		})
+		return
+	}
+	if req.DeploymentName == "" {
+		req.DeploymentName = "wp"
+	}
+
+	names := newStackNames(req.DeploymentName, req.Suffix)
+	log.Printf("[INFO] Deleting stack %s in namespace %s", names.ID(), req.Namespace)
+	deleteMatching(w, r, req, labels.Set{managedByLabel: managedByValue, stackLabel: names.ID()}.String(), &names)
+}
+
+// decodeDeleteRequest reads and checks the body shared by the delete endpoints, answering
+// the request itself when it is unusable.
+func decodeDeleteRequest(w http.ResponseWriter, r *http.Request) (DeleteRequest, bool) {
+	var req DeleteRequest
+	if !requireJSON(w, r) {
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] Failed to decode request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Invalid JSON payload",
+		})
+		return req, false
+	}
+	if req.Namespace == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "namespace is required",
+		})
+		return req, false
+	}
+	return req, true
+}
+
+// deleteMatching optionally snapshots the database volumes, then deletes every resource
+// matching selector and answers with the per-resource report. When stack is set and the data
+// is not kept, the stack's directory on the namespace's shared volume is removed as well.
+func deleteMatching(w http.ResponseWriter, r *http.Request, req DeleteRequest, selector string, stack *stackNames) {
+	clientSet, err := InitKubeClient(req.Kubeconfig, req.KubeContext)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	var snapshots []string
+	if req.SnapshotBeforeDelete {
+		snapshots, err = snapshotDatabaseClaims(r.Context(), clientSet, req.Namespace, selector, req.SnapshotClass)
+		if err != nil {
+			log.Printf("[ERROR] Snapshot before delete failed: %v", err)
+			taken := snapshotSummary(req.Namespace, snapshots)
+			w.WriteHeader(http.StatusConflict)
+			respondJSON(w, APIResponse{
+				Success:      false,
+				Message:      fmt.Sprintf("Nothing was deleted: snapshot failed: %v", err),
+				Resources:    taken.Summaries,
+				ResourceRefs: taken.Refs,
+			})
+			return
+		}
+	}
+
+	taken := snapshotSummary(req.Namespace, snapshots)
+	results, err := deleteManagedResources(r.Context(), clientSet, req.Namespace, selector, req.keepData())
+	if err != nil {
+		log.Printf("[ERROR] Failed to delete managed resources: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success:      false,
+			Message:      err.Error(),
+			Resources:    taken.Summaries,
+			ResourceRefs: taken.Refs,
+			Deleted:      results,
+		})
+		return
+	}
+	if stack != nil && !req.keepData() {
+		if res, ok := deleteSharedVolumeData(r.Context(), clientSet, req.Namespace, *stack); ok {
+			results = append(results, res)
+		}
+	}
+
+	failed := 0
+	for _, res := range results {
+		if !res.Deleted {
+			failed++
+		}
+	}
+	if failed > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success:      false,
+			Message:      fmt.Sprintf("%d of %d resource(s) could not be deleted", failed, len(results)),
+			Resources:    taken.Summaries,
+			ResourceRefs: taken.Refs,
+			Deleted:      results,
+		})
+		return
+	}
+	respondJSON(w, APIResponse{
+		Success:      true,
+		Message:      deletedMessage(len(results), req),
+		Resources:    taken.Summaries,
+		ResourceRefs: taken.Refs,
+		Deleted:      results,
+	})
+}
+
+// deletedMessage summarizes a successful delete, pointing out the data kept.
+func deletedMessage(count int, req DeleteRequest) string {
+	message := fmt.Sprintf("Deleted %d resource(s) from namespace %s", count, req.Namespace)
+	if req.keepData() {
+		message += "; PVs, PVCs and database credentials were kept (set keep_data to false to delete them)"
+	}
+	return message
+}
+
+// snapshotSummary lists the snapshots taken before a delete, in the style of Resources.
+func snapshotSummary(namespace string, snapshots []string) *resourceList {
+	summary := newResourceList(namespace)
+	for _, name := range snapshots {
+		summary.add("VolumeSnapshot", name)
+	}
+	return summary
+}
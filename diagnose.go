@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Verdicts of a Diagnosis, from best to worst.
+const (
+	verdictHealthy  = "healthy"
+	verdictDegraded = "degraded"
+	verdictBroken   = "broken"
+)
+
+// Severities of a Finding.
+const (
+	severityOK      = "ok"
+	severityWarning = "warning"
+	severityError   = "error"
+)
+
+// diagnoseEventWindow is how far back warning events are reported.
+const diagnoseEventWindow = time.Hour
+
+// maxDiagnoseEvents caps the warning events reported, newest first.
+const maxDiagnoseEvents = 10
+
+// Diagnosis is the outcome of every check /diagnose ran on a stack.
+type Diagnosis struct {
+	Verdict  string       `json:"verdict"` // healthy, degraded (warnings only) or broken
+	Findings []Finding    `json:"findings"`
+	Status   *StackStatus `json:"status,omitempty"` // Omitted for stacks using an external database
+}
+
+// Finding is the result of one check, e.g. the endpoints of one Service.
+type Finding struct {
+	Check    string `json:"check"`    // pods, pvc, endpoints, events or http
+	Severity string `json:"severity"` // ok, warning or error
+	Message  string `json:"message"`
+}
+
+// add records the finding of one check.
+func (d *Diagnosis) add(check, severity, format string, args ...any) {
+	d.Findings = append(d.Findings, Finding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// handleDiagnose runs every health check on the stack identified by the query parameters, as
+// for /status, and answers with the findings and an overall verdict.
+func handleDiagnose(w http.ResponseWriter, r *http.Request) {
+	namespace, names, err := stackFromQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	clientSet, err := InitKubeClient(r.URL.Query().Get("kubeconfig"), r.URL.Query().Get("context"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	report, err := diagnoseStack(r.Context(), clientSet, namespace, names)
+	if errors.Is(err, errStackNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("No stack %s found in namespace %s", names.ID(), namespace),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to diagnose stack: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success:   report.Verdict == verdictHealthy,
+		Message:   fmt.Sprintf("Stack %s is %s", names.ID(), report.Verdict),
+		Diagnosis: report,
+	})
+}
+
+// diagnoseStack checks the stack's pods, claims, Service endpoints, recent warning events and
+// whether WordPress answers HTTP through its Service. Only a missing WordPress deployment or a
+// failing API call is an error; everything else the checks find ends up in the findings.
+func diagnoseStack(ctx context.Context, clientSet *kubernetes.Clientset, namespace string, names stackNames) (*Diagnosis, error) {
+	d := &Diagnosis{}
+
+	wp, err := getComponentStatus(ctx, clientSet, namespace, names.WPDeployment)
+	if err != nil {
+		return nil, err
+	}
+	diagnoseComponent(d, "WordPress", wp)
+	services := []string{names.WPService}
+
+	// Without a MySQL deployment the stack uses an external database.
+	db, err := getComponentStatus(ctx, clientSet, namespace, names.DBDeployment)
+	switch {
+	case errors.Is(err, errStackNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		diagnoseComponent(d, "MySQL", db)
+		d.Status = &StackStatus{WordPress: wp, MySQL: db}
+		services = append(services, names.DBService)
+	}
+
+	if err := diagnoseClaims(ctx, d, clientSet, namespace, names); err != nil {
+		return nil, err
+	}
+	for _, svcName := range services {
+		if err := diagnoseEndpoints(ctx, d, clientSet, namespace, svcName); err != nil {
+			return nil, err
+		}
+	}
+	if err := diagnoseEvents(ctx, d, clientSet, namespace, names); err != nil {
+		return nil, err
+	}
+	diagnoseHTTP(ctx, d, clientSet, namespace, names.WPService)
+
+	d.Verdict = verdictHealthy
+	for _, f := range d.Findings {
+		if f.Severity == severityError {
+			d.Verdict = verdictBroken
+			break
+		}
+		if f.Severity == severityWarning {
+			d.Verdict = verdictDegraded
+		}
+	}
+	return d, nil
+}
+
+// diagnoseComponent reports a deployment without ready pods as an error, and unready or
+// restarting pods of an otherwise serving deployment as warnings.
+func diagnoseComponent(d *Diagnosis, what string, status ComponentStatus) {
+	if status.ReadyReplicas == 0 {
+		d.add("pods", severityError, "%s deployment %s has no ready pods", what, status.Deployment)
+	} else {
+		d.add("pods", severityOK, "%s deployment %s has %d/%d ready pods", what, status.Deployment,
+			status.ReadyReplicas, status.Replicas)
+	}
+	for _, pod := range status.Pods {
+		if pod.Ready && pod.Reason == "" {
+			continue
+		}
+		message := fmt.Sprintf("pod %s is %s", pod.Name, pod.Phase)
+		if !pod.Ready {
+			message += ", not ready"
+		}
+		if pod.Reason != "" {
+			message += fmt.Sprintf(" (%s, %d restarts)", pod.Reason, pod.Restarts)
+		}
+		d.add("pods", severityWarning, "%s", message)
+	}
+}
+
+// diagnoseClaims reports every claim of the stack that is not Bound. A claim waiting for its
+// first consumer only binds once a pod is scheduled, so the pod findings cover it.
+func diagnoseClaims(ctx context.Context, d *Diagnosis, clientSet *kubernetes.Clientset, namespace string, names stackNames) error {
+	selector := labels.Set{managedByLabel: managedByValue, stackLabel: names.ID()}.String()
+	claims, err := clientSet.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("unable to list PVCs: %w", err)
+	}
+	for _, pvc := range claims.Items {
+		if pvc.Status.Phase == corev1.ClaimBound {
+			d.add("pvc", severityOK, "PVC %s is Bound to %s", pvc.Name, pvc.Spec.VolumeName)
+			continue
+		}
+		message := fmt.Sprintf("PVC %s is %s", pvc.Name, pvc.Status.Phase)
+		if events, err := pvcEvents(ctx, clientSet, &pvc); err == nil && len(events) > 0 {
+			message += ": " + events[len(events)-1]
+		}
+		d.add("pvc", severityError, "%s", message)
+	}
+	return nil
+}
+
+// diagnoseEndpoints reports a Service without ready endpoints, which no traffic can reach.
+func diagnoseEndpoints(ctx context.Context, d *Diagnosis, clientSet *kubernetes.Clientset, namespace, svcName string) error {
+	endpoints, err := clientSet.CoreV1().Endpoints(namespace).Get(ctx, svcName, metaV1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		d.add("endpoints", severityError, "Service %s does not exist", svcName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get endpoints of %s: %w", svcName, err)
+	}
+	ready, notReady := 0, 0
+	for _, subset := range endpoints.Subsets {
+		ready += len(subset.Addresses)
+		notReady += len(subset.NotReadyAddresses)
+	}
+	switch {
+	case ready == 0:
+		d.add("endpoints", severityError, "Service %s has no ready endpoints (%d not ready)", svcName, notReady)
+	case notReady > 0:
+		d.add("endpoints", severityWarning, "Service %s has %d ready and %d not ready endpoints", svcName, ready, notReady)
+	default:
+		d.add("endpoints", severityOK, "Service %s has %d ready endpoints", svcName, ready)
+	}
+	return nil
+}
+
+// diagnoseEvents reports the namespace's recent warning events about the stack's objects,
+// whose names all start with the stack's deployment name prefix and end in its suffix or a
+// generated pod or ReplicaSet suffix.
+func diagnoseEvents(ctx context.Context, d *Diagnosis, clientSet *kubernetes.Clientset, namespace string, names stackNames) error {
+	list, err := clientSet.CoreV1().Events(namespace).List(ctx, metaV1.ListOptions{FieldSelector: "type=" + corev1.EventTypeWarning})
+	if err != nil {
+		return fmt.Errorf("unable to list events: %w", err)
+	}
+	prefixes := []string{names.DBDeployment, names.DBPVC, names.WPDeployment, names.WPPVC, names.PMADeployment, names.RedisDeployment}
+	since := time.Now().Add(-diagnoseEventWindow)
+
+	var events []corev1.Event
+	for _, event := range list.Items {
+		if event.LastTimestamp.Time.Before(since) {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(event.InvolvedObject.Name, prefix) {
+				events = append(events, event)
+				break
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[j].LastTimestamp.Before(&events[i].LastTimestamp)
+	})
+	if len(events) > maxDiagnoseEvents {
+		events = events[:maxDiagnoseEvents]
+	}
+	for _, event := range events {
+		d.add("events", severityWarning, "%s %s: %s: %s (x%d)", event.InvolvedObject.Kind, event.InvolvedObject.Name,
+			event.Reason, event.Message, event.Count)
+	}
+	// With host_path_type Directory the kubelet refuses to create a missing directory.
+	for _, event := range events {
+		if event.Reason == "FailedMount" && strings.Contains(event.Message, hostPathTypeCheckFailed) {
+			d.add("events", severityError, "Pod %s cannot mount its hostPath volume: the directory must exist on the node "+
+				"when host_path_type is Directory; create it there, or deploy with DirectoryOrCreate", event.InvolvedObject.Name)
+			break
+		}
+	}
+	return nil
+}
+
+// hostPathTypeCheckFailed is how the kubelet reports a hostPath that does not match its type.
+const hostPathTypeCheckFailed = "hostPath type check failed"
+
+// diagnoseHTTP requests the site's front page through the API server's Service proxy, which
+// works wherever the deployer runs. Any answer below 500 means WordPress is serving; a 500 is
+// typically "Error establishing a database connection".
+func diagnoseHTTP(ctx context.Context, d *Diagnosis, clientSet *kubernetes.Clientset, namespace, svcName string) {
+	svc, err := clientSet.CoreV1().Services(namespace).Get(ctx, svcName, metaV1.GetOptions{})
+	if err != nil || len(svc.Spec.Ports) == 0 {
+		d.add("http", severityError, "Service %s cannot be probed", svcName)
+		return
+	}
+	port := strconv.Itoa(int(svc.Spec.Ports[0].Port))
+
+	probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var code int
+	result := clientSet.CoreV1().RESTClient().Get().
+		Namespace(namespace).Resource("services").Name("http:" + svcName + ":" + port).SubResource("proxy").
+		Do(probeCtx)
+	result.StatusCode(&code)
+	switch {
+	case code == 0:
+		d.add("http", severityError, "WordPress did not answer through Service %s: %v", svcName, result.Error())
+	case code >= 500:
+		d.add("http", severityError, "WordPress answered HTTP %d through Service %s", code, svcName)
+	default:
+		d.add("http", severityOK, "WordPress answered HTTP %d through Service %s", code, svcName)
+	}
+}
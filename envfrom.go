@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EnvSource loads every key of an existing ConfigMap or Secret as environment variables of the
+// WordPress container, e.g. SMTP credentials or cache settings. Exactly one of ConfigMap and
+// Secret is set; Prefix is prepended to each variable name.
+type EnvSource struct {
+	ConfigMap string `json:"config_map,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+}
+
+// source returns the kind and name of the object the variables come from.
+func (s EnvSource) source() (string, string) {
+	if s.Secret != "" {
+		return "Secret", s.Secret
+	}
+	return "ConfigMap", s.ConfigMap
+}
+
+// validateEnvSource checks one extra_env_from entry on its own.
+func validateEnvSource(s EnvSource) error {
+	if (s.ConfigMap == "") == (s.Secret == "") {
+		return errors.New("set exactly one of config_map and secret")
+	}
+	_, name := s.source()
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q: %s", name, strings.Join(errs, "; "))
+	}
+	if s.Prefix != "" {
+		if errs := validation.IsEnvVarName(s.Prefix); len(errs) > 0 {
+			return fmt.Errorf("invalid prefix %q: %s", s.Prefix, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// extraEnvFrom maps the extra_env_from entries to EnvFrom sources. They follow the stack's own
+// Secret, so on a duplicate key they win; variables set in Env still take precedence.
+func extraEnvFrom(sources []EnvSource) []corev1.EnvFromSource {
+	envFrom := make([]corev1.EnvFromSource, 0, len(sources))
+	for _, s := range sources {
+		envSource := corev1.EnvFromSource{Prefix: s.Prefix}
+		if s.Secret != "" {
+			envSource.SecretRef = &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: s.Secret},
+			}
+		} else {
+			envSource.ConfigMapRef = &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: s.ConfigMap},
+			}
+		}
+		envFrom = append(envFrom, envSource)
+	}
+	return envFrom
+}
+
+// envCollisionWarnings describes every variable of the WordPress container that more than one
+// source sets: the stack's Secret, the extra_env_from entries and the variables set directly.
+// Kubernetes silently lets the last EnvFrom source win and Env beat them all, so e.g. a Secret
+// of SMTP settings that also holds WORDPRESS_DB_HOST would quietly repoint the site.
+func envCollisionWarnings(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) ([]string, error) {
+
+	secret, err := buildWPMySQLSecret(payload, names)
+	if err != nil {
+		return nil, err
+	}
+	container := buildWordPressDeployment(payload, names).Spec.Template.Spec.Containers[0]
+
+	// Sources of each variable, in increasing precedence.
+	setBy := map[string][]string{}
+	for _, envFrom := range container.EnvFrom {
+		var keys []string
+		var source string
+		switch {
+		case envFrom.SecretRef != nil && envFrom.SecretRef.Name == names.DBSecret:
+			source = "the stack's Secret " + names.DBSecret
+			for key := range secret.Data {
+				keys = append(keys, key)
+			}
+		case envFrom.SecretRef != nil:
+			source = "Secret " + envFrom.SecretRef.Name
+			s, err := clientSet.CoreV1().Secrets(payload.Namespace).Get(ctx, envFrom.SecretRef.Name, metaV1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("unable to read Secret %s: %w", envFrom.SecretRef.Name, err)
+			}
+			for key := range s.Data {
+				keys = append(keys, key)
+			}
+		case envFrom.ConfigMapRef != nil:
+			source = "ConfigMap " + envFrom.ConfigMapRef.Name
+			cm, err := clientSet.CoreV1().ConfigMaps(payload.Namespace).Get(ctx, envFrom.ConfigMapRef.Name, metaV1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("unable to read ConfigMap %s: %w", envFrom.ConfigMapRef.Name, err)
+			}
+			for key := range cm.Data {
+				keys = append(keys, key)
+			}
+		}
+		if envFrom.Prefix != "" {
+			source += fmt.Sprintf(" (prefix %s)", envFrom.Prefix)
+		}
+		for _, key := range keys {
+			setBy[envFrom.Prefix+key] = append(setBy[envFrom.Prefix+key], source)
+		}
+	}
+	for _, env := range container.Env {
+		setBy[env.Name] = append(setBy[env.Name], "the deployer's own env")
+	}
+
+	var warnings []string
+	for name, sources := range setBy {
+		if len(sources) < 2 {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s is set by %s; %s wins, set a prefix on extra_env_from to keep both",
+			name, strings.Join(sources, " and "), sources[len(sources)-1]))
+	}
+	sort.Strings(warnings)
+	return warnings, nil
+}
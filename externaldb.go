@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultExternalDBPort is the MySQL port assumed when external_database.port is not given.
+const defaultExternalDBPort = 3306
+
+// externalDBCheckScript logs in to the external database and runs a trivial query, so both
+// network reachability and the credentials are checked. The password comes from MYSQL_PWD.
+// With a CA certificate, DB_SSL_CA points at it and TLS is required.
+const externalDBCheckScript = `mysql --connect-timeout=10 -h "$DB_HOST" -P "$DB_PORT" -u "$DB_USER" \
+  ${DB_SSL_CA:+--ssl-ca="$DB_SSL_CA" --ssl-mode=VERIFY_CA} -e 'SELECT 1' "$DB_NAME"`
+
+// dbCAMountPath is where the database CA certificate is mounted, as dbCAKey, in every pod
+// that talks to the database, next to dbCAPHPIniKey, a php.ini snippet pointing PHP at it.
+const (
+	dbCAMountPath = "/etc/wp-db-ca"
+	dbCAKey       = "ca.pem"
+	dbCAPHPIniKey = "db-ca.ini"
+)
+
+// dbCAPHPIni sets the CA as PHP's default for TLS peer verification. Core wpdb never calls
+// mysqli_ssl_set, so mysqlnd verifies the server against openssl.cafile, not MYSQL_SSL_CA.
+const dbCAPHPIni = "openssl.cafile=" + dbCAMountPath + "/" + dbCAKey + "\n"
+
+// dbTLSConfig makes WordPress connect to MySQL over TLS: wpdb passes MYSQL_CLIENT_FLAGS to
+// mysqli_real_connect, and the server is checked against the CA set by dbCAPHPIni.
+// MYSQL_SSL_CA is only read by TLS-aware db.php drop-ins.
+const dbTLSConfig = `define('MYSQL_CLIENT_FLAGS', MYSQLI_CLIENT_SSL);
+define('MYSQL_SSL_CA', '` + dbCAMountPath + "/" + dbCAKey + `');`
+
+// ExternalDatabase points WordPress at an existing MySQL-compatible server instead of
+// deploying one, e.g. a managed cloud database.
+type ExternalDatabase struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"` // Defaults to 3306
+	Name     string `json:"name"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// buildExternalDBCheckJob returns a Job that connects to the external database once with the
+// stack's credentials. It uses the MySQL image only for its client.
+func buildExternalDBCheckJob(payload RequestPayload, names stackNames) *batchv1.Job {
+	ext := payload.ExternalDatabase
+	secretKey := func(name, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: names.DBSecret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.DBCheckJob,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.DBCheckJob, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
+		},
+		Spec: batchv1.JobSpec{
+			// A single retry absorbs a slow DNS or network start; more would only delay the answer.
+			BackoffLimit:            int32Ptr(1),
+			TTLSecondsAfterFinished: int32Ptr(600),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      stackLabels(names.DBCheckJob, names, componentDatabase),
+					Annotations: podAnnotations(payload),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadJob),
+					Containers: []corev1.Container{
+						{
+							Name:            "db-check",
+							Image:           payload.MySQLImage,
+							ImagePullPolicy: imagePullPolicy(payload, payload.MySQLImage),
+							Command:         []string{"sh", "-c", externalDBCheckScript},
+							Env: []corev1.EnvVar{
+								{Name: "DB_HOST", Value: ext.Host},
+								{Name: "DB_PORT", Value: strconv.Itoa(ext.Port)},
+								secretKey("DB_USER", "WORDPRESS_DB_USER"),
+								secretKey("DB_NAME", "WORDPRESS_DB_NAME"),
+								secretKey("MYSQL_PWD", "WORDPRESS_DB_PASSWORD"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	applyDNS(&job.Spec.Template.Spec, payload)
+	applyServiceAccount(&job.Spec.Template.Spec, payload, names)
+	if mountDBCACert(&job.Spec.Template.Spec, payload, names) {
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "DB_SSL_CA", Value: dbCAMountPath + "/" + dbCAKey})
+	}
+	return job
+}
+
+// checkExternalDatabase runs the connection check Job and waits for its verdict.
+func checkExternalDatabase(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, timeout time.Duration) error {
+
+	job := buildExternalDBCheckJob(payload, names)
+	_, err := clientSet.BatchV1().Jobs(payload.Namespace).Create(ctx, job, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create check job %s: %w", names.DBCheckJob, err)
+	}
+	return waitForJobComplete(ctx, clientSet, payload.Namespace, names.DBCheckJob, timeout)
+}
+
+// validateCACert checks that pem holds at least one certificate and nothing else.
+func validateCACert(data string) error {
+	rest := []byte(data)
+	count := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+		count++
+	}
+	if count == 0 || len(bytes.TrimSpace(rest)) > 0 {
+		return errors.New("expected one or more PEM-encoded certificates")
+	}
+	return nil
+}
+
+// buildDBCASecret returns the Secret holding the external database's CA certificate.
+func buildDBCASecret(payload RequestPayload, names stackNames) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.DBCASecret,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.DBCASecret, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			dbCAKey:       []byte(payload.DBCACert),
+			dbCAPHPIniKey: []byte(dbCAPHPIni),
+		},
+	}
+}
+
+// createDBCASecret creates the database CA certificate Secret.
+func createDBCASecret(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	secret := buildDBCASecret(payload, names)
+	_, err := clientSet.CoreV1().Secrets(payload.Namespace).Create(ctx, secret, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create secret %s: %w", names.DBCASecret, err)
+	}
+	return nil
+}
+
+// mountDBCACert mounts the database CA certificate read-only into every container of spec,
+// reporting whether there was one to mount. PHP_INI_SCAN_DIR adds the mount to the official
+// images' conf.d, so PHP loads dbCAPHPIni; the leading ":" keeps the built-in directory.
+func mountDBCACert(spec *corev1.PodSpec, payload RequestPayload, names stackNames) bool {
+	if payload.DBCACert == "" {
+		return false
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "db-ca",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: names.DBCASecret},
+		},
+	})
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      "db-ca",
+			MountPath: dbCAMountPath,
+			ReadOnly:  true,
+		})
+		spec.Containers[i].Env = append(spec.Containers[i].Env, corev1.EnvVar{Name: "PHP_INI_SCAN_DIR", Value: ":" + dbCAMountPath})
+	}
+	return true
+}
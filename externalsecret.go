@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Values of secret_mode.
+const (
+	secretModeManaged        = "managed"
+	secretModeExternalSecret = "external-secret"
+)
+
+// secretModes are the values of secret_mode.
+var secretModes = []string{secretModeManaged, secretModeExternalSecret}
+
+// externalSecretsGroupVersion is the API of the external-secrets operator, which is installed
+// separately and has no typed client, so ExternalSecrets are sent unstructured over REST.
+const externalSecretsGroupVersion = "external-secrets.io/v1beta1"
+
+// secretStoreKinds are the values of external_secret.secret_store_kind.
+var secretStoreKinds = []string{"SecretStore", "ClusterSecretStore"}
+
+// defaultExternalSecretRefresh is how often the operator re-reads the remote key by default.
+const defaultExternalSecretRefresh = "1h"
+
+// externalSecretSyncTimeout bounds the wait for the operator to create the stack's Secret.
+const externalSecretSyncTimeout = 2 * time.Minute
+
+// errExternalSecretNotSynced means the operator did not produce a usable database Secret.
+var errExternalSecretNotSynced = errors.New("ExternalSecret did not sync")
+
+// ExternalSecretConfig points the stack's database Secret at a key of an external-secrets
+// SecretStore. The remote key must hold every property the generated Secret would have, e.g.
+// MYSQL_ROOT_PASSWORD and WORDPRESS_DB_PASSWORD; the deploy fails with 502 if some are missing.
+type ExternalSecretConfig struct {
+	SecretStore     string `json:"secret_store"`
+	SecretStoreKind string `json:"secret_store_kind,omitempty"` // "SecretStore" (default) or "ClusterSecretStore"
+	Key             string `json:"key"`                         // Remote key whose properties become the Secret's keys
+	RefreshInterval string `json:"refresh_interval,omitempty"`  // Defaults to "1h"
+}
+
+// externalSecretsInstalled reports whether the cluster serves the external-secrets API.
+func externalSecretsInstalled(clientSet *kubernetes.Clientset) (bool, error) {
+	_, err := clientSet.Discovery().ServerResourcesForGroupVersion(externalSecretsGroupVersion)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// externalSecretsPath returns the REST path of the ExternalSecrets in a namespace, or of one of them.
+func externalSecretsPath(namespace, name string) string {
+	path := "/apis/" + externalSecretsGroupVersion + "/namespaces/" + namespace + "/externalsecrets"
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// buildStackExternalSecret returns an ExternalSecret that makes the operator write the remote
+// key to the stack's database Secret. The Secret is orphaned rather than owned, so deleting the
+// ExternalSecret leaves it to keep_data like a generated one, and carries the stack's labels.
+func buildStackExternalSecret(payload RequestPayload, names stackNames) *unstructured.Unstructured {
+	es := payload.ExternalSecret
+	secretLabels := map[string]any{}
+	for k, v := range stackLabels(names.DBSecret, names, componentDatabase) {
+		secretLabels[k] = v
+	}
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": externalSecretsGroupVersion,
+		"kind":       "ExternalSecret",
+		"spec": map[string]any{
+			"refreshInterval": es.RefreshInterval,
+			"secretStoreRef":  map[string]any{"name": es.SecretStore, "kind": es.SecretStoreKind},
+			"target": map[string]any{
+				"name":           names.DBSecret,
+				"creationPolicy": "Orphan",
+				"template": map[string]any{
+					"metadata": map[string]any{"labels": secretLabels},
+				},
+			},
+			"dataFrom": []any{
+				map[string]any{"extract": map[string]any{"key": es.Key}},
+			},
+		},
+	}}
+	obj.SetName(names.DBSecret)
+	obj.SetNamespace(payload.Namespace)
+	obj.SetLabels(stackLabels(names.DBSecret, names, componentDatabase))
+	obj.SetAnnotations(stackAnnotations(payload))
+	return obj
+}
+
+// createStackExternalSecret creates the ExternalSecret described by buildStackExternalSecret.
+func createStackExternalSecret(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	body, err := buildStackExternalSecret(payload, names).MarshalJSON()
+	if err != nil {
+		return err
+	}
+	err = clientSet.Discovery().RESTClient().Post().AbsPath(externalSecretsPath(payload.Namespace, "")).
+		SetHeader("Content-Type", "application/json").Body(body).Do(ctx).Error()
+	if err != nil {
+		return fmt.Errorf("unable to create ExternalSecret %s: %w", names.DBSecret, err)
+	}
+	return nil
+}
+
+// getExternalSecret fetches an ExternalSecret, returning a NotFound error when it does not exist.
+func getExternalSecret(ctx context.Context, clientSet *kubernetes.Clientset, namespace, name string) error {
+	return clientSet.Discovery().RESTClient().Get().AbsPath(externalSecretsPath(namespace, name)).Do(ctx).Error()
+}
+
+// listExternalSecrets returns the names of the ExternalSecrets matching selector, and none
+// when the cluster does not serve the external-secrets API.
+func listExternalSecrets(ctx context.Context, clientSet *kubernetes.Clientset, namespace, selector string) ([]string, error) {
+	return listCustomResourceNames(ctx, clientSet, externalSecretsPath(namespace, ""), selector)
+}
+
+// deleteExternalSecret deletes an ExternalSecret; the Secret it synced is left in place.
+func deleteExternalSecret(ctx context.Context, clientSet *kubernetes.Clientset, namespace, name string) error {
+	return clientSet.Discovery().RESTClient().Delete().AbsPath(externalSecretsPath(namespace, name)).Do(ctx).Error()
+}
+
+// waitForExternalSecret waits for the operator to write the stack's database Secret and checks
+// it has every key the generated one would. When it doesn't appear in time, the error carries
+// the ExternalSecret's own explanation, typically a store or key that can't be read.
+func waitForExternalSecret(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	secrets := clientSet.CoreV1().Secrets(payload.Namespace)
+	var keys map[string][]byte
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, externalSecretSyncTimeout, true, func(ctx context.Context) (bool, error) {
+		secret, err := secrets.Get(ctx, names.DBSecret, metaV1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		keys = secret.Data
+		return true, nil
+	})
+	if isContextError(ctx.Err()) {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: secret %s did not appear within %s%s", errExternalSecretNotSynced,
+			names.DBSecret, externalSecretSyncTimeout, externalSecretCondition(ctx, clientSet, payload.Namespace, names.DBSecret))
+	}
+
+	required, err := buildWPMySQLSecret(payload, names)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for key := range required.Data {
+		if _, ok := keys[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w: remote key %s lacks %s", errExternalSecretNotSynced,
+			payload.ExternalSecret.Key, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// externalSecretCondition returns the message of the ExternalSecret's Ready condition as
+// "; <message>", or "" when there is none to report.
+func externalSecretCondition(ctx context.Context, clientSet *kubernetes.Clientset, namespace, name string) string {
+	raw, err := clientSet.Discovery().RESTClient().Get().AbsPath(externalSecretsPath(namespace, name)).DoRaw(ctx)
+	if err != nil {
+		return ""
+	}
+	var es struct {
+		Status struct {
+			Conditions []struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if json.Unmarshal(raw, &es) != nil {
+		return ""
+	}
+	for _, cond := range es.Status.Conditions {
+		if cond.Type == "Ready" && cond.Message != "" {
+			return "; " + cond.Message
+		}
+	}
+	return ""
+}
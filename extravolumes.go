@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExtraVolume mounts an existing ConfigMap or Secret into the WordPress container, e.g. a custom
+// wp-config.php (with SubPath) or TLS certificates. Exactly one of ConfigMap and Secret is set.
+type ExtraVolume struct {
+	ConfigMap string `json:"config_map,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	MountPath string `json:"mount_path"`
+	SubPath   string `json:"sub_path,omitempty"` // Mounts a single key as a file at MountPath
+}
+
+// source returns the kind and name of the object the volume comes from.
+func (v ExtraVolume) source() (string, string) {
+	if v.Secret != "" {
+		return "Secret", v.Secret
+	}
+	return "ConfigMap", v.ConfigMap
+}
+
+// validateExtraVolume checks one extra_volumes entry on its own.
+func validateExtraVolume(v ExtraVolume) error {
+	if (v.ConfigMap == "") == (v.Secret == "") {
+		return errors.New("set exactly one of config_map and secret")
+	}
+	_, name := v.source()
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q: %s", name, strings.Join(errs, "; "))
+	}
+	if !path.IsAbs(v.MountPath) {
+		return fmt.Errorf("mount_path must be an absolute path, got %q", v.MountPath)
+	}
+	return nil
+}
+
+// applyExtraVolumes adds the requested volumes to the pod and mounts them read-only into the
+// WordPress container, which is always the first one.
+func applyExtraVolumes(spec *corev1.PodSpec, volumes []ExtraVolume) {
+	for i, v := range volumes {
+		name := fmt.Sprintf("extra-%d", i)
+		volume := corev1.Volume{Name: name}
+		if v.Secret != "" {
+			volume.Secret = &corev1.SecretVolumeSource{SecretName: v.Secret}
+		} else {
+			volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: v.ConfigMap},
+			}
+		}
+		spec.Volumes = append(spec.Volumes, volume)
+		spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: path.Clean(v.MountPath),
+			SubPath:   v.SubPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// checkExtraVolumes makes sure every referenced ConfigMap and Secret exists, since a missing one
+// would only show up as pods stuck in ContainerCreating.
+func checkExtraVolumes(ctx context.Context, clientSet *kubernetes.Clientset, namespace string, volumes []ExtraVolume) error {
+	for _, v := range volumes {
+		kind, name := v.source()
+		if err := checkReferencedObject(ctx, clientSet, namespace, kind, name, "extra_volumes"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExtraEnvFrom makes sure every ConfigMap and Secret of extra_env_from exists; a missing
+// one keeps the WordPress pods in CreateContainerConfigError.
+func checkExtraEnvFrom(ctx context.Context, clientSet *kubernetes.Clientset, namespace string, sources []EnvSource) error {
+	for _, s := range sources {
+		kind, name := s.source()
+		if err := checkReferencedObject(ctx, clientSet, namespace, kind, name, "extra_env_from"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReferencedObject looks up a ConfigMap or Secret the request field refers to.
+func checkReferencedObject(ctx context.Context, clientSet *kubernetes.Clientset, namespace, kind, name, field string) error {
+	var err error
+	if kind == "Secret" {
+		_, err = clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metaV1.GetOptions{})
+	} else {
+		_, err = clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metaV1.GetOptions{})
+	}
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%s %s/%s referenced by %s does not exist", kind, namespace, name, field)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read %s %s/%s: %w", kind, namespace, name, err)
+	}
+	return nil
+}
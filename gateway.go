@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// gatewayGroupVersion is the Gateway API; its CRDs are installed separately from Kubernetes and
+// client-go has no typed client for it, so HTTPRoutes are built unstructured and sent over REST.
+const gatewayGroupVersion = "gateway.networking.k8s.io/v1"
+
+// GatewayConfig attaches the WordPress Service to an existing Gateway through an HTTPRoute.
+type GatewayConfig struct {
+	GatewayName string `json:"gateway_name"`
+	Namespace   string `json:"namespace,omitempty"` // The Gateway's namespace; defaults to the stack's
+	Hostname    string `json:"hostname,omitempty"`  // Host the route answers for, e.g. "blog.example.com" or "*.example.com"
+}
+
+// hostnameMatches reports whether host is one an HTTPRoute with the given hostname answers for.
+// As in the Gateway API, "*.example.com" matches any host below example.com, but not itself.
+func hostnameMatches(hostname, host string) bool {
+	hostname, host = strings.ToLower(hostname), strings.ToLower(host)
+	if suffix, ok := strings.CutPrefix(hostname, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return hostname == host
+}
+
+// gatewayAPIInstalled reports whether the cluster serves the Gateway API.
+func gatewayAPIInstalled(clientSet *kubernetes.Clientset) (bool, error) {
+	_, err := clientSet.Discovery().ServerResourcesForGroupVersion(gatewayGroupVersion)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// httpRoutesPath returns the REST path of the HTTPRoutes in a namespace, or of one of them.
+func httpRoutesPath(namespace, name string) string {
+	path := "/apis/" + gatewayGroupVersion + "/namespaces/" + namespace + "/httproutes"
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// buildWordPressHTTPRoute returns an HTTPRoute sending every request the Gateway accepts for
+// the hostname to the WordPress Service. The Gateway must allow routes from the stack's
+// namespace when it lives in another one.
+func buildWordPressHTTPRoute(payload RequestPayload, names stackNames) *unstructured.Unstructured {
+	gw := payload.Gateway
+	parentRef := map[string]any{"name": gw.GatewayName}
+	if gw.Namespace != "" {
+		parentRef["namespace"] = gw.Namespace
+	}
+	spec := map[string]any{
+		"parentRefs": []any{parentRef},
+		"rules": []any{
+			map[string]any{
+				"backendRefs": []any{
+					map[string]any{"name": names.WPService, "port": int64(payload.ServicePort)},
+				},
+			},
+		},
+	}
+	if gw.Hostname != "" {
+		spec["hostnames"] = []any{gw.Hostname}
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": gatewayGroupVersion,
+		"kind":       "HTTPRoute",
+		"spec":       spec,
+	}}
+	route.SetName(names.HTTPRoute)
+	route.SetNamespace(payload.Namespace)
+	route.SetLabels(stackLabels(names.HTTPRoute, names, componentWordPress))
+	route.SetAnnotations(stackAnnotations(payload))
+	return route
+}
+
+// createWordPressHTTPRoute creates the HTTPRoute described by buildWordPressHTTPRoute.
+func createWordPressHTTPRoute(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	body, err := buildWordPressHTTPRoute(payload, names).MarshalJSON()
+	if err != nil {
+		return err
+	}
+	err = clientSet.Discovery().RESTClient().Post().AbsPath(httpRoutesPath(payload.Namespace, "")).
+		SetHeader("Content-Type", "application/json").Body(body).Do(ctx).Error()
+	if err != nil {
+		return fmt.Errorf("unable to create HTTPRoute %s: %w", names.HTTPRoute, err)
+	}
+	return nil
+}
+
+// getHTTPRoute fetches an HTTPRoute, returning a NotFound error when it does not exist.
+func getHTTPRoute(ctx context.Context, clientSet *kubernetes.Clientset, namespace, name string) error {
+	return clientSet.Discovery().RESTClient().Get().AbsPath(httpRoutesPath(namespace, name)).Do(ctx).Error()
+}
+
+// listHTTPRoutes returns the names of the HTTPRoutes matching selector, and none when the
+// cluster does not serve the Gateway API.
+func listHTTPRoutes(ctx context.Context, clientSet *kubernetes.Clientset, namespace, selector string) ([]string, error) {
+	return listCustomResourceNames(ctx, clientSet, httpRoutesPath(namespace, ""), selector)
+}
+
+// listCustomResourceNames returns the names of the custom resources at a collection's REST
+// path matching selector, and none when the cluster does not serve their API.
+func listCustomResourceNames(ctx context.Context, clientSet *kubernetes.Clientset, path, selector string) ([]string, error) {
+	raw, err := clientSet.Discovery().RESTClient().Get().AbsPath(path).
+		Param("labelSelector", selector).DoRaw(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata metaV1.ObjectMeta `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Metadata.Name)
+	}
+	return names, nil
+}
+
+// deleteHTTPRoute deletes an HTTPRoute.
+func deleteHTTPRoute(ctx context.Context, clientSet *kubernetes.Clientset, namespace, name string) error {
+	return clientSet.Discovery().RESTClient().Delete().AbsPath(httpRoutesPath(namespace, name)).Do(ctx).Error()
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestHostnameMatches(t *testing.T) {
+	tests := []struct {
+		hostname, host string
+		want           bool
+	}{
+		{"blog.example.com", "blog.example.com", true},
+		{"Blog.Example.com", "blog.example.COM", true},
+		{"blog.example.com", "www.example.com", false},
+		{"*.example.com", "blog.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "blog.example.org", false},
+	}
+	for _, tt := range tests {
+		if got := hostnameMatches(tt.hostname, tt.host); got != tt.want {
+			t.Errorf("hostnameMatches(%q, %q) = %v, want %v", tt.hostname, tt.host, got, tt.want)
+		}
+	}
+}
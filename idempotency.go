@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader lets clients retry a create safely: a repeated key gets the original
+// response instead of a second stack.
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyRetention is how long a key and its response are remembered.
+const idempotencyRetention = 24 * time.Hour
+
+// maxIdempotencyKeyLength bounds the keys kept in memory.
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotencyKeys bounds how many keys are remembered at once; further keys are turned
+// away with 503 until old ones expire.
+const maxIdempotencyKeys = 10000
+
+// maxIdempotentBodyBytes bounds the request bodies read into memory to hash them.
+const maxIdempotentBodyBytes = 1 << 20
+
+// errTooManyIdempotencyKeys means no key can be claimed until some expire.
+var errTooManyIdempotencyKeys = errors.New("too many " + idempotencyHeader + " values in use")
+
+// idempotentResponse is the recorded outcome of the first request with a key. Body is the
+// response with its secrets removed, see recordableBody.
+type idempotentResponse struct {
+	BodyHash   [sha256.Size]byte // The request body, so a key reused for another request is caught
+	Done       bool
+	Status     int
+	Header     http.Header
+	Body       []byte
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+var (
+	idempotencyMu sync.Mutex
+	idempotency   = map[string]*idempotentResponse{}
+)
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// withIdempotency replays the recorded response when a request repeats the Idempotency-Key of
+// an earlier one, and rejects the repeat while the first is still running or when its body
+// differs. Requests without the header are passed through. Keys live in memory only, so they
+// are forgotten on restart and not shared between replicas of the deployer. Replays carry no
+// admin login or manifest: those are only in the first response.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			w.WriteHeader(http.StatusBadRequest)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: idempotencyHeader + " must be at most 255 characters",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: "Request body must be at most 1 MiB",
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to read request body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: "Could not read request body",
+			})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		entry, isNew, err := claimIdempotencyKey(key, hash)
+		if err != nil {
+			log.Printf("[WARN] %v", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: "Too many requests with an " + idempotencyHeader + " are remembered; retry later",
+			})
+			return
+		}
+		if !isNew {
+			replayIdempotentResponse(w, entry, hash)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		// A handler that panics never finishes the entry; forget the key so it is not stuck
+		// answering 409 until it expires.
+		finished := false
+		defer func() {
+			if !finished {
+				idempotencyMu.Lock()
+				delete(idempotency, key)
+				idempotencyMu.Unlock()
+			}
+		}()
+		next(rec, r)
+		finished = true
+
+		idempotencyMu.Lock()
+		defer idempotencyMu.Unlock()
+		if rec.status == statusClientClosedRequest {
+			// The deploy was rolled back and nobody saw the answer; let the retry run it again.
+			delete(idempotency, key)
+			return
+		}
+		entry.Done = true
+		entry.Status = rec.status
+		entry.Header = w.Header().Clone()
+		entry.Body = recordableBody(rec.body.Bytes())
+		entry.FinishedAt = time.Now()
+	}
+}
+
+// recordableBody returns the response body without the admin login and manifest, which hold
+// passwords that must not sit in memory for a day. A body that is not an APIResponse is not
+// kept at all; its replay has the status and headers only.
+func recordableBody(body []byte) []byte {
+	var resp APIResponse
+	if json.Unmarshal(body, &resp) != nil {
+		return nil
+	}
+	resp.WPAdmin, resp.WPAdminClaim, resp.Manifest = nil, nil, ""
+	if resp.Job != nil && resp.Job.Result != nil {
+		result := *resp.Job.Result
+		result.WPAdmin, result.WPAdminClaim, result.Manifest = nil, nil, ""
+		job := *resp.Job
+		job.Result = &result
+		resp.Job = &job
+	}
+	var buf bytes.Buffer
+	if json.NewEncoder(&buf).Encode(resp) != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// claimIdempotencyKey returns the entry of key and whether this request created it, pruning
+// expired entries on the way. Entries still in progress expire too, from when they started, so
+// a request that never finished cannot hold its key forever.
+func claimIdempotencyKey(key string, hash [sha256.Size]byte) (*idempotentResponse, bool, error) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+
+	for k, entry := range idempotency {
+		since := entry.StartedAt
+		if entry.Done {
+			since = entry.FinishedAt
+		}
+		if time.Since(since) > idempotencyRetention {
+			delete(idempotency, k)
+		}
+	}
+	if entry, ok := idempotency[key]; ok {
+		copied := *entry
+		return &copied, false, nil
+	}
+	if len(idempotency) >= maxIdempotencyKeys {
+		return nil, false, errTooManyIdempotencyKeys
+	}
+	entry := &idempotentResponse{BodyHash: hash, StartedAt: time.Now()}
+	idempotency[key] = entry
+	return entry, true, nil
+}
+
+// replayIdempotentResponse answers a repeated key from its recorded entry.
+func replayIdempotentResponse(w http.ResponseWriter, entry *idempotentResponse, hash [sha256.Size]byte) {
+	switch {
+	case entry.BodyHash != hash:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: idempotencyHeader + " was already used with a different request body",
+		})
+	case !entry.Done:
+		w.WriteHeader(http.StatusConflict)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "A request with this " + idempotencyHeader + " is still in progress; retry later",
+		})
+	default:
+		log.Printf("[INFO] Replaying response for repeated %s", idempotencyHeader)
+		for name, values := range entry.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(entry.Status)
+		_, _ = w.Write(entry.Body)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func idempotentRequest(key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/create-wordpress", strings.NewReader(body))
+	r.Header.Set(idempotencyHeader, key)
+	return r
+}
+
+func TestIdempotencyForgetsKeyAfterPanic(t *testing.T) {
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+	func() {
+		defer func() { _ = recover() }()
+		handler(httptest.NewRecorder(), idempotentRequest("panic-key", "{}"))
+	}()
+
+	idempotencyMu.Lock()
+	_, kept := idempotency["panic-key"]
+	idempotencyMu.Unlock()
+	if kept {
+		t.Fatal("key of a panicked request is still claimed")
+	}
+}
+
+func TestIdempotencyReplayOmitsAdminLogin(t *testing.T) {
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, APIResponse{
+			Success: true,
+			Suffix:  "abc12",
+			WPAdmin: &AdminCredentials{User: "admin", Password: "hunter2"},
+		})
+	})
+	first := httptest.NewRecorder()
+	handler(first, idempotentRequest("replay-key", "{}"))
+	if !strings.Contains(first.Body.String(), "hunter2") {
+		t.Fatalf("first response lacks the admin login: %s", first.Body)
+	}
+
+	replay := httptest.NewRecorder()
+	handler(replay, idempotentRequest("replay-key", "{}"))
+	var resp APIResponse
+	if err := json.Unmarshal(replay.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Suffix != "abc12" || resp.WPAdmin != nil {
+		t.Errorf("replay = %+v, want the suffix without the admin login", resp)
+	}
+}
+
+func TestIdempotencyRejectsLargeBodies(t *testing.T) {
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran for an oversized body")
+	})
+	rec := httptest.NewRecorder()
+	handler(rec, idempotentRequest("large-key", strings.Repeat("x", maxIdempotentBodyBytes+1)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// imageReferencePattern is the grammar of container image references, as in the
+// distribution/reference package: an optional registry host and port, a lowercase repository
+// path, then an optional tag and an optional digest, e.g. "wordpress:6.7.1",
+// "registry:5000/team/mysql:8.0" or "wordpress@sha256:<64 hex digits>".
+var imageReferencePattern = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+// maxImageNameLength bounds the repository part of a reference, as registries do.
+const maxImageNameLength = 255
+
+// validateImage checks that image is a well-formed reference, pinned by tag or by digest.
+func validateImage(field, image string) error {
+	if !imageReferencePattern.MatchString(image) {
+		return fmt.Errorf("%s %q is not a valid image reference", field, image)
+	}
+	if name := imageRepository(image); len(name) > maxImageNameLength {
+		return fmt.Errorf("%s repository is longer than %d characters", field, maxImageNameLength)
+	}
+	if digest := imageDigest(image); strings.HasPrefix(digest, "sha256:") && len(digest) != len("sha256:")+64 {
+		return fmt.Errorf("%s has a sha256 digest that is not 64 hex digits", field)
+	}
+	return nil
+}
+
+// imageDigest returns the "algorithm:hex" digest an image reference is pinned to, or "".
+func imageDigest(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// imageRepository strips the tag and digest from an image reference, e.g.
+// "registry:5000/wordpress:6.7.1" yields "registry:5000/wordpress".
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// imagePullPolicies are the values of image_pull_policy.
+var imagePullPolicies = []string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)}
+
+// imagePullPolicy returns the image_pull_policy of the request, if any. Otherwise it returns
+// IfNotPresent for images pinned by digest, which are immutable, so a node never pulls them
+// again; even "wordpress:latest@sha256:..." would otherwise default to Always. For other images
+// it leaves the Kubernetes default.
+func imagePullPolicy(payload RequestPayload, image string) corev1.PullPolicy {
+	if payload.ImagePullPolicy != "" {
+		return corev1.PullPolicy(payload.ImagePullPolicy)
+	}
+	if imageDigest(image) != "" {
+		return corev1.PullIfNotPresent
+	}
+	return ""
+}
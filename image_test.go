@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestImageReferenceParsing(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		image      string
+		valid      bool
+		repository string
+		digest     string
+	}{
+		{"wordpress", true, "wordpress", ""},
+		{"wordpress:6.7.1", true, "wordpress", ""},
+		{"wordpress:6.7.1-php8.3-apache", true, "wordpress", ""},
+		{"library/wordpress:latest", true, "library/wordpress", ""},
+		{"wordpress@" + digest, true, "wordpress", digest},
+		{"wordpress:latest@" + digest, true, "wordpress", digest},
+		{"registry:5000/wordpress", true, "registry:5000/wordpress", ""},
+		{"registry:5000/wordpress:6.7.1", true, "registry:5000/wordpress", ""},
+		{"registry:5000/team/mysql:8.0@" + digest, true, "registry:5000/team/mysql", digest},
+		{"registry.example.com/team/mysql@" + digest, true, "registry.example.com/team/mysql", digest},
+
+		{"WordPress:6.7", false, "", ""},
+		{"wordpress:", false, "", ""},
+		{"wordpress@sha256:abc", false, "", ""},
+		{"wordpress@sha256:" + strings.Repeat("ab", 33), false, "", ""},
+		{"wordpress@" + digest + "@" + digest, false, "", ""},
+		{"registry:port/wordpress", false, "", ""},
+		{"wordpress:6.7 ", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			err := validateImage("wordpress_image", tt.image)
+			if (err == nil) != tt.valid {
+				t.Fatalf("validateImage(%q) = %v, want valid %v", tt.image, err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if got := imageRepository(tt.image); got != tt.repository {
+				t.Errorf("imageRepository(%q) = %q, want %q", tt.image, got, tt.repository)
+			}
+			if got := imageDigest(tt.image); got != tt.digest {
+				t.Errorf("imageDigest(%q) = %q, want %q", tt.image, got, tt.digest)
+			}
+		})
+	}
+}
+
+func TestImagePullPolicyForDigests(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		image    string
+		override string
+		want     corev1.PullPolicy
+	}{
+		{"wordpress:6.7.1", "", ""},
+		{"wordpress@" + digest, "", corev1.PullIfNotPresent},
+		{"registry:5000/wordpress:latest@" + digest, "", corev1.PullIfNotPresent},
+		{"wordpress@" + digest, string(corev1.PullAlways), corev1.PullAlways},
+		{"wordpress:6.7.1", string(corev1.PullNever), corev1.PullNever},
+	}
+	for _, tt := range tests {
+		payload := RequestPayload{ImagePullPolicy: tt.override}
+		if got := imagePullPolicy(payload, tt.image); got != tt.want {
+			t.Errorf("imagePullPolicy(%q, %q) = %q, want %q", tt.override, tt.image, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// imagePullCheckTimeout bounds the wait for the check pod's images to be pulled.
+const imagePullCheckTimeout = 3 * time.Minute
+
+// imagePullFailures are the waiting reasons the kubelet reports for an image it cannot pull.
+var imagePullFailures = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// errImageNotPullable means a stack image could not be pulled by the cluster.
+var errImageNotPullable = errors.New("image cannot be pulled")
+
+// buildImageCheckPod returns a pod with one container per stack image, each running only
+// `true`. Pulling is all that matters: a container whose image lacks `true` fails to start,
+// which still proves the image was pulled. The pod runs as the namespace's default
+// ServiceAccount, so it uses the same image pull secrets as the stack's own pods.
+func buildImageCheckPod(payload RequestPayload, names stackNames, images []string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.ImageCheckPod,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.ImageCheckPod, names, componentWordPress),
+			Annotations: podAnnotations(payload),
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			// Removed by the deploy once checked; this ends it if the deploy is interrupted.
+			ActiveDeadlineSeconds:         int64Ptr(int64(imagePullCheckTimeout.Seconds())),
+			TerminationGracePeriodSeconds: int64Ptr(0),
+		},
+	}
+	for i, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: imagePullPolicy(payload, image),
+			Command:         []string{"true"},
+		})
+	}
+	return pod
+}
+
+// checkImagesPullable runs the image check pod and waits until every image is either pulled or
+// reported unpullable, deleting the pod afterwards. An unpullable image is errImageNotPullable;
+// a pod that cannot run or pull in time yields a warning instead, since a slow pull or a busy
+// cluster says nothing about the images.
+func checkImagesPullable(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) (string, error) {
+
+	images := uniqueStrings(stackImages(payload))
+	pods := clientSet.CoreV1().Pods(payload.Namespace)
+	pod := buildImageCheckPod(payload, names, images)
+	if _, err := pods.Create(ctx, pod, metaV1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("unable to create image check pod %s: %w", pod.Name, err)
+	}
+	defer func() {
+		// Best effort on a fresh context, since ctx may be what ended the check.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := pods.Delete(cleanupCtx, pod.Name, metaV1.DeleteOptions{}); err != nil {
+			log.Printf("[WARN] Failed to delete image check pod %s: %v", pod.Name, err)
+		}
+	}()
+
+	log.Printf("[INFO] Checking that images can be pulled: %s", strings.Join(images, ", "))
+	var failures []string
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, imagePullCheckTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := pods.Get(ctx, pod.Name, metaV1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		failures = nil
+		resolved := 0
+		for _, status := range current.Status.ContainerStatuses {
+			switch {
+			case status.State.Running != nil || status.State.Terminated != nil:
+				resolved++
+			case status.State.Waiting != nil && imagePullFailures[status.State.Waiting.Reason]:
+				resolved++
+				failures = append(failures, fmt.Sprintf("%s: %s", status.Image, status.State.Waiting.Message))
+			}
+		}
+		debugf(ctx, "Image check pod %s: %d/%d images resolved", pod.Name, resolved, len(images))
+		return resolved == len(images), nil
+	})
+	if len(failures) > 0 {
+		return "", fmt.Errorf("%w: %s", errImageNotPullable, strings.Join(failures, "; "))
+	}
+	if isContextError(ctx.Err()) {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return fmt.Sprintf("images were not confirmed pullable within %s: %v", imagePullCheckTimeout, err), nil
+	}
+	log.Println("[INFO] All images can be pulled.")
+	return "", nil
+}
+
+// uniqueStrings returns values without repeats, in their first order.
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lifecycle states of an async deploy job.
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobRetention is how long finished jobs stay queryable before they're pruned.
+const jobRetention = 24 * time.Hour
+
+// callbackAttempts bounds the deliveries of one callback; waits double from 1s between tries.
+const callbackAttempts = 5
+
+// DeployJob tracks one async deploy.
+type DeployJob struct {
+	ID         string       `json:"id"`
+	State      string       `json:"state"`
+	CreatedAt  time.Time    `json:"created_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Result     *APIResponse `json:"result,omitempty"` // Final response, once finished
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = map[string]*DeployJob{}
+)
+
+// newDeployJob registers a running job under a fresh random ID, pruning old finished jobs.
+func newDeployJob() *DeployJob {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	for id, job := range jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+
+	id, err := generateRandomSuffix(12)
+	if err != nil {
+		// crypto/rand failing is not recoverable in any useful way; fall back to the clock
+		id = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	job := &DeployJob{ID: id, State: jobRunning, CreatedAt: time.Now()}
+	jobs[id] = job
+	return job
+}
+
+// getDeployJob returns a snapshot of the job, safe to encode while the deploy runs.
+func getDeployJob(id string) (DeployJob, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[id]
+	if !ok {
+		return DeployJob{}, false
+	}
+	return *job, true
+}
+
+// runDeployJob performs the deploy in the background, records the result on the job
+// and delivers it to the callback URL, if any.
+func runDeployJob(job *DeployJob, payload RequestPayload, names stackNames) {
+	resp, status, deployed := deployStack(context.Background(), payload, names)
+	if deployed.ID() != "" {
+		resp.Suffix = deployed.Suffix
+	}
+	resp.JobID = job.ID
+	resp.Namespace = generatedNamespace(payload)
+	// The result is served by /jobs/{id} until the job expires, so it keeps no secrets: async
+	// deploys deliver the admin login through the one-shot wp_admin_claim instead.
+	resp.WPAdmin, resp.Manifest = nil, ""
+
+	now := time.Now()
+	jobsMu.Lock()
+	job.Result = &resp
+	job.FinishedAt = &now
+	job.State = jobSucceeded
+	if status != http.StatusOK {
+		job.State = jobFailed
+	}
+	jobsMu.Unlock()
+	log.Printf("[INFO] Async job %s finished: %s", job.ID, job.State)
+
+	if payload.CallbackURL != "" {
+		// The admin claim is only handed out by /jobs/{id}, never to the callback URL.
+		notice := resp
+		notice.WPAdmin, notice.WPAdminClaim = nil, nil
+		if err := sendCallback(payload.CallbackURL, notice); err != nil {
+			log.Printf("[ERROR] Callback for job %s failed: %v", job.ID, err)
+		}
+	}
+}
+
+// sendCallback POSTs the final response to url, retrying with exponential backoff.
+// When CALLBACK_SECRET is set, the body is signed with HMAC-SHA256 in X-Deployer-Signature
+// ("sha256=<hex>") so the receiver can verify it came from this deployer.
+func sendCallback(url string, resp APIResponse) error {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("unable to encode callback body: %w", err)
+	}
+
+	var signature string
+	if secret := os.Getenv("CALLBACK_SECRET"); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = postCallback(client, url, body, signature, resp.JobID)
+		if err == nil {
+			return nil
+		}
+		if attempt == callbackAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		log.Printf("[WARN] Callback attempt %d to %s failed: %v", attempt, url, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postCallback makes a single callback delivery; any non-2xx answer counts as a failure.
+func postCallback(client *http.Client, url string, body []byte, signature, jobID string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Deployer-Job-ID", jobID)
+	if signature != "" {
+		req.Header.Set("X-Deployer-Signature", signature)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// handleGetJob reports the state of an async deploy and, once finished, its result.
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := getDeployJob(r.PathValue("id"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "No such job",
+		})
+		return
+	}
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: "Job " + job.State,
+		JobID:   job.ID,
+		Job:     &job,
+	})
+}
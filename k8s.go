@@ -3,15 +3,21 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	resource "k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -19,21 +25,220 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// InitKubeClient creates a new Kubernetes clientset using the provided kubeconfig path.
-// If kubeconfig is empty, it uses the in-cluster config or the default (~/.kube/config).
-func InitKubeClient(kubeconfig string) (*kubernetes.Clientset, error) {
+// Every object the deployer creates or adopts carries this label so it can be found again.
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "my-wordpress-deployer"
+)
+
+// Stack resources are also labelled with the stack they belong to and their role in it.
+const (
+	stackLabel     = "app.kubernetes.io/instance"
+	componentLabel = "app.kubernetes.io/component"
+
+	componentDatabase  = "database"
+	componentWordPress = "wordpress"
+	componentCache     = "cache"
+)
+
+// serviceLabel marks the pods the WordPress Service sends traffic to. The Service selects on it
+// rather than "app" so that the stable and canary deployments, each with its own "app"
+// selector, can both serve.
+const serviceLabel = "my-wordpress-deployer/service"
+
+// stackLabels returns the "app" selector label plus the managed-by, stack and component labels.
+func stackLabels(app string, names stackNames, component string) map[string]string {
+	return map[string]string{
+		"app":          app,
+		managedByLabel: managedByValue,
+		stackLabel:     names.ID(),
+		componentLabel: component,
+	}
+}
+
+// defaultRevisionHistoryLimit is how many old ReplicaSets each deployment keeps by default.
+const defaultRevisionHistoryLimit = 3
+
+// Default data directories of the official MySQL and WordPress images.
+const (
+	defaultMySQLDataPath     = "/var/lib/mysql"
+	defaultWordPressDataPath = "/var/www/html"
+)
+
+// defaultTopologyKey spreads WordPress replicas across availability zones.
+const defaultTopologyKey = "topology.kubernetes.io/zone"
+
+// defaultWordPressImage is the WordPress image of the main deployment.
+const defaultWordPressImage = "wordpress:6.7.1"
+
+// defaultMySQLImage is used when the request does not select a MySQL image.
+const defaultMySQLImage = "mysql:8"
+
+// defaultMySQLContainerName and defaultWordPressContainerName name the stack's main containers,
+// e.g. for `kubectl logs -c`, unless the request overrides them.
+const (
+	defaultMySQLContainerName     = "mysql"
+	defaultWordPressContainerName = "wordpress"
+)
+
+// mysqlCompatArgs holds the extra mysqld flags each MySQL release needs, keyed on the
+// "major.minor" or "major" version detected from the image tag.
+var mysqlCompatArgs = map[string][]string{
+	// 5.7 defaults to latin1 and refuses to initialise a data dir that contains lost+found.
+	"5.7": {
+		"--ignore-db-dir=lost+found",
+		"--character-set-server=utf8mb4",
+		"--collation-server=utf8mb4_unicode_ci",
+	},
+	// 8.0 defaults to caching_sha2_password, which older PHP mysqli clients cannot negotiate.
+	// The flag was removed in 8.4, so it must not be applied to the floating "8" tag.
+	"8.0": {
+		"--default-authentication-plugin=mysql_native_password",
+	},
+}
+
+// mysqlVersionFromImage extracts the leading version number from an image tag,
+// e.g. "mysql:5.7.44-oracle" yields "5.7.44". It returns "" for untagged or non-numeric tags.
+func mysqlVersionFromImage(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	tag := image[i+1:]
+	end := 0
+	for end < len(tag) && (tag[end] == '.' || (tag[end] >= '0' && tag[end] <= '9')) {
+		end++
+	}
+	return strings.Trim(tag[:end], ".")
+}
+
+// mysqlArgsForImage returns the compatibility flags for the MySQL version in the image tag,
+// preferring an exact "major.minor" match over a "major" one.
+func mysqlArgsForImage(image string) []string {
+	parts := strings.Split(mysqlVersionFromImage(image), ".")
+	if len(parts) >= 2 {
+		if args, ok := mysqlCompatArgs[parts[0]+"."+parts[1]]; ok {
+			return args
+		}
+	}
+	return mysqlCompatArgs[parts[0]]
+}
+
+// mysqlContainerArgs combines the version compatibility flags with the requested tuning flags.
+// The caller's mysql_args come last, so mysqld lets them override anything set before.
+func mysqlContainerArgs(payload RequestPayload) []string {
+	args := append([]string{}, mysqlArgsForImage(payload.MySQLImage)...)
+	if mb := innodbBufferPoolMB(payload); mb > 0 {
+		args = append(args, fmt.Sprintf("--innodb-buffer-pool-size=%dM", mb))
+	}
+	return append(args, payload.MySQLArgs...)
+}
+
+// innodbBufferPoolMB returns the requested buffer pool size, or half the MySQL memory limit
+// when none was requested. Zero means leave the MySQL default in place.
+func innodbBufferPoolMB(payload RequestPayload) int64 {
+	if payload.MySQLInnoDBBufferPoolMB > 0 {
+		return int64(payload.MySQLInnoDBBufferPoolMB)
+	}
+	if limitMB, ok := memoryLimitMB(payload.MySQLResources); ok {
+		return limitMB / 2
+	}
+	return 0
+}
+
+// memoryLimitMB returns the memory limit of spec in MiB, if one is set and parses.
+func memoryLimitMB(spec *ResourceSpec) (int64, bool) {
+	if spec == nil || spec.MemoryLimit == "" {
+		return 0, false
+	}
+	q, err := resource.ParseQuantity(spec.MemoryLimit)
+	if err != nil {
+		return 0, false
+	}
+	return q.Value() / (1024 * 1024), true
+}
+
+// buildResourceRequirements converts a ResourceSpec into container requests/limits,
+// rejecting unparsable quantities and requests above their limit.
+func buildResourceRequirements(spec ResourceSpec) (corev1.ResourceRequirements, error) {
+	req := corev1.ResourceRequirements{}
+	set := func(list *corev1.ResourceList, name corev1.ResourceName, field, value string) error {
+		if value == "" {
+			return nil
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+		(*list)[name] = q
+		return nil
+	}
+
+	for _, f := range []struct {
+		list  *corev1.ResourceList
+		name  corev1.ResourceName
+		field string
+		value string
+	}{
+		{&req.Requests, corev1.ResourceCPU, "cpu_request", spec.CPURequest},
+		{&req.Limits, corev1.ResourceCPU, "cpu_limit", spec.CPULimit},
+		{&req.Requests, corev1.ResourceMemory, "memory_request", spec.MemoryRequest},
+		{&req.Limits, corev1.ResourceMemory, "memory_limit", spec.MemoryLimit},
+	} {
+		if err := set(f.list, f.name, f.field, f.value); err != nil {
+			return req, err
+		}
+	}
+
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request, hasRequest := req.Requests[name]
+		limit, hasLimit := req.Limits[name]
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return req, fmt.Errorf("%s request %s exceeds limit %s", name, request.String(), limit.String())
+		}
+	}
+	return req, nil
+}
+
+// errKubeContextNotFound means the requested context is not defined in the kubeconfig.
+var errKubeContextNotFound = errors.New("context not found in kubeconfig")
+
+// errInlineKubeconfig means kubeconfig content sent with a request cannot be used.
+var errInlineKubeconfig = errors.New("invalid inline kubeconfig")
+
+// InitKubeClient creates a new Kubernetes clientset using the provided kubeconfig and,
+// when kubeContext is set, that context of it instead of the current one. The kubeconfig is
+// either a path on the deployer's filesystem or, for clients that bring their own cluster
+// credentials, the kubeconfig YAML itself.
+// If kubeconfig is empty, it uses the in-cluster config or the default (~/.kube/config);
+// a context always comes from a kubeconfig, the default one when no path is given.
+func InitKubeClient(kubeconfig, kubeContext string) (*kubernetes.Clientset, error) {
 	var config *rest.Config
 	var err error
 
-	if kubeconfig != "" {
+	if isInlineKubeconfig(kubeconfig) {
+		config, err = inlineClientConfig(kubeconfig, kubeContext)
+		if err != nil {
+			return nil, err
+		}
+	} else if kubeconfig != "" || kubeContext != "" {
 		// Use the given kubeconfig file
+		if kubeconfig == "" {
+			kubeconfig = filepath.Join(HomeDir(), ".kube", "config")
+		}
 		kubeconfig, err = filepath.Abs(kubeconfig)
 		if err != nil {
 			return nil, fmt.Errorf("invalid kubeconfig path: %w", err)
 		}
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		config, err = kubeconfigClientConfig(kubeconfig, kubeContext)
 		if err != nil {
-			return nil, fmt.Errorf("cannot build config from flags: %w", err)
+			return nil, err
 		}
 	} else {
 		// Try in-cluster config, fallback to local kube config
@@ -55,6 +260,85 @@ func InitKubeClient(kubeconfig string) (*kubernetes.Clientset, error) {
 	return clientSet, nil
 }
 
+// kubeconfigClientConfig loads the kubeconfig at path with kubeContext as its current context,
+// or the file's own current context when kubeContext is empty.
+func kubeconfigClientConfig(path, kubeContext string) (*rest.Config, error) {
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+	if kubeContext != "" {
+		raw, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubeconfig: %w", err)
+		}
+		if _, ok := raw.Contexts[kubeContext]; !ok {
+			return nil, fmt.Errorf("%w: %q", errKubeContextNotFound, kubeContext)
+		}
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot build config from kubeconfig: %w", err)
+	}
+	return config, nil
+}
+
+// isInlineKubeconfig tells kubeconfig content from a path: YAML or JSON content spans lines
+// or starts like a document, which a file path doesn't.
+func isInlineKubeconfig(kubeconfig string) bool {
+	trimmed := strings.TrimSpace(kubeconfig)
+	return strings.Contains(trimmed, "\n") || strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "apiVersion:")
+}
+
+// inlineClientConfig builds the client config from kubeconfig content. Content comes from the
+// caller, so anything that would make the deployer read its own files or run commands, such as
+// certificate paths, token files or exec credential plugins, is rejected.
+func inlineClientConfig(content, kubeContext string) (*rest.Config, error) {
+	raw, err := clientcmd.Load([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInlineKubeconfig, err)
+	}
+	for name, auth := range raw.AuthInfos {
+		switch {
+		case auth.Exec != nil || auth.AuthProvider != nil:
+			return nil, fmt.Errorf("%w: user %q uses a credential plugin; use a token or client certificate data", errInlineKubeconfig, name)
+		case auth.ClientCertificate != "" || auth.ClientKey != "" || auth.TokenFile != "":
+			return nil, fmt.Errorf("%w: user %q refers to files; embed client-certificate-data, client-key-data or a token", errInlineKubeconfig, name)
+		}
+	}
+	for name, cluster := range raw.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return nil, fmt.Errorf("%w: cluster %q refers to a file; embed certificate-authority-data", errInlineKubeconfig, name)
+		}
+	}
+
+	if kubeContext == "" {
+		config, err := clientcmd.RESTConfigFromKubeConfig([]byte(content))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInlineKubeconfig, err)
+		}
+		return config, nil
+	}
+	if _, ok := raw.Contexts[kubeContext]; !ok {
+		return nil, fmt.Errorf("%w: %q", errKubeContextNotFound, kubeContext)
+	}
+	config, err := clientcmd.NewNonInteractiveClientConfig(*raw, kubeContext, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInlineKubeconfig, err)
+	}
+	return config, nil
+}
+
+// kubeClientError is the HTTP status and message reporting an InitKubeClient failure: the
+// client's mistake when it named a context the kubeconfig lacks or sent an unusable
+// kubeconfig, an internal error otherwise.
+func kubeClientError(err error) (int, string) {
+	if errors.Is(err, errKubeContextNotFound) || errors.Is(err, errInlineKubeconfig) {
+		return http.StatusBadRequest, err.Error()
+	}
+	return http.StatusInternalServerError, "Could not initialize Kubernetes client"
+}
+
 // HomeDir returns the home directory for the current user (fallback to /root if not set).
 func HomeDir() string {
 	if h := SystemGetenv("HOME"); h != "" {
@@ -68,49 +352,138 @@ var SystemGetenv = func(key string) string {
 	return ""
 }
 
-// ensureNamespace checks if a namespace exists; if not, creates it.
-func ensureNamespace(ctx context.Context, clientSet *kubernetes.Clientset, namespace string) error {
-	_, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metaV1.GetOptions{})
+// errNamespaceTerminating means the target namespace exists but is still being deleted.
+var errNamespaceTerminating = errors.New("namespace is terminating")
+
+// namespaceErrorStatus maps an ensureNamespace error to its HTTP status.
+func namespaceErrorStatus(err error) int {
+	if errors.Is(err, errNamespaceTerminating) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// namespaceCreatedForAnnotation records the stack a namespace was created for by
+// namespace_per_deployment. Unlike the managed-by label, which ensureNamespace also merges onto
+// namespaces that already existed, it is only set on creation, so it alone proves the namespace
+// is the stack's to delete.
+const namespaceCreatedForAnnotation = "my-wordpress-deployer/created-for"
+
+// namespaceCreatedFor returns the stack ID to record in namespaceCreatedForAnnotation: the
+// stack's own when namespace_per_deployment generates its namespace, "" otherwise.
+func namespaceCreatedFor(payload RequestPayload, names stackNames) string {
+	if payload.NamespacePerDeployment {
+		return names.ID()
+	}
+	return ""
+}
+
+// ensureNamespace checks if a namespace exists; if not, creates it, annotated as created for
+// the createdFor stack unless that is empty.
+// Either way the managed-by label plus any requested labels/annotations are merged onto it,
+// so reused namespaces end up labelled exactly like new ones.
+func ensureNamespace(ctx context.Context, clientSet kubernetes.Interface, namespace string,
+	labels, annotations map[string]string, createdFor string) (created bool, err error) {
+
+	existing, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metaV1.GetOptions{})
 	if err == nil {
-		// namespace already exists
-		return nil
+		// A terminating namespace rejects new objects, so nothing deployed into it would stick.
+		if existing.Status.Phase == corev1.NamespaceTerminating {
+			return false, fmt.Errorf("%w: namespace %s is being deleted; wait for the deletion to finish and retry",
+				errNamespaceTerminating, namespace)
+		}
+		// namespace already exists; merge our metadata without clobbering what's there
+		return false, patchNamespaceMetadata(ctx, clientSet, namespace, namespaceLabels(labels), annotations)
+	}
+
+	nsSpec := buildNamespace(namespace, labels, annotations, createdFor)
+	_, err = clientSet.CoreV1().Namespaces().Create(ctx, nsSpec, metaV1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("unable to create namespace %s: %w", namespace, err)
+	}
+	return true, nil
+}
+
+// namespaceLabels returns the requested labels plus the managed-by label, which always wins.
+func namespaceLabels(labels map[string]string) map[string]string {
+	nsLabels := map[string]string{}
+	for k, v := range labels {
+		nsLabels[k] = v
 	}
+	nsLabels[managedByLabel] = managedByValue
+	return nsLabels
+}
 
-	nsSpec := &corev1.Namespace{
+// buildNamespace returns a namespace carrying the managed-by label and the requested metadata,
+// and namespaceCreatedForAnnotation when createdFor is set.
+func buildNamespace(namespace string, labels, annotations map[string]string, createdFor string) *corev1.Namespace {
+	if createdFor != "" {
+		// Copied, since the map is the request's own.
+		nsAnnotations := map[string]string{}
+		for k, v := range annotations {
+			nsAnnotations[k] = v
+		}
+		nsAnnotations[namespaceCreatedForAnnotation] = createdFor
+		annotations = nsAnnotations
+	}
+	return &corev1.Namespace{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: namespace,
+			Name:        namespace,
+			Labels:      namespaceLabels(labels),
+			Annotations: annotations,
 		},
 	}
+}
 
-	_, err = clientSet.CoreV1().Namespaces().Create(ctx, nsSpec, metaV1.CreateOptions{})
+// patchNamespaceMetadata applies a JSON merge patch that adds or updates the given
+// labels and annotations while leaving any other keys on the namespace untouched.
+func patchNamespaceMetadata(ctx context.Context, clientSet kubernetes.Interface, namespace string,
+	labels, annotations map[string]string) error {
+
+	metadata := map[string]interface{}{"labels": labels}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return fmt.Errorf("unable to build namespace patch: %w", err)
+	}
+
+	_, err = clientSet.CoreV1().Namespaces().Patch(ctx, namespace, types.MergePatchType, patch, metaV1.PatchOptions{})
 	if err != nil {
-		return fmt.Errorf("unable to create namespace %s: %w", namespace, err)
+		return fmt.Errorf("unable to label namespace %s: %w", namespace, err)
 	}
 	return nil
 }
 
-// createPersistentVolume creates a hostPath PV with the given capacity (in GB),
-// ensuring the directory is created if it doesn't exist.
-func createPersistentVolume(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, pvName, hostPath string, sizeGB int) error {
+// buildPersistentVolume returns a hostPath PV with the given capacity (in GB),
+// ensuring the directory is created if it doesn't exist. With hostNode, the PV's node affinity
+// pins it, and so every pod mounting it, to the node holding the directory.
+// The PV records the namespace it serves, so it can be found once the namespace is gone.
+func buildPersistentVolume(namespace, pvName, hostPath string, sizeGB int, labels map[string]string,
+	hostNode string, hostPathType corev1.HostPathType) (*corev1.PersistentVolume, error) {
 
 	quantity, err := resource.ParseQuantity(fmt.Sprintf("%dGi", sizeGB))
 	if err != nil {
-		return fmt.Errorf("invalid capacity: %w", err)
+		return nil, fmt.Errorf("invalid capacity: %w", err)
 	}
 
-	hostPathType := corev1.HostPathDirectoryOrCreate
+	if hostPathType == "" {
+		hostPathType = corev1.HostPathDirectoryOrCreate
+	}
+	// hostPath only serves filesystems; claims must ask for the same mode to bind.
+	volumeMode := corev1.PersistentVolumeFilesystem
 
 	pv := &corev1.PersistentVolume{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: pvName,
-			Labels: map[string]string{
-				"app": pvName,
-			},
+			Name:        pvName,
+			Labels:      labels,
+			Annotations: map[string]string{pvNamespaceAnnotation: namespace},
 		},
 		Spec: corev1.PersistentVolumeSpec{
 			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
 			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			VolumeMode:                    &volumeMode,
 			Capacity: corev1.ResourceList{
 				corev1.ResourceStorage: quantity,
 			},
@@ -122,31 +495,265 @@ func createPersistentVolume(ctx context.Context, clientSet *kubernetes.Clientset
 			},
 		},
 	}
+	if hostNode != "" {
+		pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+			Required: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      hostnameLabel,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{hostNode},
+					}},
+				}},
+			},
+		}
+	}
 
-	_, err = clientSet.CoreV1().PersistentVolumes().Create(ctx, pv, metaV1.CreateOptions{})
+	return pv, nil
+}
+
+// pvNamespaceAnnotation names the namespace a cluster-scoped PV was created for.
+const pvNamespaceAnnotation = "my-wordpress-deployer/namespace"
+
+// hostnameLabel is the well-known node label holding the node's name, used to pin hostPath PVs.
+const hostnameLabel = "kubernetes.io/hostname"
+
+// sharedPVCName is the claim every stack of a namespace mounts in shared_volume mode.
+const sharedPVCName = "wp-shared-pvc"
+
+// sharedPVName returns the cluster-scoped PV backing a namespace's shared claim.
+func sharedPVName(namespace string) string {
+	return "wp-shared-" + namespace
+}
+
+// defaultSharedVolumeGB sizes the shared PV when shared_volume_size is not given.
+const defaultSharedVolumeGB = 20
+
+// stackClaim returns the PVC a component mounts and the subPath within it. Stacks normally get
+// a claim of their own; in shared_volume mode they all mount the namespace's shared claim, each
+// in its own <stack>/<component> directory.
+func stackClaim(payload RequestPayload, names stackNames, component string) (claimName, subPath string) {
+	if payload.SharedVolume {
+		return sharedPVCName, names.ID() + "/" + component
+	}
+	if component == componentDatabase {
+		return names.DBPVC, ""
+	}
+	return names.WPPVC, ""
+}
+
+// sharedVolumeLabels labels the shared PV/PVC, which belong to no single stack.
+func sharedVolumeLabels(app string) map[string]string {
+	return map[string]string{
+		"app":          app,
+		managedByLabel: managedByValue,
+	}
+}
+
+// buildSharedVolume returns the namespace's shared PV and its claim.
+func buildSharedVolume(payload RequestPayload) (*corev1.PersistentVolume, *corev1.PersistentVolumeClaim, error) {
+	pvName := sharedPVName(payload.Namespace)
+	pv, err := buildPersistentVolume(payload.Namespace, pvName, hostPathFor(payload.Namespace, pvName), payload.SharedVolumeGB,
+		sharedVolumeLabels(pvName), payload.HostNode, corev1.HostPathType(payload.HostPathType))
 	if err != nil {
-		return fmt.Errorf("unable to create PV %s: %w", pvName, err)
+		return nil, nil, err
 	}
+	pvc, err := buildPersistentVolumeClaim(payload.Namespace, sharedPVCName, pvName, payload.SharedVolumeGB,
+		sharedVolumeLabels(sharedPVCName))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pv, pvc, nil
+}
 
+// ensureSharedVolume creates the namespace's shared PV and PVC unless an earlier stack already did.
+// An existing volume is reused as is, whatever size this request asked for.
+func ensureSharedVolume(ctx context.Context, clientSet *kubernetes.Clientset, payload RequestPayload) error {
+	pv, pvc, err := buildSharedVolume(payload)
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().PersistentVolumes().Create(ctx, pv, metaV1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create shared PV %s: %w", pv.Name, err)
+	}
+	_, err = clientSet.CoreV1().PersistentVolumeClaims(payload.Namespace).Create(ctx, pvc, metaV1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create shared PVC %s: %w", pvc.Name, err)
+	}
 	return nil
 }
 
-// createPersistentVolumeClaim creates a PVC that references the specified PV (by label selector).
-func createPersistentVolumeClaim(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, pvcName, pvName string, sizeGB int) error {
+// stackNamesInUse reports whether any deployment or PV of the stack already exists.
+// PVs are cluster-scoped, so they can collide with stacks in other namespaces too.
+func stackNamesInUse(ctx context.Context, clientSet kubernetes.Interface, namespace string, names stackNames) (bool, error) {
+	for _, deployName := range []string{names.DBDeployment, names.WPDeployment} {
+		_, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, deployName, metaV1.GetOptions{})
+		if err == nil {
+			return true, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("unable to look up deployment %s: %w", deployName, err)
+		}
+	}
+	for _, pvName := range []string{names.DBPV, names.WPPV} {
+		_, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, pvName, metaV1.GetOptions{})
+		if err == nil {
+			return true, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("unable to look up PV %s: %w", pvName, err)
+		}
+	}
+	return false, nil
+}
+
+// errStackNamesTaken means every suffix tried, or the fixed names without one, is in use.
+var errStackNamesTaken = errors.New("resource names already in use")
+
+// unusedStackNames returns names, or names with a new random suffix of the same length, none
+// of which is taken in the namespace yet.
+func unusedStackNames(ctx context.Context, clientSet kubernetes.Interface,
+	payload RequestPayload, names stackNames) (stackNames, error) {
+
+	for attempt := 1; ; attempt++ {
+		inUse, err := stackNamesInUse(ctx, clientSet, payload.Namespace, names)
+		if err != nil {
+			return stackNames{}, err
+		}
+		if !inUse {
+			return names, nil
+		}
+		if names.Suffix == "" {
+			// Without a suffix there is nothing to regenerate: the names are what the client asked for.
+			return stackNames{}, fmt.Errorf("%w: resources of stack %s already exist in namespace %s",
+				errStackNamesTaken, names.ID(), payload.Namespace)
+		}
+		if attempt == maxSuffixAttempts {
+			return stackNames{}, fmt.Errorf("%w: no unused resource suffix found after %d attempts", errStackNamesTaken, attempt)
+		}
+
+		log.Printf("[WARN] Suffix %s is already in use, generating a new one", names.Suffix)
+		suffix, err := randSuffixFunc(len(names.Suffix))
+		if err != nil {
+			return stackNames{}, fmt.Errorf("unable to generate a suffix: %w", err)
+		}
+		names = stackNamesFor(payload, suffix)
+	}
+}
+
+// hostPathCapacityWarning checks the combined hostPath size against every node's allocatable
+// ephemeral storage. hostPath ignores the PV capacity and either pod may land on any node, so
+// the smallest node decides. It returns a warning when requestedGB doesn't fit, or "" if it does.
+func hostPathCapacityWarning(ctx context.Context, clientSet *kubernetes.Clientset, requestedGB int) (string, error) {
+	nodes, err := clientSet.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to list nodes: %w", err)
+	}
+
+	requested := resource.MustParse(fmt.Sprintf("%dGi", requestedGB))
+	for _, node := range nodes.Items {
+		allocatable, ok := node.Status.Allocatable[corev1.ResourceEphemeralStorage]
+		if !ok {
+			continue
+		}
+		if allocatable.Cmp(requested) < 0 {
+			return fmt.Sprintf("requested disks total %dGi but node %s only has %s of allocatable ephemeral storage",
+				requestedGB, node.Name, allocatable.String()), nil
+		}
+	}
+	return "", nil
+}
+
+// errPVConflict means a PV of the requested name already exists and cannot serve the stack.
+var errPVConflict = errors.New("PV already exists")
+
+// createPersistentVolume creates the hostPath PV described by buildPersistentVolume.
+// A PV of the same name, typically kept by an earlier deploy with the same names, is compared
+// with the requested one: if capacity or path differ, the discrepancy is an errPVConflict;
+// otherwise it is reused if reuseExisting is set and not bound to another claim.
+// The returned note says what was done with an existing PV, and is empty for a fresh one.
+func createPersistentVolume(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, pvName, hostPath string, sizeGB int, labels map[string]string, hostNode string,
+	hostPathType corev1.HostPathType, reuseExisting bool) (string, error) {
+
+	pv, err := buildPersistentVolume(namespace, pvName, hostPath, sizeGB, labels, hostNode, hostPathType)
+	if err != nil {
+		return "", err
+	}
+
+	volumes := clientSet.CoreV1().PersistentVolumes()
+	_, err = volumes.Create(ctx, pv, metaV1.CreateOptions{})
+	if err == nil {
+		return "", nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("unable to create PV %s: %w", pvName, err)
+	}
+
+	existing, err := volumes.Get(ctx, pvName, metaV1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect existing PV %s: %w", pvName, err)
+	}
+	if diffs := persistentVolumeDifferences(existing, pv); len(diffs) > 0 {
+		return "", fmt.Errorf("%w: %s has %s; delete it or deploy under other names",
+			errPVConflict, pvName, strings.Join(diffs, " and "))
+	}
+	if !reuseExisting {
+		return "", fmt.Errorf("%w: %s matches the requested size and path; set reuse_existing_pv to use it",
+			errPVConflict, pvName)
+	}
+
+	switch existing.Status.Phase {
+	case corev1.VolumeAvailable:
+		return fmt.Sprintf("PV %s already existed; reused it", pvName), nil
+	case corev1.VolumeReleased:
+		// Still reserved for the deleted claim that kept the data; free it for the new claim.
+		patch := []byte(`{"spec":{"claimRef":null}}`)
+		if _, err := volumes.Patch(ctx, pvName, types.MergePatchType, patch, metaV1.PatchOptions{}); err != nil {
+			return "", fmt.Errorf("unable to release PV %s from its old claim: %w", pvName, err)
+		}
+		return fmt.Sprintf("PV %s already existed, released by its old claim; reused it with its data", pvName), nil
+	}
+	claim := "another claim"
+	if ref := existing.Spec.ClaimRef; ref != nil {
+		claim = "PVC " + ref.Namespace + "/" + ref.Name
+	}
+	return "", fmt.Errorf("%w: %s is %s to %s and cannot be reused", errPVConflict, pvName, existing.Status.Phase, claim)
+}
+
+// persistentVolumeDifferences describes how an existing PV differs from the requested one in
+// the capacity and hostPath a redeploy may have changed, e.g. `capacity 5Gi, not the requested 10Gi`.
+func persistentVolumeDifferences(existing, requested *corev1.PersistentVolume) []string {
+	var diffs []string
+	have, want := existing.Spec.Capacity[corev1.ResourceStorage], requested.Spec.Capacity[corev1.ResourceStorage]
+	if have.Cmp(want) != 0 {
+		diffs = append(diffs, fmt.Sprintf("capacity %s, not the requested %s", have.String(), want.String()))
+	}
+	wantPath := requested.Spec.HostPath.Path
+	switch {
+	case existing.Spec.HostPath == nil:
+		diffs = append(diffs, fmt.Sprintf("no hostPath, not the requested %s", wantPath))
+	case existing.Spec.HostPath.Path != wantPath:
+		diffs = append(diffs, fmt.Sprintf("hostPath %s, not the requested %s", existing.Spec.HostPath.Path, wantPath))
+	}
+	return diffs
+}
+
+// buildPersistentVolumeClaim returns a PVC that references the specified PV (by label selector).
+func buildPersistentVolumeClaim(namespace, pvcName, pvName string, sizeGB int,
+	labels map[string]string) (*corev1.PersistentVolumeClaim, error) {
 
 	quantity, err := resource.ParseQuantity(fmt.Sprintf("%dGi", sizeGB))
 	if err != nil {
-		return fmt.Errorf("invalid capacity: %w", err)
+		return nil, fmt.Errorf("invalid capacity: %w", err)
 	}
 
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metaV1.ObjectMeta{
 			Name:      pvcName,
 			Namespace: namespace,
-			Labels: map[string]string{
-				"app": pvcName,
-			},
+			Labels:    labels,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			AccessModes: []corev1.PersistentVolumeAccessMode{
@@ -157,40 +764,151 @@ func createPersistentVolumeClaim(ctx context.Context, clientSet *kubernetes.Clie
 					corev1.ResourceStorage: quantity,
 				},
 			},
-			Selector: &metaV1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": pvName, // match the label "app" on the PV
-				},
-			},
 		},
 	}
+	// Without a PV of our own the claim is left to the storage class's provisioner.
+	if pvName != "" {
+		pvc.Spec.Selector = &metaV1.LabelSelector{
+			MatchLabels: map[string]string{
+				"app": pvName, // match the label "app" on the PV
+			},
+		}
+	}
+
+	return pvc, nil
+}
+
+// stackVolume returns the PV, PVC and size of a component's own volume. The PV name is empty
+// with dynamic provisioning, where the provisioner creates the PV.
+func stackVolume(payload RequestPayload, names stackNames, component string) (pvName, pvcName string, sizeGB int) {
+	pvName, pvcName, sizeGB = names.WPPV, names.WPPVC, payload.PersistenceDiskGB
+	if component == componentDatabase {
+		pvName, pvcName, sizeGB = names.DBPV, names.DBPVC, payload.DatabaseDiskGB
+	}
+	if payload.DynamicProvisioning {
+		pvName = ""
+	}
+	return pvName, pvcName, sizeGB
+}
 
-	_, err = clientSet.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metaV1.CreateOptions{})
+// componentStorageClass is the StorageClass of a component's claim: its own class when given,
+// else storage_class, else empty for the cluster default.
+func componentStorageClass(payload RequestPayload, component string) string {
+	class := payload.WordPressStorageClass
+	if component == componentDatabase {
+		class = payload.DatabaseStorageClass
+	}
+	if class == "" {
+		class = payload.StorageClass
+	}
+	return class
+}
+
+// buildStackPVC returns a component's own claim: bound to its hostPath PV, or, with dynamic
+// provisioning, requesting the component's StorageClass (the cluster default when empty).
+func buildStackPVC(payload RequestPayload, names stackNames, component string) (*corev1.PersistentVolumeClaim, error) {
+	pvName, pvcName, sizeGB := stackVolume(payload, names, component)
+	pvc, err := buildPersistentVolumeClaim(payload.Namespace, pvcName, pvName, sizeGB, stackLabels(pvcName, names, component))
 	if err != nil {
-		return fmt.Errorf("unable to create PVC %s: %w", pvcName, err)
+		return nil, err
 	}
+	if class := componentStorageClass(payload, component); class != "" {
+		pvc.Spec.StorageClassName = &class
+	}
+	volumeMode := corev1.PersistentVolumeFilesystem
+	if component == componentDatabase {
+		volumeMode = databaseVolumeMode(payload)
+	}
+	pvc.Spec.VolumeMode = &volumeMode
+	pvc.Annotations = stackAnnotations(payload)
+	return pvc, nil
+}
 
-	return nil
+// createPersistentVolumeClaim creates the PVC described by buildStackPVC.
+// A PVC of the same name left behind by an interrupted deploy is reused when it is Bound to pvName,
+// or to any volume for a dynamically provisioned claim (empty pvName); otherwise it is deleted and
+// recreated if recreateStale is set, or reported as an error.
+// The returned note says what was done with an existing PVC, and is empty for a fresh one.
+func createPersistentVolumeClaim(ctx context.Context, clientSet *kubernetes.Clientset,
+	pvc *corev1.PersistentVolumeClaim, pvName string, recreateStale bool) (string, error) {
+
+	pvcName := pvc.Name
+	claims := clientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace)
+	_, err := claims.Create(ctx, pvc, metaV1.CreateOptions{})
+	if err == nil {
+		return "", nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("unable to create PVC %s: %w", pvcName, err)
+	}
+
+	existing, err := claims.Get(ctx, pvcName, metaV1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect existing PVC %s: %w", pvcName, err)
+	}
+	if existing.Status.Phase == corev1.ClaimBound && (pvName == "" || existing.Spec.VolumeName == pvName) {
+		return fmt.Sprintf("PVC %s already existed and is bound to PV %s; reused it", pvcName, existing.Spec.VolumeName), nil
+	}
+
+	state := fmt.Sprintf("is %s", existing.Status.Phase)
+	if existing.Spec.VolumeName != "" && pvName != "" {
+		state = fmt.Sprintf("is %s to PV %s instead of %s", existing.Status.Phase, existing.Spec.VolumeName, pvName)
+	}
+	if !recreateStale {
+		return "", fmt.Errorf("PVC %s already exists and %s; set recreate_stale_pvc to replace it", pvcName, state)
+	}
+
+	log.Printf("[WARN] PVC %s %s, recreating it", pvcName, state)
+	if err := claims.Delete(ctx, pvcName, metaV1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to delete stale PVC %s: %w", pvcName, err)
+	}
+	// The pvc-protection finalizer keeps the claim around while any pod still mounts it.
+	err = wait.PollUntilContextTimeout(ctx, 2*time.Second, 60*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := claims.Get(ctx, pvcName, metaV1.GetOptions{})
+		if isContextError(err) {
+			return false, err
+		}
+		return apierrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("stale PVC %s was not removed; is a pod still using it?", pvcName)
+	}
+	if _, err := claims.Create(ctx, pvc, metaV1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("unable to recreate PVC %s: %w", pvcName, err)
+	}
+	return fmt.Sprintf("PVC %s already existed and %s; deleted and recreated it", pvcName, state), nil
 }
 
-// createWPMySQLSecret generates random passwords and stores all needed environment variables
+// buildWPMySQLSecret generates random passwords and stores all needed environment variables
 // for both MySQL and WordPress in a single Secret.
-func createWPMySQLSecret(
-	ctx context.Context,
-	clientSet *kubernetes.Clientset,
-	namespace,
-	secretName,
-	dbSvcName string,
-) error {
+func buildWPMySQLSecret(payload RequestPayload, names stackNames) (*corev1.Secret, error) {
+	// With an external database there is no MySQL to initialise: WordPress gets the given credentials.
+	if ext := payload.ExternalDatabase; ext != nil {
+		return &corev1.Secret{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:        names.DBSecret,
+				Namespace:   payload.Namespace,
+				Labels:      stackLabels(names.DBSecret, names, componentDatabase),
+				Annotations: stackAnnotations(payload),
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				"WORDPRESS_DB_HOST":     []byte(fmt.Sprintf("%s:%d", ext.Host, ext.Port)),
+				"WORDPRESS_DB_USER":     []byte(ext.User),
+				"WORDPRESS_DB_PASSWORD": []byte(ext.Password),
+				"WORDPRESS_DB_NAME":     []byte(ext.Name),
+			},
+		}, nil
+	}
 
 	// Generate random passwords
 	rootPass, err := generateRandomPassword(16)
 	if err != nil {
-		return fmt.Errorf("failed to generate root password: %w", err)
+		return nil, fmt.Errorf("failed to generate root password: %w", err)
 	}
 	wpPass, err := generateRandomPassword(16)
 	if err != nil {
-		return fmt.Errorf("failed to generate wordpress user password: %w", err)
+		return nil, fmt.Errorf("failed to generate wordpress user password: %w", err)
 	}
 
 	secretData := map[string][]byte{
@@ -199,33 +917,68 @@ func createWPMySQLSecret(
 		"MYSQL_USER":          []byte("wordpress"),
 		"MYSQL_PASSWORD":      []byte(wpPass),
 
-		// Use dbSvcName directly
-		"WORDPRESS_DB_HOST":     []byte(dbSvcName),
+		// Use the MySQL service name directly
+		"WORDPRESS_DB_HOST":     []byte(names.DBService),
 		"WORDPRESS_DB_USER":     []byte("wordpress"),
 		"WORDPRESS_DB_PASSWORD": []byte(wpPass),
 		"WORDPRESS_DB_NAME":     []byte("wordpressdb"),
 	}
+	if payload.MySQLReadReplica {
+		replPass, err := generateRandomPassword(16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate replication password: %w", err)
+		}
+		secretData["MYSQL_REPLICATION_PASSWORD"] = []byte(replPass)
+	}
 
 	secret := &corev1.Secret{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      secretName,
-			Namespace: namespace,
+			Name:        names.DBSecret,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.DBSecret, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
 		},
 		Type: corev1.SecretTypeOpaque,
 		Data: secretData,
 	}
+	return secret, nil
+}
 
-	_, err = clientSet.CoreV1().Secrets(namespace).Create(ctx, secret, metaV1.CreateOptions{})
+// createWPMySQLSecret creates the combined MySQL/WordPress credentials Secret and copies it
+// to the external secret manager selected by secret_backend, if any.
+func createWPMySQLSecret(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	secret, err := buildWPMySQLSecret(payload, names)
 	if err != nil {
-		return fmt.Errorf("unable to create secret %s: %w", secretName, err)
+		return err
+	}
+
+	for _, sink := range secretSinks(clientSet, payload) {
+		debugf(ctx, "Writing secret %s to %s", names.DBSecret, sink.Name())
+		if err := sink.Write(ctx, secret); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// createMySQLDeployment creates a Deployment for MySQL, mounting the given PVC,
+// buildMySQLDeployment returns a Deployment for MySQL, mounting the stack's DB PVC,
 // using environment variables from the combined secret (root password, DB, user, pass).
-func createMySQLDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, deployName, pvcName, secretName string) error {
+// Version-specific mysqld flags are derived from the image tag.
+func buildMySQLDeployment(payload RequestPayload, names stackNames) (*appsv1.Deployment, error) {
+	namespace, deployName := payload.Namespace, names.DBDeployment
+	secretName, image := names.DBSecret, payload.MySQLImage
+	pvcName, subPath := stackClaim(payload, names, componentDatabase)
+
+	var resources corev1.ResourceRequirements
+	if payload.MySQLResources != nil {
+		var err error
+		resources, err = buildResourceRequirements(*payload.MySQLResources)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mysql_resources: %w", err)
+		}
+	}
 
 	envFromSource := corev1.EnvFromSource{
 		SecretRef: &corev1.SecretEnvSource{
@@ -237,14 +990,16 @@ func createMySQLDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
 
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      deployName,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app": deployName,
-			},
+			Name:        deployName,
+			Namespace:   namespace,
+			Labels:      stackLabels(deployName, names, componentDatabase),
+			Annotations: deploymentAnnotations(payload),
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: int32Ptr(1),
+			Replicas:                int32Ptr(1),
+			RevisionHistoryLimit:    payload.RevisionHistoryLimit,
+			ProgressDeadlineSeconds: payload.ProgressDeadline,
+			MinReadySeconds:         payload.MinReadySeconds,
 			Selector: &metaV1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": deployName,
@@ -252,15 +1007,18 @@ func createMySQLDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metaV1.ObjectMeta{
-					Labels: map[string]string{
-						"app": deployName,
-					},
+					Labels:      stackLabels(deployName, names, componentDatabase),
+					Annotations: podAnnotations(payload),
 				},
 				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadDeployment),
 					Containers: []corev1.Container{
 						{
-							Name:  "mysql",
-							Image: "mysql:8",
+							Name:            payload.MySQLContainerName,
+							Image:           image,
+							ImagePullPolicy: imagePullPolicy(payload, image),
+							Args:            mysqlContainerArgs(payload),
+							Resources:       resources,
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: 3306,
@@ -273,7 +1031,8 @@ func createMySQLDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "mysql-persistent-storage",
-									MountPath: "/var/lib/mysql",
+									MountPath: payload.MySQLDataPath,
+									SubPath:   subPath,
 								},
 							},
 							ReadinessProbe: &corev1.Probe{
@@ -311,24 +1070,51 @@ func createMySQLDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
 		},
 	}
 
-	_, err := clientSet.AppsV1().Deployments(namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	// Debugging aid: keep readiness but stop the kubelet killing a crashlooping container.
+	if payload.DisableLivenessProbes {
+		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = nil
+	}
+	applyProbeTuning(&deployment.Spec.Template.Spec.Containers[0], payload.ProbeTuning)
+	applyDNS(&deployment.Spec.Template.Spec, payload)
+	applyServiceAccount(&deployment.Spec.Template.Spec, payload, names)
+	deployment.Spec.Template.Spec.PriorityClassName = payload.PriorityClassName
+	mountMySQLConfig(&deployment.Spec.Template.Spec, payload, names)
+	attachMySQLBlockDevice(&deployment.Spec.Template.Spec, payload)
+
+	// The primary logs GTIDs, keeps its binlog and creates the account the read replica connects with.
+	if payload.MySQLReadReplica {
+		container := &deployment.Spec.Template.Spec.Containers[0]
+		container.Args = append(append(container.Args, mysqlReplicationArgs(1)...), mysqlPrimaryBinlogArgs...)
+		container.Command = []string{"sh", "-c", mysqlPrimaryStartScript, "sh"}
+	}
+	return deployment, nil
+}
+
+// createMySQLDeployment creates the MySQL Deployment described by buildMySQLDeployment.
+func createMySQLDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	deployment, err := buildMySQLDeployment(payload, names)
 	if err != nil {
-		return fmt.Errorf("unable to create MySQL deployment %s: %w", deployName, err)
+		return err
+	}
+	_, err = clientSet.AppsV1().Deployments(payload.Namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create MySQL deployment %s: %w", names.DBDeployment, err)
 	}
 	return nil
 }
 
-// createMySQLService creates a ClusterIP service for MySQL so WordPress can connect.
-func createMySQLService(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, svcName, deployName string) error {
+// buildMySQLService returns a ClusterIP service for MySQL so WordPress can connect.
+func buildMySQLService(payload RequestPayload, names stackNames) *corev1.Service {
+	namespace, svcName, deployName := payload.Namespace, names.DBService, names.DBDeployment
 
 	service := &corev1.Service{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      svcName,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app": deployName,
-			},
+			Name:        svcName,
+			Namespace:   namespace,
+			Labels:      stackLabels(deployName, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: map[string]string{
@@ -345,17 +1131,35 @@ func createMySQLService(ctx context.Context, clientSet *kubernetes.Clientset,
 		},
 	}
 
-	_, err := clientSet.CoreV1().Services(namespace).Create(ctx, service, metaV1.CreateOptions{})
+	return service
+}
+
+// createMySQLService creates the MySQL Service described by buildMySQLService.
+func createMySQLService(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	service := buildMySQLService(payload, names)
+	_, err := clientSet.CoreV1().Services(payload.Namespace).Create(ctx, service, metaV1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("unable to create MySQL service %s: %w", svcName, err)
+		return fmt.Errorf("unable to create MySQL service %s: %w", names.DBService, err)
 	}
 	return nil
 }
 
-// createWordPressDeployment creates a Deployment for WordPress, mounting the given PVC,
+// buildWordPressDeployment returns a Deployment for WordPress, mounting the stack's WordPress PVC,
 // also using environment variables from the same secret.
-func createWordPressDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, deployName, pvcName, secretName, dbSvcName string) error {
+func buildWordPressDeployment(payload RequestPayload, names stackNames) *appsv1.Deployment {
+	namespace, deployName := payload.Namespace, names.WPDeployment
+	secretName := names.DBSecret
+	pvcName, subPath := stackClaim(payload, names, componentWordPress)
+
+	// Installs that force HTTPS answer plain HTTP with a redirect. The image serves only port
+	// 80, so their probes say they came through a TLS-terminating proxy instead, which the
+	// image's wp-config.php honours by setting $_SERVER['HTTPS'].
+	var probeHeaders []corev1.HTTPHeader
+	if payload.ProbeScheme == string(corev1.URISchemeHTTPS) {
+		probeHeaders = []corev1.HTTPHeader{{Name: "X-Forwarded-Proto", Value: "https"}}
+	}
 
 	// Use EnvFrom to load all WORDPRESS_DB_* environment variables from the secret
 	envFromSource := corev1.EnvFromSource{
@@ -368,14 +1172,16 @@ func createWordPressDeployment(ctx context.Context, clientSet *kubernetes.Client
 
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      deployName,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app": deployName,
-			},
+			Name:        deployName,
+			Namespace:   namespace,
+			Labels:      stackLabels(deployName, names, componentWordPress),
+			Annotations: deploymentAnnotations(payload),
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: int32Ptr(1),
+			Replicas:                int32Ptr(payload.Replicas),
+			RevisionHistoryLimit:    payload.RevisionHistoryLimit,
+			ProgressDeadlineSeconds: payload.ProgressDeadline,
+			MinReadySeconds:         payload.MinReadySeconds,
 			Selector: &metaV1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": deployName,
@@ -383,36 +1189,45 @@ func createWordPressDeployment(ctx context.Context, clientSet *kubernetes.Client
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metaV1.ObjectMeta{
-					Labels: map[string]string{
-						"app": deployName,
-					},
+					Labels:      wordPressPodLabels(deployName, names),
+					Annotations: podAnnotations(payload),
 				},
 				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadDeployment),
+					// Lets Apache (www-data) write plugins and uploads to the volume. The kubelet only
+					// applies fsGroup to volume types that support ownership management, not hostPath.
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup:             payload.PodFSGroup,
+						FSGroupChangePolicy: fsGroupChangePolicyPtr(corev1.FSGroupChangeOnRootMismatch),
+					},
 					Containers: []corev1.Container{
 						{
-							Name:  "wordpress",
-							Image: "wordpress:6.7.1",
+							Name:            payload.WordPressContainerName,
+							Image:           payload.WordPressImage,
+							ImagePullPolicy: imagePullPolicy(payload, payload.WordPressImage),
+							Command:         payload.WordPressCommand,
+							Args:            payload.WordPressArgs,
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: 80,
 									Name:          "http",
 								},
 							},
-							EnvFrom: []corev1.EnvFromSource{
-								envFromSource,
-							},
+							EnvFrom: append([]corev1.EnvFromSource{envFromSource}, extraEnvFrom(payload.ExtraEnvFrom)...),
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "wordpress-persistent-storage",
-									MountPath: "/var/www/html",
+									MountPath: payload.WordPressDataPath,
+									SubPath:   subPath,
 								},
 							},
 							// More forgiving readiness probe
 							ReadinessProbe: &corev1.Probe{
 								ProbeHandler: corev1.ProbeHandler{
 									HTTPGet: &corev1.HTTPGetAction{
-										Path: "/wp-admin/install.php",
-										Port: intstr.FromInt(80),
+										Path:        "/wp-admin/install.php",
+										Port:        intstr.FromInt(80),
+										HTTPHeaders: probeHeaders,
 									},
 								},
 								// The container waits 10s before first check,
@@ -428,8 +1243,9 @@ func createWordPressDeployment(ctx context.Context, clientSet *kubernetes.Client
 							LivenessProbe: &corev1.Probe{
 								ProbeHandler: corev1.ProbeHandler{
 									HTTPGet: &corev1.HTTPGetAction{
-										Path: "/wp-admin/install.php",
-										Port: intstr.FromInt(80),
+										Path:        "/wp-admin/install.php",
+										Port:        intstr.FromInt(80),
+										HTTPHeaders: probeHeaders,
 									},
 								},
 								// The container waits 30s before first check,
@@ -458,74 +1274,438 @@ func createWordPressDeployment(ctx context.Context, clientSet *kubernetes.Client
 		},
 	}
 
-	_, err := clientSet.AppsV1().Deployments(namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	if payload.DisableLivenessProbes {
+		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = nil
+	}
+	applyProbeTuning(&deployment.Spec.Template.Spec.Containers[0], payload.ProbeTuning)
+	applyReadOnlyRootFilesystem(&deployment.Spec.Template.Spec, payload)
+	applyDNS(&deployment.Spec.Template.Spec, payload)
+	applyServiceAccount(&deployment.Spec.Template.Spec, payload, names)
+	deployment.Spec.Template.Spec.PriorityClassName = payload.PriorityClassName
+
+	// WordPress itself ignores this; split-read plugins such as HyperDB or LudicrousDB use it.
+	if payload.MySQLReadReplica {
+		deployment.Spec.Template.Spec.Containers[0].Env = append(deployment.Spec.Template.Spec.Containers[0].Env,
+			corev1.EnvVar{Name: "WORDPRESS_DB_READ_HOST", Value: names.DBReplicaService})
+	}
+	deployment.Spec.Template.Spec.Containers[0].Env = append(deployment.Spec.Template.Spec.Containers[0].Env,
+		wordPressConfigEnv(payload, names)...)
+	if payload.WPCLISidecar {
+		deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers,
+			buildWPCLISidecar(payload, names))
+	}
+	mountDBCACert(&deployment.Spec.Template.Spec, payload, names)
+	applyExtraVolumes(&deployment.Spec.Template.Spec, payload.ExtraVolumes)
+	if payload.WaitForDB {
+		deployment.Spec.Template.Spec.InitContainers = append(deployment.Spec.Template.Spec.InitContainers,
+			buildWaitForDBInitContainer(payload, names))
+	}
+
+	if ts := payload.TopologySpread; ts != nil {
+		deployment.Spec.Template.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+			{
+				MaxSkew:           ts.MaxSkew,
+				TopologyKey:       ts.TopologyKey,
+				WhenUnsatisfiable: corev1.UnsatisfiableConstraintAction(ts.WhenUnsatisfiable),
+				LabelSelector: &metaV1.LabelSelector{
+					MatchLabels: map[string]string{
+						"app": deployName,
+					},
+				},
+			},
+		}
+	}
+	return deployment
+}
+
+// wordPressConfigEnv returns the environment wp-config.php reads beyond the DB secret:
+// the object storage settings, and WORDPRESS_CONFIG_EXTRA, which the official image evaluates
+// as PHP at the end of wp-config.php. Every feature's snippet goes into that one variable.
+func wordPressConfigEnv(payload RequestPayload, names stackNames) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	var extra []string
+	if payload.ObjectStorage != nil {
+		env = append(env, objectStorageEnv(payload, names)...)
+		extra = append(extra, objectStorageConfig)
+	}
+	if payload.DBCACert != "" {
+		extra = append(extra, dbTLSConfig)
+	}
+	if payload.RedisCache {
+		extra = append(extra, redisConfig(names))
+	}
+	if len(extra) > 0 {
+		env = append(env, corev1.EnvVar{Name: "WORDPRESS_CONFIG_EXTRA", Value: strings.Join(extra, "\n")})
+	}
+	return env
+}
+
+// wordPressPodLabels returns the labels of pods served by the WordPress Service.
+func wordPressPodLabels(deployName string, names stackNames) map[string]string {
+	labels := stackLabels(deployName, names, componentWordPress)
+	labels[serviceLabel] = names.WPService
+	return labels
+}
+
+// createWordPressDeployment creates the WordPress Deployment described by buildWordPressDeployment.
+func createWordPressDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	deployment := buildWordPressDeployment(payload, names)
+	_, err := clientSet.AppsV1().Deployments(payload.Namespace).Create(ctx, deployment, metaV1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("unable to create WordPress deployment %s: %w", deployName, err)
+		return fmt.Errorf("unable to create WordPress deployment %s: %w", names.WPDeployment, err)
 	}
 	return nil
 }
 
-// createWordPressService creates a ClusterIP service for WordPress on port 80.
-func createWordPressService(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, svcName, deployName string) error {
+// defaultServicePort is the WordPress Service port when service_port is not given.
+const defaultServicePort = 80
+
+// buildWordPressService returns a ClusterIP service for WordPress on the requested port,
+// forwarding to the container's http port.
+func buildWordPressService(payload RequestPayload, names stackNames) *corev1.Service {
+	namespace, svcName, deployName := payload.Namespace, names.WPService, names.WPDeployment
 
 	service := &corev1.Service{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      svcName,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app": deployName,
-			},
+			Name:        svcName,
+			Namespace:   namespace,
+			Labels:      stackLabels(deployName, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: map[string]string{
-				"app": deployName,
+				serviceLabel: svcName,
 			},
 			Ports: []corev1.ServicePort{
 				{
-					Name:     "http",
-					Protocol: corev1.ProtocolTCP,
-					Port:     80,
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       payload.ServicePort,
+					TargetPort: intstr.FromString("http"),
 				},
 			},
 			Type: corev1.ServiceTypeClusterIP,
 		},
 	}
 
-	_, err := clientSet.CoreV1().Services(namespace).Create(ctx, service, metaV1.CreateOptions{})
+	return service
+}
+
+// createWordPressService creates the WordPress Service described by buildWordPressService.
+func createWordPressService(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	service := buildWordPressService(payload, names)
+	_, err := clientSet.CoreV1().Services(payload.Namespace).Create(ctx, service, metaV1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("unable to create WordPress service %s: %w", svcName, err)
+		return fmt.Errorf("unable to create WordPress service %s: %w", names.WPService, err)
 	}
 	return nil
 }
 
-// waitForDeploymentReady polls the deployment until it has at least one ready replica or times out.
+// isContextError reports whether err comes from a cancelled or expired context, e.g. the client
+// hung up mid-deploy. Polls give up on these instead of retrying them as transient.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// errProgressDeadlineExceeded means the deployment controller gave up on a rollout: no new
+// pod became available within the deployment's progressDeadlineSeconds.
+var errProgressDeadlineExceeded = errors.New("progress deadline exceeded")
+
+// maxMinReadySeconds caps min_ready_seconds; every deploy waits that long on each deployment.
+const maxMinReadySeconds = 300
+
+// maxProgressDeadlineSeconds bounds progress_deadline_seconds, which also sets how long a
+// synchronous deploy holds its request open waiting for the rollout.
+const maxProgressDeadlineSeconds = 1800
+
+// readinessTimeout is how long the deployer waits for a deployment: 120s plus min_ready_seconds
+// by default, or long enough for the configured progress deadline to be reported by the controller.
+func readinessTimeout(payload RequestPayload) time.Duration {
+	if d := payload.ProgressDeadline; d != nil {
+		return time.Duration(*d)*time.Second + 30*time.Second
+	}
+	return 120*time.Second + time.Duration(payload.MinReadySeconds)*time.Second
+}
+
+// waitForReady reports whether the deploy waits for its deployments to become ready, which
+// is the default.
+func waitForReady(payload RequestPayload) bool {
+	return payload.WaitForReady == nil || *payload.WaitForReady
+}
+
+// defaultPollIntervalSeconds caps the readiness poll interval when poll_interval_seconds is not given.
+const defaultPollIntervalSeconds = 5
+
+// pollInterval is the slowest the deployer polls a deployment for readiness.
+func pollInterval(payload RequestPayload) time.Duration {
+	return time.Duration(payload.PollIntervalSeconds) * time.Second
+}
+
+// pollWithBackoff runs condition every second at first, doubling the wait after each attempt
+// up to maxInterval, so fast rollouts are noticed quickly and long image pulls don't flood the
+// API server. It returns wait.ErrWaitTimeout once timeout has passed.
+func pollWithBackoff(ctx context.Context, maxInterval, timeout time.Duration, condition wait.ConditionFunc) error {
+	deadline := time.Now().Add(timeout)
+	interval := time.Second
+	for {
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if remaining := time.Until(deadline); remaining <= 0 {
+			return wait.ErrWaitTimeout
+		} else if interval > remaining {
+			interval = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// notReadyMessage explains why a deployment did not become ready, naming the controller's
+// reason when the progress deadline was exceeded.
+func notReadyMessage(what string, err error) string {
+	if errors.Is(err, errProgressDeadlineExceeded) {
+		return fmt.Sprintf("%s failed to become ready: %v", what, err)
+	}
+	return what + " failed to become ready"
+}
+
+// readyPollsRequired is how many consecutive polls must find a deployment available. MySQL's
+// first boot runs a temporary server and restarts it once the data directory is initialised,
+// so a single available reading can come just before that restart.
+const readyPollsRequired = 2
+
+// waitForDeploymentReady polls the deployment until the controller has observed its current
+// spec and it has had at least one available replica for readyPollsRequired polls in a row,
+// the controller reports ProgressDeadlineExceeded, or the timeout expires.
 func waitForDeploymentReady(ctx context.Context, clientSet *kubernetes.Clientset,
-	namespace, deployName string, timeout time.Duration) error {
+	namespace, deployName string, timeout, interval time.Duration) error {
 
 	log.Printf("[INFO] Checking readiness for deployment: %s/%s", namespace, deployName)
-	return wait.PollImmediate(5*time.Second, timeout, func() (bool, error) {
+	readyPolls := 0
+	return pollWithBackoff(ctx, interval, timeout, func() (bool, error) {
 		deploy, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, deployName, metaV1.GetOptions{})
+		if isContextError(err) {
+			return false, err
+		}
 		if err != nil {
 			log.Printf("[WARN] Error fetching deployment status: %v", err)
 			// Could be transient, keep retrying
 			return false, nil
 		}
 
-		if deploy.Status.ReadyReplicas >= 1 {
-			return true, nil
+		if deploy.Status.ObservedGeneration >= deploy.Generation && deploy.Status.AvailableReplicas >= 1 {
+			readyPolls++
+			if readyPolls >= readyPollsRequired {
+				return true, nil
+			}
+			debugf(ctx, "Deployment %s is available; confirming on the next poll", deployName)
+			return false, nil
 		}
-		log.Printf("[DEBUG] Deployment %s not ready yet. ReadyReplicas=%d, Replicas=%d",
+		readyPolls = 0
+		for _, cond := range deploy.Status.Conditions {
+			if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse &&
+				cond.Reason == "ProgressDeadlineExceeded" {
+				return false, fmt.Errorf("%w: %s", errProgressDeadlineExceeded, cond.Message)
+			}
+		}
+		debugf(ctx, "Deployment %s not ready yet. ReadyReplicas=%d, Replicas=%d",
 			deployName, deploy.Status.ReadyReplicas, deploy.Status.Replicas)
 		return false, nil
 	})
 }
 
+// errWordPressDBConnection means WordPress answers HTTP but cannot reach its database,
+// typically because the credentials in the secret don't match what MySQL was initialised with.
+var errWordPressDBConnection = errors.New("WordPress cannot connect to its database")
+
+// wpDBErrorMarker is the text WordPress renders when mysqli cannot connect.
+const wpDBErrorMarker = "Error establishing a database connection"
+
+// verifyWordPressDBConnection fetches the install page through the API server's service proxy
+// and fails with errWordPressDBConnection if WordPress reports a database connection error.
+// Other failures (e.g. the page not answering yet) are retried until the timeout.
+func verifyWordPressDBConnection(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, svcName string, timeout time.Duration) error {
+
+	log.Printf("[INFO] Verifying database connectivity through service: %s/%s", namespace, svcName)
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		var status int
+		body, err := clientSet.CoreV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource("services").
+			Name(svcName+":http").
+			SubResource("proxy").
+			Suffix("wp-admin", "install.php").
+			Do(ctx).
+			StatusCode(&status).
+			Raw()
+
+		// Non-2xx proxy responses carry the page text in the error rather than the body.
+		page := string(body)
+		if err != nil {
+			page += err.Error()
+		}
+		if strings.Contains(page, wpDBErrorMarker) {
+			return false, errWordPressDBConnection
+		}
+		if isContextError(err) {
+			return false, err
+		}
+		if err != nil {
+			lastErr = err
+			debugf(ctx, "Install page not reachable yet (status %d): %v", status, err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && !errors.Is(err, errWordPressDBConnection) && lastErr != nil {
+		return fmt.Errorf("install page did not respond: %w", lastErr)
+	}
+	return err
+}
+
+// applyProbeTuning overwrites the probe timings of a container with every non-zero tuning value.
+func applyProbeTuning(container *corev1.Container, t *ProbeTuning) {
+	if t == nil {
+		return
+	}
+	for _, probe := range []*corev1.Probe{container.ReadinessProbe, container.LivenessProbe} {
+		if probe == nil {
+			continue
+		}
+		if t.InitialDelaySeconds > 0 {
+			probe.InitialDelaySeconds = t.InitialDelaySeconds
+		}
+		if t.PeriodSeconds > 0 {
+			probe.PeriodSeconds = t.PeriodSeconds
+		}
+		if t.TimeoutSeconds > 0 {
+			probe.TimeoutSeconds = t.TimeoutSeconds
+		}
+		if t.FailureThreshold > 0 {
+			probe.FailureThreshold = t.FailureThreshold
+		}
+	}
+	if t.SuccessThreshold > 0 && container.ReadinessProbe != nil {
+		container.ReadinessProbe.SuccessThreshold = t.SuccessThreshold
+	}
+}
+
+// dnsPolicies are the values accepted for dns_policy.
+var dnsPolicies = []string{
+	string(corev1.DNSClusterFirst),
+	string(corev1.DNSClusterFirstWithHostNet),
+	string(corev1.DNSDefault),
+	string(corev1.DNSNone),
+}
+
+// applyDNS sets the requested DNS policy and resolver config on a pod spec; unset fields keep
+// the Kubernetes defaults.
+func applyDNS(spec *corev1.PodSpec, payload RequestPayload) {
+	spec.DNSPolicy = corev1.DNSPolicy(payload.DNSPolicy)
+	c := payload.DNSConfig
+	if c == nil {
+		return
+	}
+	spec.DNSConfig = &corev1.PodDNSConfig{
+		Nameservers: c.Nameservers,
+		Searches:    c.Searches,
+	}
+	for _, opt := range c.Options {
+		option := corev1.PodDNSConfigOption{Name: opt.Name}
+		if opt.Value != "" {
+			option.Value = &opt.Value
+		}
+		spec.DNSConfig.Options = append(spec.DNSConfig.Options, option)
+	}
+}
+
+// meshInjectionAnnotations opt a pod out of Istio and Linkerd sidecar injection. A sidecar
+// can hold MySQL's readiness hostage to its mTLS handshake and keeps Job pods from ever finishing.
+var meshInjectionAnnotations = map[string]string{
+	"sidecar.istio.io/inject": "false",
+	"linkerd.io/inject":       "disabled",
+}
+
+// podAnnotations returns the annotations for every pod template of the stack, or nil if none apply.
+func podAnnotations(payload RequestPayload) map[string]string {
+	if !payload.DisableMeshInjection {
+		return nil
+	}
+	annotations := make(map[string]string, len(meshInjectionAnnotations))
+	for k, v := range meshInjectionAnnotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// changeCauseAnnotation is shown in the CHANGE-CAUSE column of `kubectl rollout history`.
+const changeCauseAnnotation = "kubernetes.io/change-cause"
+
+// maxChangeCauseLength keeps change_cause to what fits a rollout history line.
+const maxChangeCauseLength = 1024
+
+// deploymentAnnotations returns the annotations of the stack's Deployments: the stack's own
+// plus the change cause, if the request gave one.
+func deploymentAnnotations(payload RequestPayload) map[string]string {
+	annotations := stackAnnotations(payload)
+	if payload.ChangeCause != "" {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[changeCauseAnnotation] = payload.ChangeCause
+	}
+	return annotations
+}
+
+// workloadKind distinguishes controllers whose pod templates need different restart policies.
+type workloadKind int
+
+const (
+	workloadDeployment workloadKind = iota
+	workloadJob                     // also covers CronJobs, which template Jobs
+)
+
+// restartPolicyFor returns the restart policy every pod template of the given kind must use.
+// Deployments only accept Always; Jobs reject Always, and OnFailure lets them retry in place.
+func restartPolicyFor(kind workloadKind) corev1.RestartPolicy {
+	if kind == workloadJob {
+		return corev1.RestartPolicyOnFailure
+	}
+	return corev1.RestartPolicyAlways
+}
+
+// fsGroupChangePolicyPtr is a simple helper for pointer values.
+func fsGroupChangePolicyPtr(p corev1.PodFSGroupChangePolicy) *corev1.PodFSGroupChangePolicy {
+	return &p
+}
+
 // int32Ptr is a simple helper for pointer values.
 func int32Ptr(i int32) *int32 {
 	return &i
 }
 
+// intPtr is a simple helper for pointer values.
+func intPtr(i int) *int {
+	return &i
+}
+
 // generateRandomPassword returns a random string of the specified length using a secure RNG.
 func generateRandomPassword(length int) (string, error) {
 	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!@#$%^&*()-_+"
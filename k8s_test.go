@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// restartPolicyAccepted mirrors the API server's validation of pod template restart policies:
+// Deployments only take Always, Jobs only OnFailure or Never.
+func restartPolicyAccepted(kind workloadKind, policy corev1.RestartPolicy) bool {
+	if kind == workloadJob {
+		return policy == corev1.RestartPolicyOnFailure || policy == corev1.RestartPolicyNever
+	}
+	return policy == corev1.RestartPolicyAlways
+}
+
+func TestRestartPolicyCombinations(t *testing.T) {
+	tests := []struct {
+		kind     workloadKind
+		policy   corev1.RestartPolicy
+		accepted bool
+	}{
+		{workloadDeployment, corev1.RestartPolicyAlways, true},
+		{workloadDeployment, corev1.RestartPolicyOnFailure, false},
+		{workloadDeployment, corev1.RestartPolicyNever, false},
+		{workloadJob, corev1.RestartPolicyAlways, false},
+		{workloadJob, corev1.RestartPolicyOnFailure, true},
+		{workloadJob, corev1.RestartPolicyNever, true},
+	}
+	for _, tt := range tests {
+		if got := restartPolicyAccepted(tt.kind, tt.policy); got != tt.accepted {
+			t.Errorf("restartPolicyAccepted(%d, %s) = %v, want %v", tt.kind, tt.policy, got, tt.accepted)
+		}
+	}
+	for _, kind := range []workloadKind{workloadDeployment, workloadJob} {
+		if policy := restartPolicyFor(kind); !restartPolicyAccepted(kind, policy) {
+			t.Errorf("restartPolicyFor(%d) = %s, which the API server rejects", kind, policy)
+		}
+	}
+}
+
+func TestBuiltPodTemplatesRestartPolicy(t *testing.T) {
+	payload := RequestPayload{
+		Namespace:        "blog",
+		DeploymentName:   "wp",
+		AutoInstall:      true,
+		WPAdminEmail:     "admin@example.com",
+		MySQLReadReplica: true,
+		PhpMyAdmin:       true,
+		RedisCache:       true,
+		Canary:           &CanaryConfig{Image: "wordpress:6.5"},
+	}
+	if status, err := preparePayload(&payload); err != nil {
+		t.Fatalf("preparePayload() = %d, %v", status, err)
+	}
+	names := stackNamesFor(payload, "abc12")
+	external := payload
+	external.MySQLReadReplica, external.DatabaseReplicas = false, 0
+	external.ExternalDatabase = &ExternalDatabase{Host: "db", Port: 3306, Name: "wp", User: "wp", Password: "secret"}
+	shared := payload
+	shared.ExternalDatabase = &ExternalDatabase{Host: "mysql", Port: 3306}
+	shared.SharedDatabase = &SharedDatabase{Service: "mysql", AdminSecret: "mysql", AdminUser: "root", AdminPasswordKey: "MYSQL_ROOT_PASSWORD"}
+
+	mysql, err := buildMySQLDeployment(payload, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := buildMySQLReplicaDeployment(payload, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := []struct {
+		name string
+		kind workloadKind
+		spec corev1.PodSpec
+	}{
+		{"mysql", workloadDeployment, mysql.Spec.Template.Spec},
+		{"mysql replica", workloadDeployment, replica.Spec.Template.Spec},
+		{"wordpress", workloadDeployment, buildWordPressDeployment(payload, names).Spec.Template.Spec},
+		{"wordpress canary", workloadDeployment, buildWordPressCanaryDeployment(payload, names).Spec.Template.Spec},
+		{"phpmyadmin", workloadDeployment, buildPhpMyAdminDeployment(payload, names).Spec.Template.Spec},
+		{"redis", workloadDeployment, buildRedisDeployment(payload, names).Spec.Template.Spec},
+		{"wp-cli install", workloadJob, buildWPCLIJob(payload, names, names.WPInstallJob, wpInstallScript, nil).Spec.Template.Spec},
+		{"wp-cli extensions", workloadJob, buildWPExtensionsJob(payload, names).Spec.Template.Spec},
+		{"external database check", workloadJob, buildExternalDBCheckJob(external, names).Spec.Template.Spec},
+		{"shared database provisioning", workloadJob, buildSharedDBProvisionJob(shared, names).Spec.Template.Spec},
+		{"shared volume cleanup", workloadJob, buildSharedDataCleanupJob(payload.Namespace, names).Spec.Template.Spec},
+	}
+	for _, tt := range templates {
+		if !restartPolicyAccepted(tt.kind, tt.spec.RestartPolicy) {
+			t.Errorf("%s pod template has restart policy %q", tt.name, tt.spec.RestartPolicy)
+		}
+	}
+}
+
+func TestMySQLReplicationKeepsBinlogAndEnsuresUser(t *testing.T) {
+	payload := RequestPayload{Namespace: "blog", DeploymentName: "wp", MySQLReadReplica: true}
+	if status, err := preparePayload(&payload); err != nil {
+		t.Fatalf("preparePayload() = %d, %v", status, err)
+	}
+	names := stackNamesFor(payload, "abc12")
+	primary, err := buildMySQLDeployment(payload, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := buildMySQLReplicaDeployment(payload, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container := primary.Spec.Template.Spec.Containers[0]
+	if !containsString(container.Args, "--binlog-expire-logs-seconds=0") {
+		t.Errorf("primary args = %q, want the binlog kept", container.Args)
+	}
+	if want := []string{"sh", "-c", mysqlPrimaryStartScript, "sh"}; !reflect.DeepEqual(container.Command, want) {
+		t.Errorf("primary command = %q, want the start script that ensures the replication user", container.Command)
+	}
+	for _, volume := range primary.Spec.Template.Spec.Volumes {
+		if volume.Name == "mysql-init" {
+			t.Error("primary mounts init scripts, which only run on an empty data directory")
+		}
+	}
+
+	container = replica.Spec.Template.Spec.Containers[0]
+	if container.Command != nil {
+		t.Errorf("replica command = %q, want the image default", container.Command)
+	}
+	if containsString(container.Args, "--binlog-expire-logs-seconds=0") {
+		t.Errorf("replica args = %q, want only the primary to keep its binlog", container.Args)
+	}
+	mounted := false
+	for _, mount := range container.VolumeMounts {
+		mounted = mounted || mount.MountPath == mysqlInitDir
+	}
+	if !mounted {
+		t.Error("replica does not mount its init script")
+	}
+}
+
+func TestUnusedStackNamesRetriesCollisions(t *testing.T) {
+	payload := RequestPayload{Namespace: "blog", DeploymentName: "wp"}
+	taken := func(suffix string) runtime.Object {
+		return &appsv1.Deployment{ObjectMeta: metaV1.ObjectMeta{
+			Name:      stackNamesFor(payload, suffix).WPDeployment,
+			Namespace: payload.Namespace,
+		}}
+	}
+
+	tests := []struct {
+		name       string
+		suffix     string
+		existing   []runtime.Object
+		generated  []string // Suffixes randSuffixFunc returns, in order
+		wantSuffix string
+		wantErr    error
+		wantCalls  int
+	}{
+		{"free at once", "aaaaa", nil, nil, "aaaaa", nil, 0},
+		{"free after a collision", "aaaaa", []runtime.Object{taken("aaaaa")}, []string{"bbbbb"}, "bbbbb", nil, 1},
+		{"free after two collisions", "aaaaa", []runtime.Object{taken("aaaaa"), taken("bbbbb")},
+			[]string{"bbbbb", "ccccc"}, "ccccc", nil, 2},
+		{"every suffix taken", "aaaaa", []runtime.Object{taken("aaaaa")},
+			[]string{"aaaaa", "aaaaa", "aaaaa", "aaaaa"}, "", errStackNamesTaken, maxSuffixAttempts - 1},
+		{"fixed names taken", "", []runtime.Object{taken("")}, nil, "", errStackNamesTaken, 0},
+	}
+	defer func(original func(int) (string, error)) { randSuffixFunc = original }(randSuffixFunc)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			randSuffixFunc = func(n int) (string, error) {
+				if calls >= len(tt.generated) {
+					t.Fatalf("randSuffixFunc called %d times, want at most %d", calls+1, len(tt.generated))
+				}
+				calls++
+				return tt.generated[calls-1], nil
+			}
+			clientSet := fake.NewSimpleClientset(tt.existing...)
+			names, err := unusedStackNames(context.Background(), clientSet, payload, stackNamesFor(payload, tt.suffix))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unusedStackNames() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && names.Suffix != tt.wantSuffix {
+				t.Errorf("unusedStackNames() suffix = %q, want %q", names.Suffix, tt.wantSuffix)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("randSuffixFunc called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCanaryServesItsOwnCore(t *testing.T) {
+	payload := RequestPayload{
+		Namespace:      "blog",
+		DeploymentName: "wp",
+		WPCLISidecar:   true,
+		Canary:         &CanaryConfig{Image: "wordpress:6.5"},
+	}
+	if status, err := preparePayload(&payload); err != nil {
+		t.Fatalf("preparePayload() = %d, %v", status, err)
+	}
+	names := stackNamesFor(payload, "abc12")
+	_, subPath := stackClaim(payload, names, componentWordPress)
+
+	spec := buildWordPressCanaryDeployment(payload, names).Spec.Template.Spec
+	for _, container := range spec.Containers {
+		var mounts []corev1.VolumeMount
+		for _, mount := range container.VolumeMounts {
+			if mount.Name == canaryCoreVolume || mount.Name == "wordpress-persistent-storage" {
+				mounts = append(mounts, mount)
+			}
+		}
+		want := []corev1.VolumeMount{
+			{Name: canaryCoreVolume, MountPath: "/var/www/html"},
+			{Name: "wordpress-persistent-storage", MountPath: "/var/www/html/wp-content", SubPath: path.Join(subPath, "wp-content")},
+		}
+		if !reflect.DeepEqual(mounts, want) {
+			t.Errorf("container %s mounts = %+v, want %+v", container.Name, mounts, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// logLevel orders the [DEBUG], [INFO], [WARN] and [ERROR] tags the log lines start with.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevelTags maps each level to its tag in log lines.
+var logLevelTags = map[logLevel]string{
+	levelDebug: "[DEBUG]",
+	levelInfo:  "[INFO]",
+	levelWarn:  "[WARN]",
+	levelError: "[ERROR]",
+}
+
+// parseLogLevel reads LOG_LEVEL: debug, info (the default when empty), warn or error.
+func parseLogLevel(value string) (logLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return levelDebug, nil
+	case "", "info":
+		return levelInfo, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q; use debug, info, warn or error", value)
+}
+
+// levelFilter drops log lines whose tag is below min. Untagged lines count as info.
+type levelFilter struct {
+	out io.Writer
+	min logLevel
+}
+
+func (f *levelFilter) Write(p []byte) (int, error) {
+	if lineLevel(p) < f.min {
+		return len(p), nil
+	}
+	return f.out.Write(p)
+}
+
+// lineLevel returns the level of the first tag in a log line, after the log package's date prefix.
+func lineLevel(line []byte) logLevel {
+	level, first := levelInfo, -1
+	for l, tag := range logLevelTags {
+		if i := bytes.Index(line, []byte(tag)); i >= 0 && (first < 0 || i < first) {
+			level, first = l, i
+		}
+	}
+	return level
+}
+
+// minLogLevel is the level set by LOG_LEVEL; set up by setupLogging.
+var minLogLevel = levelInfo
+
+// verboseLog writes the debug lines of verbose requests, which bypass the level filter.
+var verboseLog = log.New(os.Stderr, "", log.LstdFlags)
+
+// setupLogging filters the standard logger's output to the level named by value.
+func setupLogging(value string) error {
+	level, err := parseLogLevel(value)
+	if err != nil {
+		return err
+	}
+	minLogLevel = level
+	log.SetOutput(&levelFilter{out: os.Stderr, min: level})
+	return nil
+}
+
+// verboseKey marks the context of a request that asked for verbose logs.
+type verboseKey struct{}
+
+// withVerbose returns ctx marked so debugf logs for it whatever LOG_LEVEL says.
+func withVerbose(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verboseKey{}, true)
+}
+
+// debugf logs a [DEBUG] line when LOG_LEVEL is debug, or when ctx belongs to a request
+// with verbose set, so one request can be traced without raising the level for all.
+func debugf(ctx context.Context, format string, args ...any) {
+	if minLogLevel <= levelDebug {
+		log.Printf("[DEBUG] "+format, args...)
+		return
+	}
+	if verbose, _ := ctx.Value(verboseKey{}).(bool); verbose {
+		verboseLog.Printf("[DEBUG] "+format, args...)
+	}
+}
@@ -1,37 +1,344 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
+	"path"
+	"reflect"
 	"strings"
+	"text/template"
 	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // RequestPayload defines the JSON structure we expect in the request body.
 type RequestPayload struct {
-	Kubeconfig        string `json:"kubeconfig,omitempty"`            // Optional; if not provided, use in-cluster or ~/.kube/config
-	Namespace         string `json:"namespace,omitempty"`             // Required
+	Kubeconfig        string `json:"kubeconfig,omitempty"`            // Path or inline YAML; if not provided, use in-cluster or ~/.kube/config
+	KubeContext       string `json:"context,omitempty"`               // Context of the kubeconfig to use instead of its current one
+	Namespace         string `json:"namespace,omitempty"`             // Required unless namespace_per_deployment is set
 	PersistenceDiskGB int    `json:"persistence_disk_size,omitempty"` // WordPress disk size in GB
 	DatabaseDiskGB    int    `json:"database_disk_size,omitempty"`    // Database disk size in GB
 	DeploymentName    string `json:"deployment_name,omitempty"`       // User-supplied prefix (can be empty)
+	SuffixLength      *int   `json:"suffix_length,omitempty"`         // Random name suffix length; defaults to 5, 0 disables it
+	MySQLImage        string `json:"mysql_image,omitempty"`           // MySQL image; defaults to mysql:8
+	WordPressImage    string `json:"wordpress_image,omitempty"`       // WordPress image, by tag or digest; defaults to wordpress:6.7.1
+	Output            string `json:"output,omitempty"`                // "apply" (default) creates resources; "manifest" only renders YAML
+
+	MySQLContainerName     string `json:"mysql_container_name,omitempty"`     // Name of the MySQL container; defaults to "mysql"
+	WordPressContainerName string `json:"wordpress_container_name,omitempty"` // Name of the WordPress container; defaults to "wordpress"
+
+	NamespaceLabels      map[string]string `json:"namespace_labels,omitempty"`      // Merged onto the namespace, new or existing
+	NamespaceAnnotations map[string]string `json:"namespace_annotations,omitempty"` // Merged onto the namespace, new or existing
+	NamespaceQuota       *NamespaceQuota   `json:"namespace_quota,omitempty"`       // ResourceQuota for a namespace the deployer creates
+
+	// NamespacePerDeployment deploys into a new namespace named "<deployment_name>-<suffix>"
+	// instead of the given one, so deleting that namespace removes the whole stack.
+	NamespacePerDeployment bool `json:"namespace_per_deployment,omitempty"`
+
+	MySQLResources          *ResourceSpec `json:"mysql_resources,omitempty"`             // Requests/limits for the MySQL container
+	MySQLInnoDBBufferPoolMB int           `json:"mysql_innodb_buffer_pool_mb,omitempty"` // Defaults to half the MySQL memory limit, if any
+	MySQLReadReplica        bool          `json:"mysql_read_replica,omitempty"`          // Adds a GTID read replica behind its own Service; MySQL 8.0.23+ only
+	DatabaseReplicas        int32         `json:"database_replicas,omitempty"`           // MySQL pods, the primary included; defaults to 1, or 2 with mysql_read_replica
+	MySQLArgs               []string      `json:"mysql_args,omitempty"`                  // Extra mysqld flags, appended after the built-in ones
+	MySQLConfig             string        `json:"mysql_config,omitempty"`                // my.cnf contents, mounted at /etc/mysql/conf.d/custom.cnf
+
+	// VolumeMode "Block" gives MySQL the database claim as a raw device at /dev/mysql-data for
+	// InnoDB raw partitions set up in mysql_config; the data directory is then an emptyDir.
+	VolumeMode string `json:"volume_mode,omitempty"` // "Filesystem" (default) or "Block"
+
+	// ExternalDatabase replaces the bundled MySQL; CheckExternalDatabase logs in to it
+	// from a short-lived Job before WordPress is deployed.
+	ExternalDatabase      *ExternalDatabase `json:"external_database,omitempty"`
+	CheckExternalDatabase bool              `json:"check_external_database,omitempty"`
+	SharedDatabase        *SharedDatabase   `json:"shared_database,omitempty"` // A database and user of the stack's own on a MySQL already in the namespace
+	DBCACert              string            `json:"db_ca_cert,omitempty"`      // PEM CA bundle; WordPress then connects over TLS
+	PhpMyAdmin            bool              `json:"phpmyadmin,omitempty"`      // Adds phpMyAdmin for the stack's database; log in with the DB credentials
+	RedisCache            bool              `json:"redis_cache,omitempty"`     // Adds Redis as object cache; with auto_install its plugin is enabled too
+
+	ObjectStorage *ObjectStorage `json:"object_storage,omitempty"` // Offloads uploads to S3; with auto_install the plugin is installed too
+	Gateway       *GatewayConfig `json:"gateway,omitempty"`        // Routes an existing Gateway API Gateway to WordPress
+	SaveManifest  bool           `json:"save_manifest,omitempty"`  // Keeps the created resources in a ConfigMap, served by /stacks/{id}/manifest
+
+	// ServiceAccount runs the stack's pods as a ServiceAccount of their own instead of the
+	// namespace's default one. They only get an API token when ServiceAccountRules grant it
+	// access, through a Role and RoleBinding named like the ServiceAccount.
+	ServiceAccount      bool         `json:"service_account,omitempty"`
+	ServiceAccountRules []PolicyRule `json:"service_account_rules,omitempty"`
+
+	// SecretBackend "vault" also writes the generated database credentials to Vault. The
+	// Kubernetes Secret is created either way, as the pods read their credentials from it.
+	SecretBackend string       `json:"secret_backend,omitempty"` // "kubernetes" (default) or "vault"
+	Vault         *VaultConfig `json:"vault,omitempty"`
+
+	// SecretMode "external-secret" has the external-secrets operator write the database Secret
+	// from a remote key instead of the deployer generating it; the key must hold every property
+	// the generated Secret would have.
+	SecretMode     string                `json:"secret_mode,omitempty"` // "managed" (default) or "external-secret"
+	ExternalSecret *ExternalSecretConfig `json:"external_secret,omitempty"`
+
+	RevisionHistoryLimit *int32        `json:"revision_history_limit,omitempty"`    // Old ReplicaSets kept per deployment; defaults to 3
+	ChangeCause          string        `json:"change_cause,omitempty"`              // Recorded as kubernetes.io/change-cause for `kubectl rollout history`
+	ProgressDeadline     *int32        `json:"progress_deadline_seconds,omitempty"` // Deployment progressDeadlineSeconds; Kubernetes defaults to 600
+	MinReadySeconds      int32         `json:"min_ready_seconds,omitempty"`         // Seconds a new pod must stay ready before it counts as available
+	PollIntervalSeconds  int           `json:"poll_interval_seconds,omitempty"`     // Slowest readiness poll; checks start at 1s and back off to it. Defaults to 5
+	Replicas             int32         `json:"replicas,omitempty"`                  // WordPress replicas; defaults to 1
+	Canary               *CanaryConfig `json:"canary,omitempty"`                    // Second WordPress deployment sharing the Service
+	ServicePort          int32         `json:"service_port,omitempty"`              // Port the WordPress Service listens on; defaults to 80
+	PodFSGroup           *int64        `json:"pod_fs_group,omitempty"`              // Group owning the WordPress volume; defaults to www-data (33)
+	VerifyDBConnection   bool          `json:"verify_db_connection,omitempty"`      // After readiness, check WordPress can actually reach MySQL
+
+	MySQLDataPath     string `json:"mysql_data_path,omitempty"`     // MySQL volume mount path; defaults to /var/lib/mysql
+	WordPressDataPath string `json:"wordpress_data_path,omitempty"` // WordPress volume mount path; defaults to /var/www/html
+
+	// Override the WordPress image's entrypoint and command, e.g. to run a setup script first.
+	WordPressCommand []string `json:"wordpress_command,omitempty"`
+	WordPressArgs    []string `json:"wordpress_args,omitempty"`
+
+	// ReadOnlyRootFilesystem runs the WordPress container with a read-only root filesystem, as
+	// hardened clusters require; /tmp and Apache's run and lock directories get emptyDirs, and
+	// the WordPress volume stays writable. Only the official wordpress images are verified.
+	ReadOnlyRootFilesystem bool `json:"read_only_root_filesystem,omitempty"`
+
+	ExtraVolumes []ExtraVolume `json:"extra_volumes,omitempty"`  // Existing ConfigMaps/Secrets mounted read-only into WordPress
+	ExtraEnvFrom []EnvSource   `json:"extra_env_from,omitempty"` // Existing ConfigMaps/Secrets loaded as WordPress environment
+
+	// SharedVolume mounts one namespace-wide PV/PVC with a subPath per stack instead of
+	// creating PVs per stack. SharedVolumeGB sizes it when it doesn't exist yet. Deleting the
+	// stack with keep_data false removes its directory from the shared volume.
+	SharedVolume   bool `json:"shared_volume,omitempty"`
+	SharedVolumeGB int  `json:"shared_volume_size,omitempty"`
+
+	RecreateStalePVC bool `json:"recreate_stale_pvc,omitempty"` // Replace a same-named PVC bound elsewhere instead of failing
+	ReuseExistingPV  bool `json:"reuse_existing_pv,omitempty"`  // Reuse a same-named hostPath PV of the same size and path instead of failing with 409
+	ParallelVolumes  bool `json:"parallel_volumes,omitempty"`   // Create the MySQL and WordPress volumes concurrently
+
+	// HostNode pins the hostPath PVs, and with them the MySQL and WordPress pods, to the node
+	// holding their directories. Without it a rescheduled pod may start on a node with an empty one.
+	HostNode string `json:"host_node,omitempty"`
+
+	// HostPathType "Directory" requires the hostPath directories to exist on the node already, e.g.
+	// pre-provisioned with the right owner; the default "DirectoryOrCreate" creates missing ones.
+	HostPathType string `json:"host_path_type,omitempty"`
+
+	// DynamicProvisioning lets a StorageClass provision the stack's volumes instead of creating
+	// hostPath PVs. StorageClass picks the class and implies it; empty uses the cluster default.
+	// WordPressStorageClass and DatabaseStorageClass override it for one component's claim,
+	// e.g. fast SSD for MySQL and cheaper storage for uploads, and imply it too.
+	DynamicProvisioning   bool   `json:"dynamic_provisioning,omitempty"`
+	StorageClass          string `json:"storage_class,omitempty"`
+	WordPressStorageClass string `json:"wordpress_storage_class,omitempty"`
+	DatabaseStorageClass  string `json:"database_storage_class,omitempty"`
+	PVCBindTimeout        int    `json:"pvc_bind_timeout_seconds,omitempty"` // Wait for provisioned PVCs to bind; defaults to 120
+
+	DisableLivenessProbes bool         `json:"disable_liveness_probes,omitempty"` // Debugging aid: keep readiness, drop liveness on both containers
+	ProbeTuning           *ProbeTuning `json:"probe_tuning,omitempty"`            // Overrides probe timings on both containers
+	ProbeScheme           string       `json:"probe_scheme,omitempty"`            // HTTPS makes the WordPress probes send X-Forwarded-Proto: https
+
+	TopologySpread       *TopologySpread `json:"topology_spread,omitempty"`        // Spreads WordPress replicas across zones/nodes
+	DisableMeshInjection bool            `json:"disable_mesh_injection,omitempty"` // Keeps Istio/Linkerd sidecars out of the stack's pods
+	PriorityClassName    string          `json:"priority_class_name,omitempty"`    // Protects the MySQL and WordPress pods from preemption
+
+	DNSPolicy string     `json:"dns_policy,omitempty"` // ClusterFirst (default), ClusterFirstWithHostNet, Default or None
+	DNSConfig *DNSConfig `json:"dns_config,omitempty"` // Extra resolver settings; required with dns_policy None
+
+	// Automated install: once WordPress is ready, run `wp core install` so the site skips the wizard.
+	AutoInstall     bool   `json:"auto_install,omitempty"`
+	WPAdminUser     string `json:"wp_admin_user,omitempty"`     // Defaults to "admin"
+	WPAdminPassword string `json:"wp_admin_password,omitempty"` // Generated when empty
+	WPAdminEmail    string `json:"wp_admin_email,omitempty"`    // Required with auto_install
+	WPSiteTitle     string `json:"wp_site_title,omitempty"`     // Defaults to "WordPress"
+	WPSiteURL       string `json:"wp_site_url,omitempty"`       // Defaults to the in-cluster service URL
+	WPAdminDelivery string `json:"wp_admin_delivery,omitempty"` // "response" (default) returns wp_admin; "claim" (always with async) a one-time wp_admin_claim token
+
+	// WPCLISidecar adds a wp-cli container to the WordPress pods for `kubectl exec`.
+	// WPCLIImage is used for it and for the install Jobs; defaults to wordpress:cli.
+	WPCLISidecar bool   `json:"wp_cli_sidecar,omitempty"`
+	WPCLIImage   string `json:"wp_cli_image,omitempty"`
+
+	// WaitForDB adds an init container that holds WordPress pods back until the database
+	// accepts connections. WaitForDBImage needs sh and nc; defaults to busybox.
+	WaitForDB      bool   `json:"wait_for_database,omitempty"`
+	WaitForDBImage string `json:"wait_for_database_image,omitempty"`
+
+	// Installed from wordpress.org after auto_install; plugins are also activated.
+	WPPlugins []string `json:"wp_plugins,omitempty"`
+	WPThemes  []string `json:"wp_themes,omitempty"`
+
+	// Async returns 202 with a job ID right away; the result is polled at /jobs/{id}
+	// or, with CallbackURL, POSTed there when the deploy finishes or fails.
+	Async       bool   `json:"async,omitempty"`
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// WaitForReady, true unless set to false, waits for the deployments to become ready. When
+	// false the deploy returns as soon as everything is created; poll /status for readiness.
+	WaitForReady *bool `json:"wait_for_ready,omitempty"`
+
+	// NodeCapacityCheck compares the combined disk sizes with node ephemeral storage before
+	// creating the hostPath PVs: "warn" adds a warning, "reject" fails the deploy. Off when empty.
+	NodeCapacityCheck string `json:"node_capacity_check,omitempty"`
+
+	// ImageArchCheck compares the nodes' kubernetes.io/arch labels with the architectures the
+	// stack's images are known to be published for, and warns about likely mismatches.
+	ImageArchCheck bool `json:"image_arch_check,omitempty"`
+
+	// ImagePullCheck pulls the stack's images in a short-lived pod before creating anything else,
+	// and fails the deploy with 422 if one of them cannot be pulled.
+	ImagePullCheck bool `json:"image_pull_check,omitempty"`
+
+	// ImagePullPolicy sets the pull policy of every stack container: "Always", "IfNotPresent" or
+	// "Never". When empty, images pinned by digest use IfNotPresent and the rest the Kubernetes default.
+	ImagePullPolicy string `json:"image_pull_policy,omitempty"`
+
+	// PrepullImages pulls the stack's images onto every node with a short-lived DaemonSet before
+	// creating anything else, so the deployments don't wait on first pulls to become ready.
+	// Pulls that fail or don't finish in time only add a warning.
+	PrepullImages bool `json:"prepull_images,omitempty"`
+
+	// Verbose logs this request's debug lines whatever LOG_LEVEL is, to trace one deploy.
+	Verbose bool `json:"verbose,omitempty"`
+
+	// NameTemplate replaces the "<deployment_name>-<suffix>-<type>" resource names with a Go
+	// text/template over {{.Prefix}}, {{.Suffix}}, {{.Type}} and {{.Namespace}}. Later calls on
+	// the stack must pass it again. nameTemplate is parsed from it by preparePayload.
+	NameTemplate string `json:"name_template,omitempty"`
+	nameTemplate *template.Template
+
+	// TTLSeconds marks the stack as ephemeral: its resources carry an expiry annotation and the
+	// TTL reaper, when enabled with TTL_REAPER_INTERVAL, deletes the stack once it has passed.
+	// expiresAt is set from it by preparePayload.
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	expiresAt  time.Time
+}
+
+// DNSConfig adds resolver settings to the stack's pods, e.g. a private nameserver for an external database.
+type DNSConfig struct {
+	Nameservers []string    `json:"nameservers,omitempty"` // IP addresses
+	Searches    []string    `json:"searches,omitempty"`
+	Options     []DNSOption `json:"options,omitempty"`
+}
+
+// DNSOption is a resolver option such as ndots:2.
+type DNSOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// TopologySpread spreads the WordPress pods over a topology domain such as zones or nodes.
+type TopologySpread struct {
+	TopologyKey       string `json:"topology_key,omitempty"`       // Defaults to topology.kubernetes.io/zone
+	MaxSkew           int32  `json:"max_skew,omitempty"`           // Defaults to 1
+	WhenUnsatisfiable string `json:"when_unsatisfiable,omitempty"` // DoNotSchedule or ScheduleAnyway (default)
+}
+
+// ProbeTuning overrides the timings of the liveness and readiness probes; zero fields keep the defaults.
+// SuccessThreshold only applies to readiness probes, since Kubernetes requires 1 for liveness.
+type ProbeTuning struct {
+	InitialDelaySeconds int32 `json:"initial_delay_seconds,omitempty"`
+	PeriodSeconds       int32 `json:"period_seconds,omitempty"`
+	TimeoutSeconds      int32 `json:"timeout_seconds,omitempty"`
+	FailureThreshold    int32 `json:"failure_threshold,omitempty"`
+	SuccessThreshold    int32 `json:"success_threshold,omitempty"`
+}
+
+// ResourceSpec holds container requests and limits as Kubernetes quantities (e.g. "500m", "1Gi").
+type ResourceSpec struct {
+	CPURequest    string `json:"cpu_request,omitempty"`
+	CPULimit      string `json:"cpu_limit,omitempty"`
+	MemoryRequest string `json:"memory_request,omitempty"`
+	MemoryLimit   string `json:"memory_limit,omitempty"`
 }
 
 // APIResponse defines the JSON structure we return upon success/failure.
 type APIResponse struct {
 	Success   bool     `json:"success"`
 	Message   string   `json:"message"`
-	Resources []string `json:"resources,omitempty"` // Summaries of created resources
+	Resources []string `json:"resources,omitempty"` // Deprecated: human-readable summaries; use ResourceRefs
+	Suffix    string   `json:"suffix,omitempty"`    // Random suffix identifying the stack in later calls
+	Namespace string   `json:"namespace,omitempty"` // Set when the namespace was generated by namespace_per_deployment
+	SiteURL   string   `json:"site_url,omitempty"`  // Where the site answers once deployed
+	JobID     string   `json:"job_id,omitempty"`    // Set for async deploys
+	Warnings  []string `json:"warnings,omitempty"`  // Non-fatal problems found while deploying
+	Errors    []string `json:"errors,omitempty"`    // Field-level problems with the request payload
+
+	WordPressInstalled *bool             `json:"wordpress_installed,omitempty"` // Set when auto_install was requested
+	WPAdmin            *AdminCredentials `json:"wp_admin,omitempty"`            // Set when auto_install succeeded
+	WPAdminClaim       *AdminClaim       `json:"wp_admin_claim,omitempty"`      // Replaces wp_admin with wp_admin_delivery "claim"
+	Extensions         []ExtensionResult `json:"extensions,omitempty"`          // Per plugin/theme outcome
+	Endpoints          []string          `json:"endpoints,omitempty"`           // Valid endpoints, returned on 404
+	Manifest           string            `json:"manifest,omitempty"`            // Multi-document YAML, returned when output is "manifest"; contains the passwords
+
+	Namespaces     []NamespaceSummary    `json:"namespaces,omitempty"`      // Returned by GET /namespaces
+	StorageClasses []StorageClassSummary `json:"storage_classes,omitempty"` // Returned by GET /storageclasses
+	Status         *StackStatus          `json:"status,omitempty"`          // Returned by GET /status
+	Diagnosis      *Diagnosis            `json:"diagnosis,omitempty"`       // Returned by GET /diagnose
+	Resize         *ResizeResult         `json:"resize,omitempty"`          // Returned by POST /resize
+	Maintenance    *MaintenanceResult    `json:"maintenance,omitempty"`     // Returned by POST /maintenance
+	Job            *DeployJob            `json:"job,omitempty"`             // Returned by GET /jobs/{id}
+	Deleted        []DeletionResult      `json:"deleted,omitempty"`         // Per-resource report of the delete endpoints
+
+	ResourceRefs []ResourceRef `json:"resource_refs,omitempty"` // The resources of Resources, by kind, name and namespace
+}
+
+// Supported values for RequestPayload.Output.
+const (
+	outputApply    = "apply"
+	outputManifest = "manifest"
+)
+
+// Supported values for RequestPayload.NodeCapacityCheck.
+const (
+	capacityCheckWarn   = "warn"
+	capacityCheckReject = "reject"
+)
+
+// route binds a path to the single HTTP method it accepts and its handler.
+type route struct {
+	Path    string
+	Method  string
+	Handler http.HandlerFunc
+}
+
+// routes lists every endpoint served by the API.
+var routes = []route{
+	{Path: "/create-wordpress", Method: http.MethodPost, Handler: withIdempotency(handleCreateWordPress)},
+	{Path: "/namespaces", Method: http.MethodGet, Handler: handleListNamespaces},
+	{Path: "/storageclasses", Method: http.MethodGet, Handler: handleListStorageClasses},
+	{Path: "/status", Method: http.MethodGet, Handler: handleStackStatus},
+	{Path: "/diagnose", Method: http.MethodGet, Handler: handleDiagnose},
+	{Path: "/jobs/{id}", Method: http.MethodGet, Handler: handleGetJob},
+	{Path: "/schema", Method: http.MethodGet, Handler: handleSchema},
+	{Path: "/delete", Method: http.MethodPost, Handler: handleDeleteStack},
+	{Path: "/delete-all", Method: http.MethodPost, Handler: handleDeleteAll},
+	{Path: "/reconcile", Method: http.MethodPost, Handler: handleReconcile},
+	{Path: "/resize", Method: http.MethodPost, Handler: handleResize},
+	{Path: "/maintenance", Method: http.MethodPost, Handler: handleMaintenance},
+	{Path: "/stacks/{id}/manifest", Method: http.MethodGet, Handler: handleStackManifest},
+	{Path: "/claim/{token}", Method: http.MethodPost, Handler: handleClaim},
 }
 
 func main() {
+	// LOG_LEVEL (debug, info, warn or error; default info) drops less severe log lines.
+	if err := setupLogging(os.Getenv("LOG_LEVEL")); err != nil {
+		log.Fatalf("Invalid LOG_LEVEL: %v", err)
+	}
 	log.Println("Starting WordPress deployment API service...")
-	http.HandleFunc("/create-wordpress", handleCreateWordPress)
 
 	// You can set the port using the PORT environment variable; default is 8080.
 	port := os.Getenv("PORT")
@@ -39,315 +346,2171 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Browser clients need CORS_ALLOWED_ORIGINS (comma-separated, or "*"); CORS is off when unset.
+	allowedOrigins := parseAllowedOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if len(allowedOrigins) > 0 {
+		log.Printf("CORS enabled for origins: %s", strings.Join(allowedOrigins, ", "))
 	}
-}
 
-// handleCreateWordPress is our main handler for receiving JSON requests to deploy the stack.
-func handleCreateWordPress(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "Only POST is allowed",
-		})
-		return
+	// TTL_REAPER_INTERVAL sets how often expired stacks are looked for, e.g. "1m"; the reaper is off when unset or "0".
+	// REAP_ORPHANED_PVS=true makes it also delete PVs whose namespace was deleted.
+	reapPVs, err := parseReapOrphanedPVs(os.Getenv("REAP_ORPHANED_PVS"))
+	if err != nil {
+		log.Fatalf("Invalid REAP_ORPHANED_PVS: %v", err)
 	}
-
-	decoder := json.NewDecoder(r.Body)
-	var payload RequestPayload
-	if err := decoder.Decode(&payload); err != nil {
-		log.Printf("[ERROR] Failed to decode request body: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "Invalid JSON payload",
-		})
-		return
+	if interval, err := reaperInterval(os.Getenv("TTL_REAPER_INTERVAL")); err != nil {
+		log.Fatalf("Invalid TTL_REAPER_INTERVAL: %v", err)
+	} else if interval > 0 {
+		go runTTLReaper(interval, reapPVs)
 	}
 
-	// Basic validation
-	if payload.Namespace == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "namespace is required",
-		})
-		return
+	// RATE_LIMIT_RPS caps requests per second per client IP, with bursts of RATE_LIMIT_BURST; off when unset.
+	limit, burst, err := parseRateLimit(os.Getenv("RATE_LIMIT_RPS"), os.Getenv("RATE_LIMIT_BURST"))
+	if err != nil {
+		log.Fatalf("Invalid rate limit: %v", err)
 	}
-
-	// If user did not provide deployment_name, default to "wp"
-	if strings.TrimSpace(payload.DeploymentName) == "" {
-		payload.DeploymentName = "wp"
+	if limit > 0 {
+		log.Printf("Rate limiting clients to %g requests/s, bursts of %d", float64(limit), burst)
 	}
 
-	if payload.PersistenceDiskGB <= 0 {
-		payload.PersistenceDiskGB = 5 // default disk size for WordPress
-	}
-	if payload.DatabaseDiskGB <= 0 {
-		payload.DatabaseDiskGB = 5 // default disk size for Database
+	log.Printf("Listening on port %s", port)
+	handler := corsMiddleware(allowedOrigins, rateLimitMiddleware(limit, burst, newRouter()))
+	if err := http.ListenAndServe(":"+port, accessLogMiddleware(handler)); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
 	}
+}
 
-	// Generate a random 5-character suffix for uniqueness
-	suffix, err := generateRandomSuffix(5)
-	if err != nil {
-		log.Printf("[ERROR] Failed to generate random suffix: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "Could not generate unique suffix",
-		})
-		return
+// newRouter registers every route behind a method check and adds a JSON catch-all for unknown paths.
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.Handle(rt.Path, allowMethod(rt.Method, rt.Handler))
 	}
+	mux.HandleFunc("/", handleNotFound)
+	return mux
+}
 
-	// Log the start of the process
-	log.Printf("[INFO] Received request to deploy WordPress: %+v", payload)
-	log.Printf("[INFO] Suffix for uniqueness: %s", suffix)
+// allowMethod rejects requests whose method differs from the one the route accepts.
+func allowMethod(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set the content type before any handler calls WriteHeader, otherwise it is dropped.
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Only %s is allowed", method),
+			})
+			return
+		}
+		next(w, r)
+	}
+}
 
-	// Prepare Kubernetes client
-	log.Println("[INFO] Initializing Kubernetes client...")
-	clientSet, err := InitKubeClient(payload.Kubeconfig)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "Could not initialize Kubernetes client",
-		})
-		return
+// handleNotFound answers any unknown path with a JSON 404 listing the valid endpoints.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	endpoints := make([]string, 0, len(routes))
+	for _, rt := range routes {
+		endpoints = append(endpoints, rt.Method+" "+rt.Path)
 	}
 
-	ctx := context.Background()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	respondJSON(w, APIResponse{
+		Success:   false,
+		Message:   fmt.Sprintf("No endpoint found for %s %s", r.Method, r.URL.Path),
+		Endpoints: endpoints,
+	})
+}
 
-	// 1. Ensure namespace exists (or create if not).
-	log.Printf("[INFO] Ensuring namespace '%s' exists...", payload.Namespace)
-	nsErr := ensureNamespace(ctx, clientSet, payload.Namespace)
-	if nsErr != nil {
-		log.Printf("[ERROR] Failed to ensure namespace: %v", nsErr)
-		w.WriteHeader(http.StatusInternalServerError)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: nsErr.Error(),
-		})
+// handleCreateWordPress is our main handler for receiving JSON requests to deploy the stack.
+func handleCreateWordPress(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
 		return
 	}
 
-	// We'll create resource names with a function that ensures total length <= 60.
-	dbPVName := buildResourceName(payload.DeploymentName, "db-pv", suffix)
-	dbPVCName := buildResourceName(payload.DeploymentName, "db-pvc", suffix)
-	dbDeploymentName := buildResourceName(payload.DeploymentName, "db", suffix)
-	dbServiceName := buildResourceName(payload.DeploymentName, "db-svc", suffix)
-	dbSecretName := buildResourceName(payload.DeploymentName, "db-secret", suffix)
-
-	wpPVName := buildResourceName(payload.DeploymentName, "wp-pv", suffix)
-	wpPVCName := buildResourceName(payload.DeploymentName, "wp-pvc", suffix)
-	wpDeploymentName := buildResourceName(payload.DeploymentName, "wp", suffix)
-	wpServiceName := buildResourceName(payload.DeploymentName, "wp-svc", suffix)
-
-	// 2. Create hostPath-based PV and PVC for MySQL
-	log.Printf("[INFO] Creating hostPath PV/PVC for MySQL: PV=%s, PVC=%s", dbPVName, dbPVCName)
-	err = createPersistentVolume(ctx, clientSet, payload.Namespace, dbPVName,
-		"/mnt/data/"+payload.Namespace+"/"+dbPVName+"_data",
-		payload.DatabaseDiskGB)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("[ERROR] Failed to create MySQL PV: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("[ERROR] Failed to read request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to create MySQL PV: %v", err),
+			Message: "Could not read request body",
 		})
 		return
 	}
 
-	err = createPersistentVolumeClaim(ctx, clientSet, payload.Namespace, dbPVCName, dbPVName, payload.DatabaseDiskGB)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create MySQL PVC: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// Check the raw JSON against the schema first, so clients get every bad field by name
+	// instead of the decoder's first type error.
+	var raw any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		log.Printf("[ERROR] Failed to decode request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to create MySQL PVC: %v", err),
+			Message: "Invalid JSON payload",
 		})
 		return
 	}
-
-	// 3. Create hostPath-based PV and PVC for WordPress
-	log.Printf("[INFO] Creating hostPath PV/PVC for WordPress: PV=%s, PVC=%s", wpPVName, wpPVCName)
-	err = createPersistentVolume(ctx, clientSet, payload.Namespace, wpPVName,
-		"/mnt/data/"+payload.Namespace+"/"+wpPVName+"_data",
-		payload.PersistenceDiskGB)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create WordPress PV: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if errs := validateAgainstSchema(requestSchema, raw, ""); len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to create WordPress PV: %v", err),
+			Message: "Payload does not match the schema served at /schema",
+			Errors:  errs,
 		})
 		return
 	}
 
-	err = createPersistentVolumeClaim(ctx, clientSet, payload.Namespace, wpPVCName, wpPVName, payload.PersistenceDiskGB)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create WordPress PVC: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	var payload RequestPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		log.Printf("[ERROR] Failed to decode request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to create WordPress PVC: %v", err),
+			Message: "Invalid JSON payload",
 		})
 		return
 	}
 
-	// 4. Create Secret with random credentials for MySQL root and wordpress user.
-	log.Printf("[INFO] Creating combined MySQL & WordPress secret: %s", dbSecretName)
-
-	err = createWPMySQLSecret(ctx, clientSet, payload.Namespace, dbSecretName, dbServiceName)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create MySQL/WordPress Secret: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if conflicts := validatePayload(payload); len(conflicts) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: "Failed to create MySQL/WordPress Secret",
+			Message: fmt.Sprintf("The request has %d conflicting option(s)", len(conflicts)),
+			Errors:  conflicts,
 		})
 		return
 	}
-
-	// 5. Deploy MySQL (Deployment + Service)
-	log.Printf("[INFO] Creating MySQL deployment: %s", dbDeploymentName)
-	err = createMySQLDeployment(ctx, clientSet, payload.Namespace, dbDeploymentName, dbPVCName, dbSecretName)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create MySQL deployment: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if status, err := preparePayload(&payload); err != nil {
+		w.WriteHeader(status)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: "Failed to create MySQL deployment",
+			Message: err.Error(),
 		})
 		return
 	}
 
-	log.Printf("[INFO] Creating MySQL service: %s", dbServiceName)
-	err = createMySQLService(ctx, clientSet, payload.Namespace, dbServiceName, dbDeploymentName)
+	// Generate a random suffix for uniqueness, unless the client manages uniqueness itself
+	suffix, err := randSuffixFunc(*payload.SuffixLength)
 	if err != nil {
-		log.Printf("[ERROR] Failed to create MySQL service: %v", err)
+		log.Printf("[ERROR] Failed to generate random suffix: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: "Failed to create MySQL service",
+			Message: "Could not generate unique suffix",
 		})
 		return
 	}
 
-	// 6. Wait for MySQL deployment to be ready
-	log.Println("[INFO] Waiting for MySQL deployment to be ready...")
-	err = waitForDeploymentReady(ctx, clientSet, payload.Namespace, dbDeploymentName, 120*time.Second)
-	if err != nil {
-		log.Printf("[ERROR] MySQL deployment not ready in time: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "MySQL deployment failed to become ready",
-		})
-		return
+	// Log the start of the process, without the passwords
+	logged := payload
+	if isInlineKubeconfig(logged.Kubeconfig) {
+		logged.Kubeconfig = "<redacted>"
+	}
+	if logged.WPAdminPassword != "" {
+		logged.WPAdminPassword = "<redacted>"
+	}
+	if ext := logged.ExternalDatabase; ext != nil {
+		redacted := *ext
+		redacted.Password = "<redacted>"
+		logged.ExternalDatabase = &redacted
 	}
-	log.Println("[INFO] MySQL deployment is running and ready.")
+	if objs := logged.ObjectStorage; objs != nil && objs.SecretAccessKey != "" {
+		redacted := *objs
+		redacted.SecretAccessKey = "<redacted>"
+		logged.ObjectStorage = &redacted
+	}
+	if v := logged.Vault; v != nil && v.Token != "" {
+		redacted := *v
+		redacted.Token = "<redacted>"
+		logged.Vault = &redacted
+	}
+	log.Printf("[INFO] Received request to deploy WordPress (request_id=%s): %+v", requestID(r.Context()), logged)
+	log.Printf("[INFO] Suffix for uniqueness: %s", suffix)
 
-	// 7. Deploy WordPress (Deployment + Service)
-	log.Printf("[INFO] Creating WordPress deployment: %s", wpDeploymentName)
-	err = createWordPressDeployment(ctx, clientSet, payload.Namespace, wpDeploymentName, wpPVCName, dbSecretName, dbServiceName)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create WordPress deployment: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// We'll create resource names with a function that ensures total length <= 60.
+	names := stackNamesFor(payload, suffix)
+	if payload.NamespacePerDeployment {
+		payload.Namespace = names.ID()
+		if errs := validation.IsDNS1123Label(payload.Namespace); len(errs) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("deployment_name gives an invalid namespace %q: %s", payload.Namespace, strings.Join(errs, "; ")),
+			})
+			return
+		}
+		log.Printf("[INFO] Deploying into generated namespace: %s", payload.Namespace)
+	}
+
+	// In manifest mode nothing touches the cluster: render the objects and return them.
+	if payload.Output == outputManifest {
+		manifest, err := renderStackManifest(payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Failed to render manifest: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to render manifest: %v", err),
+			})
+			return
+		}
+		resources := names.summary(payload)
+		// The Secrets carry the generated passwords, only base64-encoded, so the manifest is
+		// returned as is for kubectl apply, but flagged for what it is and kept out of caches.
+		w.Header().Set("Cache-Control", "no-store")
 		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "Failed to create WordPress deployment",
+			Success: true,
+			Message: "Manifest rendered; no resources were created. Its Secrets hold the stack's passwords " +
+				"(base64-encoded, not encrypted): store it like a secret.",
+			Resources:    resources.Summaries,
+			ResourceRefs: resources.Refs,
+			Suffix:       names.Suffix,
+			Namespace:    generatedNamespace(payload),
+			Manifest:     manifest,
 		})
 		return
 	}
 
-	log.Printf("[INFO] Creating WordPress service: %s", wpServiceName)
-	err = createWordPressService(ctx, clientSet, payload.Namespace, wpServiceName, wpDeploymentName)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create WordPress service: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if payload.Async {
+		job := newDeployJob()
+		log.Printf("[INFO] Deploying asynchronously as job %s", job.ID)
+		go runDeployJob(job, payload, names)
+
+		// No suffix yet: the deploy picks another one if this one is taken, so only the job's
+		// result has the final one.
+		w.WriteHeader(http.StatusAccepted)
 		respondJSON(w, APIResponse{
-			Success: false,
-			Message: "Failed to create WordPress service",
+			Success:   true,
+			Message:   "Deployment started; poll /jobs/" + job.ID + " for the result.",
+			Namespace: generatedNamespace(payload),
+			JobID:     job.ID,
 		})
 		return
 	}
 
-	// 8. Wait for WordPress deployment to be ready
-	log.Println("[INFO] Waiting for WordPress deployment to be ready...")
-	err = waitForDeploymentReady(ctx, clientSet, payload.Namespace, wpDeploymentName, 120*time.Second)
-	if err != nil {
-		log.Printf("[ERROR] WordPress deployment not ready in time: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// The request context ends when the client disconnects, which aborts the deploy.
+	ctx := r.Context()
+	resp, status, deployed := deployStack(ctx, payload, names)
+	resp.Namespace = generatedNamespace(payload)
+	// A conflict means the resources in the way are another stack's, so there is nothing of
+	// this request's to undo.
+	if status != http.StatusOK && status != http.StatusConflict && isContextError(ctx.Err()) && deployed.ID() != "" {
+		// Not an internal error: the client went away, so undo the half-built stack.
+		log.Printf("[WARN] Deploy of stack %s aborted: %v; rolling back", deployed.ID(), ctx.Err())
+		rollbackStack(payload, deployed)
+		w.WriteHeader(statusClientClosedRequest)
 		respondJSON(w, APIResponse{
 			Success: false,
-			Message: "WordPress deployment failed to become ready",
+			Message: "Request cancelled before the deploy finished; created resources were rolled back",
+			Suffix:  deployed.Suffix,
 		})
 		return
 	}
-	log.Println("[INFO] WordPress deployment is running and ready.")
-
-	// 9. Build a summary
-	resources := []string{
-		"Namespace: " + payload.Namespace,
-		"PV: " + dbPVName,
-		"PVC: " + dbPVCName,
-		"PV: " + wpPVName,
-		"PVC: " + wpPVCName,
-		"Secret: " + dbSecretName,
-		"MySQL Deployment: " + dbDeploymentName,
-		"MySQL Service: " + dbServiceName,
-		"WordPress Deployment: " + wpDeploymentName,
-		"WordPress Service: " + wpServiceName,
+	if status != http.StatusOK {
+		w.WriteHeader(status)
 	}
-
-	log.Printf("[INFO] Successfully created resources: %+v", resources)
-
-	respondJSON(w, APIResponse{
-		Success:   true,
-		Message:   "WordPress + MySQL stack created successfully. Strong random credentials have been set for MySQL.",
-		Resources: resources,
-	})
+	respondJSON(w, resp)
 }
 
-// respondJSON is a helper to send JSON responses.
-func respondJSON(w http.ResponseWriter, resp APIResponse) {
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
-}
+// preparePayload validates the create request and fills in every default, returning the
+// HTTP status and error to report when the payload cannot be deployed.
+func preparePayload(payload *RequestPayload) (int, error) {
+	// Basic validation
+	if payload.Namespace == "" && !payload.NamespacePerDeployment {
+		return http.StatusBadRequest, errors.New("namespace is required")
+	}
+	// Also keeps the namespace a single, plain directory in the hostPath of the PVs.
+	if payload.Namespace != "" && !payload.NamespacePerDeployment {
+		if errs := validation.IsDNS1123Label(payload.Namespace); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid namespace %q: %s", payload.Namespace, strings.Join(errs, "; "))
+		}
+	}
 
-// buildResourceName constructs a Kubernetes resource name that is guaranteed
-// to be ≤ 60 characters. It uses the format:
-//
-//	<prefix> + "-" + <suffix> + "-" + <resourceType>
-//
-// Where:
-//   - <prefix> is truncated if it’s too long
-//   - <suffix> is 5 random chars
-//   - <resourceType> is a short string like "db-pv" or "wp-svc"
-func buildResourceName(userPrefix, resourceType, suffix string) string {
-	// We want the final string <= 60 chars total.
-	// We'll do: userPrefix + "-" + suffix + "-" + resourceType
-	// So total length = len(userPrefix) + 1 + len(suffix) + 1 + len(resourceType).
-	// That is len(userPrefix) + len(resourceType) + len(suffix) + 2.
-	maxTotal := 60
-	// We know suffix is always 5 chars. So let's define:
-	fixedLen := len(resourceType) + 5 + 2 // resourceType + suffix + 2 dashes
+	if err := validateLabels(payload.NamespaceLabels); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid namespace_labels: %w", err)
+	}
+	for key := range payload.NamespaceAnnotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid namespace_annotations key %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
 
-	// The user prefix can occupy the remainder:
-	allowed := maxTotal - fixedLen
-	if allowed < 0 {
-		// if resourceType + suffix + 2 alone exceed 60 (extremely unlikely), fallback to a minimal prefix
-		allowed = 0
+	// If user did not provide deployment_name, default to "wp"
+	if strings.TrimSpace(payload.DeploymentName) == "" {
+		payload.DeploymentName = "wp"
 	}
-	if len(userPrefix) > allowed {
-		userPrefix = userPrefix[:allowed]
+	if payload.SuffixLength == nil {
+		payload.SuffixLength = intPtr(defaultSuffixLength)
 	}
-	return fmt.Sprintf("%s-%s-%s", userPrefix, suffix, resourceType)
-}
+	if *payload.SuffixLength < 0 || *payload.SuffixLength > maxSuffixLength {
+		return http.StatusBadRequest, fmt.Errorf("suffix_length must be between 0 and %d", maxSuffixLength)
+	}
+	if payload.NameTemplate != "" {
+		tmpl, err := parseNameTemplate(payload.NameTemplate, *payload.SuffixLength > 0)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid name_template: %w", err)
+		}
+		payload.nameTemplate = tmpl
+	}
+	// The suffix itself is random, so checking names built with the longest one covers them
+	// all; digits, since a template may start a name with the suffix.
+	sample := stackNamesFor(*payload, strings.Repeat("0", *payload.SuffixLength))
+	if err := sample.validate(); err != nil {
+		if payload.nameTemplate != nil {
+			return http.StatusBadRequest, fmt.Errorf("name_template gives invalid resource names: %w", err)
+		}
+		return http.StatusBadRequest, fmt.Errorf("deployment_name gives invalid resource names: %w", err)
+	}
+
+	if payload.PersistenceDiskGB <= 0 {
+		payload.PersistenceDiskGB = 5 // default disk size for WordPress
+	}
+	if payload.DatabaseDiskGB <= 0 {
+		payload.DatabaseDiskGB = 5 // default disk size for Database
+	}
+	for _, f := range []struct {
+		name  string
+		class string
+	}{
+		{"storage_class", payload.StorageClass},
+		{"wordpress_storage_class", payload.WordPressStorageClass},
+		{"database_storage_class", payload.DatabaseStorageClass},
+	} {
+		if f.class == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(f.class); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid %s %q: %s", f.name, f.class, strings.Join(errs, "; "))
+		}
+		payload.DynamicProvisioning = true
+	}
+	if payload.HostPathType == "" {
+		payload.HostPathType = string(corev1.HostPathDirectoryOrCreate)
+	}
+	if !containsString(hostPathTypes, payload.HostPathType) {
+		return http.StatusBadRequest, errors.New("host_path_type must be one of " + strings.Join(hostPathTypes, ", "))
+	}
+	if payload.VolumeMode == "" {
+		payload.VolumeMode = string(corev1.PersistentVolumeFilesystem)
+	}
+	if !containsString(volumeModes, payload.VolumeMode) {
+		return http.StatusBadRequest, errors.New("volume_mode must be one of " + strings.Join(volumeModes, ", "))
+	}
+	if payload.PVCBindTimeout == 0 {
+		payload.PVCBindTimeout = defaultPVCBindTimeoutSeconds
+	}
+	if payload.PVCBindTimeout < 1 || payload.PVCBindTimeout > 3600 {
+		return http.StatusBadRequest, errors.New("pvc_bind_timeout_seconds must be between 1 and 3600")
+	}
+	if payload.SharedVolumeGB <= 0 {
+		payload.SharedVolumeGB = defaultSharedVolumeGB
+	}
+	if strings.TrimSpace(payload.MySQLImage) == "" {
+		payload.MySQLImage = defaultMySQLImage
+	}
+	if strings.TrimSpace(payload.WordPressImage) == "" {
+		payload.WordPressImage = defaultWordPressImage
+	}
+	if payload.MySQLContainerName == "" {
+		payload.MySQLContainerName = defaultMySQLContainerName
+	}
+	if payload.WordPressContainerName == "" {
+		payload.WordPressContainerName = defaultWordPressContainerName
+	}
+	// Each name must be unique within its pod, alongside the containers the deployer adds itself.
+	for _, f := range []struct {
+		name      string
+		container string
+		reserved  []string
+	}{
+		{"mysql_container_name", payload.MySQLContainerName, nil},
+		{"wordpress_container_name", payload.WordPressContainerName, []string{"wp-cli", "wait-for-db"}},
+	} {
+		if errs := validation.IsDNS1123Label(f.container); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid %s %q: %s", f.name, f.container, strings.Join(errs, "; "))
+		}
+		if containsString(f.reserved, f.container) {
+			return http.StatusBadRequest, fmt.Errorf("%s %q is already used by a container the deployer adds", f.name, f.container)
+		}
+	}
+	if ext := payload.ExternalDatabase; ext != nil {
+		if ext.Host == "" || ext.Name == "" || ext.User == "" || ext.Password == "" {
+			return http.StatusBadRequest, errors.New("external_database requires host, name, user and password")
+		}
+		if ext.Port == 0 {
+			ext.Port = defaultExternalDBPort
+		}
+		if ext.Port < 1 || ext.Port > 65535 {
+			return http.StatusBadRequest, errors.New("external_database.port must be between 1 and 65535")
+		}
+	}
+	if shared := payload.SharedDatabase; shared != nil {
+		if errs := validation.IsDNS1035Label(shared.Service); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("shared_database.service %q is not a valid service name: %s", shared.Service, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsDNS1123Subdomain(shared.AdminSecret); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("shared_database.admin_secret %q is not a valid secret name: %s", shared.AdminSecret, strings.Join(errs, "; "))
+		}
+		if shared.Port == 0 {
+			shared.Port = defaultExternalDBPort
+		}
+		if shared.Port < 1 || shared.Port > 65535 {
+			return http.StatusBadRequest, errors.New("shared_database.port must be between 1 and 65535")
+		}
+		if shared.AdminPasswordKey == "" {
+			shared.AdminPasswordKey = defaultSharedDBAdminPasswordKey
+		}
+		if shared.AdminUser == "" {
+			shared.AdminUser = defaultSharedDBAdminUser
+		}
+		// From here on the shared server is an external database; the database and user names
+		// follow from the stack's names, see resolveSharedDatabase.
+		password, err := generateRandomPassword(16)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("failed to generate database password: %w", err)
+		}
+		payload.ExternalDatabase = &ExternalDatabase{Host: shared.Service, Port: shared.Port, Password: password}
+	}
+	if payload.DBCACert != "" {
+		if err := validateCACert(payload.DBCACert); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid db_ca_cert: %w", err)
+		}
+	}
+	if objs := payload.ObjectStorage; objs != nil {
+		if objs.Bucket == "" || objs.Region == "" {
+			return http.StatusBadRequest, errors.New("object_storage requires bucket and region")
+		}
+		if objs.CredentialsSecret != "" {
+			if errs := validation.IsDNS1123Subdomain(objs.CredentialsSecret); len(errs) > 0 {
+				return http.StatusBadRequest, fmt.Errorf("invalid object_storage.credentials_secret: %s", strings.Join(errs, "; "))
+			}
+		} else if objs.AccessKeyID == "" || objs.SecretAccessKey == "" {
+			return http.StatusBadRequest, errors.New("object_storage requires access_key_id and secret_access_key, or credentials_secret")
+		}
+		// With auto_install the offload plugin rides along with the requested extensions.
+		if payload.AutoInstall && !containsString(payload.WPPlugins, objectStoragePlugin) {
+			payload.WPPlugins = append(payload.WPPlugins, objectStoragePlugin)
+		}
+	}
+	if payload.RedisCache && payload.AutoInstall && !containsString(payload.WPPlugins, redisCachePlugin) {
+		payload.WPPlugins = append(payload.WPPlugins, redisCachePlugin)
+	}
+	if payload.MySQLResources != nil {
+		if _, err := buildResourceRequirements(*payload.MySQLResources); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid mysql_resources: %w", err)
+		}
+	}
+	for _, arg := range payload.MySQLArgs {
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			return http.StatusBadRequest, fmt.Errorf("invalid mysql_args entry %q: every flag must start with --", arg)
+		}
+	}
+	// An empty list would silently keep the image's defaults; reject it rather than guess.
+	if payload.WordPressCommand != nil && len(payload.WordPressCommand) == 0 {
+		return http.StatusBadRequest, errors.New("wordpress_command must not be an empty list")
+	}
+	for _, arg := range payload.WordPressCommand {
+		if arg == "" {
+			return http.StatusBadRequest, errors.New("wordpress_command entries must not be empty")
+		}
+	}
+	if payload.WordPressArgs != nil && len(payload.WordPressArgs) == 0 {
+		return http.StatusBadRequest, errors.New("wordpress_args must not be an empty list")
+	}
+	if payload.MySQLConfig != "" && strings.TrimSpace(payload.MySQLConfig) == "" {
+		return http.StatusBadRequest, errors.New("mysql_config must not be blank")
+	}
+	if payload.MySQLInnoDBBufferPoolMB < 0 {
+		return http.StatusBadRequest, errors.New("mysql_innodb_buffer_pool_mb must not be negative")
+	}
+	if limitMB, ok := memoryLimitMB(payload.MySQLResources); ok && payload.MySQLInnoDBBufferPoolMB > 0 &&
+		int64(payload.MySQLInnoDBBufferPoolMB) >= limitMB {
+		return http.StatusBadRequest, fmt.Errorf("mysql_innodb_buffer_pool_mb (%d) must be below the MySQL memory limit (%dMB)",
+			payload.MySQLInnoDBBufferPoolMB, limitMB)
+	}
+	if payload.RevisionHistoryLimit == nil {
+		payload.RevisionHistoryLimit = int32Ptr(defaultRevisionHistoryLimit)
+	}
+	if *payload.RevisionHistoryLimit < 0 {
+		return http.StatusBadRequest, errors.New("revision_history_limit must not be negative")
+	}
+	if payload.TTLSeconds != 0 {
+		if payload.TTLSeconds < minTTLSeconds || payload.TTLSeconds > maxTTLSeconds {
+			return http.StatusBadRequest, fmt.Errorf("ttl_seconds must be between %d and %d", minTTLSeconds, maxTTLSeconds)
+		}
+		payload.expiresAt = time.Now().UTC().Add(time.Duration(payload.TTLSeconds) * time.Second).Truncate(time.Second)
+	}
+	if len(payload.ChangeCause) > maxChangeCauseLength {
+		return http.StatusBadRequest, fmt.Errorf("change_cause must be at most %d characters", maxChangeCauseLength)
+	}
+	if payload.PollIntervalSeconds == 0 {
+		payload.PollIntervalSeconds = defaultPollIntervalSeconds
+	}
+	if payload.PollIntervalSeconds < 1 || payload.PollIntervalSeconds > 60 {
+		return http.StatusBadRequest, errors.New("poll_interval_seconds must be between 1 and 60")
+	}
+	if d := payload.ProgressDeadline; d != nil && (*d < 1 || *d > maxProgressDeadlineSeconds) {
+		return http.StatusBadRequest, fmt.Errorf("progress_deadline_seconds must be between 1 and %d", maxProgressDeadlineSeconds)
+	}
+	if payload.MinReadySeconds < 0 || payload.MinReadySeconds > maxMinReadySeconds {
+		return http.StatusBadRequest, fmt.Errorf("min_ready_seconds must be between 0 and %d", maxMinReadySeconds)
+	}
+	if payload.MySQLDataPath == "" {
+		payload.MySQLDataPath = defaultMySQLDataPath
+	}
+	if payload.WordPressDataPath == "" {
+		payload.WordPressDataPath = defaultWordPressDataPath
+	}
+	for _, f := range [][2]string{
+		{"mysql_data_path", payload.MySQLDataPath},
+		{"wordpress_data_path", payload.WordPressDataPath},
+	} {
+		if !path.IsAbs(f[1]) {
+			return http.StatusBadRequest, fmt.Errorf("%s must be an absolute path, got %q", f[0], f[1])
+		}
+	}
+	payload.MySQLDataPath = path.Clean(payload.MySQLDataPath)
+	payload.WordPressDataPath = path.Clean(payload.WordPressDataPath)
+	mountPaths := map[string]bool{payload.WordPressDataPath: true}
+	if payload.ReadOnlyRootFilesystem {
+		// The official images unpack WordPress into /var/www/html, which must be the volume then.
+		if payload.WordPressDataPath != defaultWordPressDataPath {
+			return http.StatusBadRequest, fmt.Errorf("read_only_root_filesystem requires wordpress_data_path %s, where the image writes WordPress",
+				defaultWordPressDataPath)
+		}
+		for _, mount := range readOnlyRootWritablePaths {
+			mountPaths[mount.MountPath] = true
+		}
+	}
+	for i, v := range payload.ExtraVolumes {
+		if err := validateExtraVolume(v); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("extra_volumes[%d]: %w", i, err)
+		}
+		if mountPaths[path.Clean(v.MountPath)] {
+			return http.StatusBadRequest, fmt.Errorf("extra_volumes[%d]: mount_path %s is already mounted", i, v.MountPath)
+		}
+		mountPaths[path.Clean(v.MountPath)] = true
+	}
+	for i, s := range payload.ExtraEnvFrom {
+		if err := validateEnvSource(s); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("extra_env_from[%d]: %w", i, err)
+		}
+	}
+	for _, slug := range append(append([]string{}, payload.WPPlugins...), payload.WPThemes...) {
+		if !wpSlugPattern.MatchString(slug) {
+			return http.StatusBadRequest, fmt.Errorf("invalid plugin/theme slug %q: use the lowercase wordpress.org slug", slug)
+		}
+	}
+	if payload.AutoInstall {
+		if _, err := mail.ParseAddress(payload.WPAdminEmail); err != nil {
+			return http.StatusBadRequest, errors.New("auto_install requires a valid wp_admin_email")
+		}
+		if payload.WPSiteURL != "" {
+			if u, err := url.Parse(payload.WPSiteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return http.StatusBadRequest, errors.New("wp_site_url must be an absolute http(s) URL")
+			}
+		}
+		if strings.TrimSpace(payload.WPAdminUser) == "" {
+			payload.WPAdminUser = "admin"
+		}
+		if strings.TrimSpace(payload.WPSiteTitle) == "" {
+			payload.WPSiteTitle = "WordPress"
+		}
+		if payload.WPAdminDelivery == "" {
+			payload.WPAdminDelivery = adminDeliveryResponse
+			if payload.Async {
+				payload.WPAdminDelivery = adminDeliveryClaim
+			}
+		}
+		if !containsString(adminDeliveries, payload.WPAdminDelivery) {
+			return http.StatusBadRequest, errors.New("wp_admin_delivery must be one of " + strings.Join(adminDeliveries, ", "))
+		}
+		if payload.WPAdminPassword == "" {
+			pass, err := generateRandomPassword(20)
+			if err != nil {
+				log.Printf("[ERROR] Failed to generate admin password: %v", err)
+				return http.StatusInternalServerError, errors.New("Could not generate WordPress admin password")
+			}
+			payload.WPAdminPassword = pass
+		}
+	}
+	if strings.TrimSpace(payload.WaitForDBImage) == "" {
+		payload.WaitForDBImage = defaultWaitForDBImage
+	}
+	if strings.TrimSpace(payload.WPCLIImage) == "" {
+		payload.WPCLIImage = defaultWPCLIImage
+	}
+	// Tags and digests are both accepted; digest-pinned images are pulled IfNotPresent.
+	for _, f := range []struct {
+		name  string
+		image string
+	}{
+		{"mysql_image", payload.MySQLImage},
+		{"wordpress_image", payload.WordPressImage},
+		{"wp_cli_image", payload.WPCLIImage},
+		{"wait_for_database_image", payload.WaitForDBImage},
+	} {
+		if err := validateImage(f.name, f.image); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	if t := payload.ProbeTuning; t != nil {
+		for _, f := range []struct {
+			name  string
+			value int32
+		}{
+			{"initial_delay_seconds", t.InitialDelaySeconds},
+			{"period_seconds", t.PeriodSeconds},
+			{"timeout_seconds", t.TimeoutSeconds},
+			{"failure_threshold", t.FailureThreshold},
+			{"success_threshold", t.SuccessThreshold},
+		} {
+			if f.value < 0 {
+				return http.StatusBadRequest, fmt.Errorf("probe_tuning.%s must be positive (0 keeps the default)", f.name)
+			}
+		}
+	}
+	if payload.ProbeScheme == "" {
+		payload.ProbeScheme = string(corev1.URISchemeHTTP)
+	}
+	if payload.ProbeScheme != string(corev1.URISchemeHTTP) && payload.ProbeScheme != string(corev1.URISchemeHTTPS) {
+		return http.StatusBadRequest, fmt.Errorf("probe_scheme must be %q or %q", corev1.URISchemeHTTP, corev1.URISchemeHTTPS)
+	}
+	if payload.Replicas < 0 {
+		return http.StatusBadRequest, errors.New("replicas must not be negative")
+	}
+	if payload.Replicas == 0 {
+		payload.Replicas = 1
+	}
+	if payload.DatabaseReplicas == 0 && payload.ExternalDatabase == nil {
+		payload.DatabaseReplicas = 1
+		if payload.MySQLReadReplica {
+			payload.DatabaseReplicas = 2
+		}
+	}
+	if payload.ExternalDatabase == nil && (payload.DatabaseReplicas < 1 || payload.DatabaseReplicas > maxDatabaseReplicas) {
+		return http.StatusBadRequest, fmt.Errorf("database_replicas must be between 1 and %d", maxDatabaseReplicas)
+	}
+	if payload.ServicePort == 0 {
+		payload.ServicePort = defaultServicePort
+	}
+	if payload.ServicePort < 1 || payload.ServicePort > 65535 {
+		return http.StatusBadRequest, errors.New("service_port must be between 1 and 65535")
+	}
+	if payload.PodFSGroup == nil {
+		payload.PodFSGroup = int64Ptr(wwwDataUID)
+	}
+	if *payload.PodFSGroup < 0 {
+		return http.StatusBadRequest, errors.New("pod_fs_group must not be negative")
+	}
+	if c := payload.Canary; c != nil {
+		if strings.TrimSpace(c.Image) == "" {
+			return http.StatusBadRequest, errors.New("canary.image is required")
+		}
+		if err := validateImage("canary.image", c.Image); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if c.Replicas == 0 {
+			c.Replicas = 1
+		}
+		if c.Replicas < 0 {
+			return http.StatusBadRequest, errors.New("canary.replicas must not be negative")
+		}
+	}
+	if ts := payload.TopologySpread; ts != nil {
+		if ts.TopologyKey == "" {
+			ts.TopologyKey = defaultTopologyKey
+		}
+		if ts.MaxSkew == 0 {
+			ts.MaxSkew = 1
+		}
+		if ts.WhenUnsatisfiable == "" {
+			ts.WhenUnsatisfiable = string(corev1.ScheduleAnyway)
+		}
+		if ts.MaxSkew < 1 {
+			return http.StatusBadRequest, errors.New("topology_spread.max_skew must be at least 1")
+		}
+		if ts.WhenUnsatisfiable != string(corev1.DoNotSchedule) && ts.WhenUnsatisfiable != string(corev1.ScheduleAnyway) {
+			return http.StatusBadRequest, fmt.Errorf("topology_spread.when_unsatisfiable must be %q or %q", corev1.DoNotSchedule, corev1.ScheduleAnyway)
+		}
+		if errs := validation.IsQualifiedName(ts.TopologyKey); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid topology_spread.topology_key %q: %s", ts.TopologyKey, strings.Join(errs, "; "))
+		}
+	}
+	if payload.CallbackURL != "" {
+		if u, err := url.Parse(payload.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return http.StatusBadRequest, errors.New("callback_url must be an absolute http(s) URL")
+		}
+	}
+	if gw := payload.Gateway; gw != nil {
+		if gw.GatewayName == "" {
+			return http.StatusBadRequest, errors.New("gateway.gateway_name is required")
+		}
+		if errs := validation.IsDNS1123Subdomain(gw.GatewayName); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid gateway.gateway_name %q: %s", gw.GatewayName, strings.Join(errs, "; "))
+		}
+		if gw.Namespace != "" {
+			if errs := validation.IsDNS1123Label(gw.Namespace); len(errs) > 0 {
+				return http.StatusBadRequest, fmt.Errorf("invalid gateway.namespace %q: %s", gw.Namespace, strings.Join(errs, "; "))
+			}
+		}
+		if gw.Hostname != "" {
+			errs := validation.IsDNS1123Subdomain(gw.Hostname)
+			if strings.HasPrefix(gw.Hostname, "*.") {
+				errs = validation.IsWildcardDNS1123Subdomain(gw.Hostname)
+			}
+			if len(errs) > 0 {
+				return http.StatusBadRequest, fmt.Errorf("invalid gateway.hostname %q: %s", gw.Hostname, strings.Join(errs, "; "))
+			}
+		}
+	}
+	for i, rule := range payload.ServiceAccountRules {
+		if len(rule.Resources) == 0 || len(rule.Verbs) == 0 {
+			return http.StatusBadRequest, fmt.Errorf("service_account_rules[%d] needs resources and verbs", i)
+		}
+	}
+	if payload.SecretBackend == "" {
+		payload.SecretBackend = secretBackendKubernetes
+	}
+	if payload.SecretBackend == secretBackendVault {
+		if payload.Vault == nil {
+			payload.Vault = &VaultConfig{}
+		}
+		v := payload.Vault
+		if v.Address == "" {
+			v.Address = os.Getenv("VAULT_ADDR")
+		}
+		if v.Mount == "" {
+			v.Mount = defaultVaultMount
+		}
+		if u, err := url.Parse(v.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return http.StatusBadRequest, errors.New("vault.address (or the deployer's VAULT_ADDR) must be an absolute http(s) URL")
+		}
+		if v.Token == "" {
+			// The deployer's token only goes to the deployer's Vault, and only below its prefix.
+			if v.Address != os.Getenv("VAULT_ADDR") {
+				return http.StatusBadRequest, errors.New("vault.token is required with a vault.address other than the deployer's VAULT_ADDR")
+			}
+			v.Token = os.Getenv("VAULT_TOKEN")
+			if v.Token == "" {
+				return http.StatusBadRequest, errors.New("vault.token is required unless the deployer has VAULT_TOKEN set")
+			}
+			if prefix := vaultPathPrefix(); v.Path != "" && !vaultPathWithin(v.Path, prefix) {
+				return http.StatusBadRequest, fmt.Errorf("vault.path must be below %s/ unless vault.token is given", prefix)
+			}
+		}
+	}
+	if payload.SecretMode == "" {
+		payload.SecretMode = secretModeManaged
+	}
+	if !containsString(secretModes, payload.SecretMode) {
+		return http.StatusBadRequest, errors.New("secret_mode must be one of " + strings.Join(secretModes, ", "))
+	}
+	if payload.SecretMode == secretModeExternalSecret {
+		es := payload.ExternalSecret
+		if es == nil || es.SecretStore == "" || es.Key == "" {
+			return http.StatusBadRequest, errors.New("secret_mode external-secret requires external_secret.secret_store and external_secret.key")
+		}
+		if es.SecretStoreKind == "" {
+			es.SecretStoreKind = secretStoreKinds[0]
+		}
+		if !containsString(secretStoreKinds, es.SecretStoreKind) {
+			return http.StatusBadRequest, errors.New("external_secret.secret_store_kind must be one of " + strings.Join(secretStoreKinds, ", "))
+		}
+		if es.RefreshInterval == "" {
+			es.RefreshInterval = defaultExternalSecretRefresh
+		}
+		if d, err := time.ParseDuration(es.RefreshInterval); err != nil || d < 0 {
+			return http.StatusBadRequest, fmt.Errorf("external_secret.refresh_interval %q is not a valid duration", es.RefreshInterval)
+		}
+	}
+	if q := payload.NamespaceQuota; q != nil {
+		if _, _, err := buildNamespaceQuota(payload.Namespace, *q); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid namespace_quota: %w", err)
+		}
+	}
+	if payload.HostNode != "" {
+		if errs := validation.IsDNS1123Subdomain(payload.HostNode); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid host_node %q: %s", payload.HostNode, strings.Join(errs, "; "))
+		}
+	}
+	if payload.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(payload.PriorityClassName); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid priority_class_name %q: %s", payload.PriorityClassName, strings.Join(errs, "; "))
+		}
+	}
+	if payload.ImagePullPolicy != "" && !containsString(imagePullPolicies, payload.ImagePullPolicy) {
+		return http.StatusBadRequest, errors.New("image_pull_policy must be one of " + strings.Join(imagePullPolicies, ", "))
+	}
+	if payload.DNSPolicy != "" && !containsString(dnsPolicies, payload.DNSPolicy) {
+		return http.StatusBadRequest, errors.New("dns_policy must be one of " + strings.Join(dnsPolicies, ", "))
+	}
+	if payload.DNSConfig != nil {
+		for _, ns := range payload.DNSConfig.Nameservers {
+			if net.ParseIP(ns) == nil {
+				return http.StatusBadRequest, fmt.Errorf("dns_config.nameservers: %q is not an IP address", ns)
+			}
+		}
+		for _, opt := range payload.DNSConfig.Options {
+			if opt.Name == "" {
+				return http.StatusBadRequest, errors.New("dns_config.options: every option needs a name")
+			}
+		}
+	}
+	if c := payload.NodeCapacityCheck; c != "" && c != capacityCheckWarn && c != capacityCheckReject {
+		return http.StatusBadRequest, fmt.Errorf("node_capacity_check must be %q or %q", capacityCheckWarn, capacityCheckReject)
+	}
+	if payload.Output == "" {
+		payload.Output = outputApply
+	}
+	if payload.Output != outputApply && payload.Output != outputManifest {
+		return http.StatusBadRequest, fmt.Errorf("output must be %q or %q", outputApply, outputManifest)
+	}
+	if !payload.DynamicProvisioning {
+		namespace := payload.Namespace
+		if payload.NamespacePerDeployment {
+			namespace = sample.ID()
+		}
+		for _, pvName := range []string{sample.WPPV, sample.DBPV, sharedPVName(namespace)} {
+			if err := validateHostPath(hostPathFor(namespace, pvName)); err != nil {
+				return http.StatusBadRequest, fmt.Errorf("namespace and deployment_name give an unusable hostPath: %w", err)
+			}
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// validatePayload checks the options that only make sense together, or not at all together,
+// and returns every conflict found so the client can fix them in one go. It runs before
+// preparePayload fills in defaults, so it only sees what the client actually sent.
+func validatePayload(payload RequestPayload) []string {
+	var conflicts []string
+	conflict := func(format string, args ...any) {
+		conflicts = append(conflicts, fmt.Sprintf(format, args...))
+	}
+
+	if payload.ExternalDatabase != nil && payload.SharedDatabase != nil {
+		conflict("external_database and shared_database are alternatives; give only one")
+	}
+	if payload.SharedDatabase != nil && payload.NamespacePerDeployment {
+		conflict("shared_database needs the shared server in the stack's namespace, which namespace_per_deployment creates")
+	}
+	if payload.ExternalDatabase != nil || payload.SharedDatabase != nil {
+		database := "external_database"
+		if payload.SharedDatabase != nil {
+			database = "shared_database"
+		}
+		// Everything here configures the bundled MySQL, which isn't deployed.
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"mysql_read_replica", payload.MySQLReadReplica},
+			{"database_replicas", payload.DatabaseReplicas != 0},
+			{"mysql_resources", payload.MySQLResources != nil},
+			{"mysql_args", len(payload.MySQLArgs) > 0},
+			{"mysql_config", payload.MySQLConfig != ""},
+			{"mysql_innodb_buffer_pool_mb", payload.MySQLInnoDBBufferPoolMB != 0},
+			{"mysql_data_path", payload.MySQLDataPath != ""},
+			{"database_disk_size", payload.DatabaseDiskGB != 0},
+			{"database_storage_class", payload.DatabaseStorageClass != ""},
+			{"volume_mode", payload.VolumeMode != ""},
+		} {
+			if f.set {
+				conflict("%s cannot be combined with %s", f.name, database)
+			}
+		}
+	} else {
+		if payload.CheckExternalDatabase {
+			conflict("check_external_database requires external_database or shared_database")
+		}
+		if payload.DBCACert != "" {
+			conflict("db_ca_cert requires external_database or shared_database")
+		}
+	}
+	if payload.MySQLReadReplica && !mysqlVersionAtLeast(mysqlVersionFromImage(payload.MySQLImage), minReplicaMySQLVersion) {
+		conflict("mysql_read_replica requires MySQL %s or later, got %s", minReplicaMySQLVersion, payload.MySQLImage)
+	}
+	// The primary is a Deployment with a single writer on a ReadWriteOnce claim, not a
+	// StatefulSet, so every further MySQL pod has to be a read replica.
+	if payload.DatabaseReplicas > 1 && !payload.MySQLReadReplica {
+		conflict("database_replicas above 1 requires mysql_read_replica: MySQL runs as a single-primary " +
+			"Deployment, not a StatefulSet, so further pods can only be read replicas")
+	}
+	if payload.DatabaseReplicas == 1 && payload.MySQLReadReplica {
+		conflict("mysql_read_replica requires database_replicas of at least 2, the primary and one replica")
+	}
+
+	if payload.NamespacePerDeployment && payload.Namespace != "" {
+		conflict("namespace cannot be combined with namespace_per_deployment, which generates it")
+	}
+
+	if payload.NamespacePerDeployment && len(payload.ExtraVolumes) > 0 {
+		conflict("extra_volumes cannot be combined with namespace_per_deployment: the new namespace holds no ConfigMaps or Secrets yet")
+	}
+	if payload.NamespacePerDeployment && len(payload.ExtraEnvFrom) > 0 {
+		conflict("extra_env_from cannot be combined with namespace_per_deployment: the new namespace holds no ConfigMaps or Secrets yet")
+	}
+
+	if payload.DynamicProvisioning || payload.StorageClass != "" || payload.WordPressStorageClass != "" || payload.DatabaseStorageClass != "" {
+		// Both only apply to hostPath PVs created by the deployer.
+		if payload.SharedVolume {
+			conflict("shared_volume cannot be combined with dynamic provisioning")
+		}
+		if payload.NodeCapacityCheck != "" {
+			conflict("node_capacity_check only applies to hostPath volumes, not dynamic provisioning")
+		}
+		if payload.HostNode != "" {
+			conflict("host_node only applies to hostPath volumes, not dynamic provisioning")
+		}
+		if payload.HostPathType != "" {
+			conflict("host_path_type only applies to hostPath volumes, not dynamic provisioning")
+		}
+		if payload.ReuseExistingPV {
+			conflict("reuse_existing_pv only applies to hostPath volumes, not dynamic provisioning")
+		}
+	}
+
+	// Without host_node, pods on other nodes would each see their own node's directory.
+	hostPathWordPress := !payload.DynamicProvisioning && payload.StorageClass == "" && payload.WordPressStorageClass == ""
+	if payload.Replicas > 1 && hostPathWordPress && payload.HostNode == "" {
+		conflict("replicas above 1 requires host_node or a storage class: the ReadWriteOnce hostPath volume " +
+			"only holds the site's files on one node")
+	}
+	if payload.SharedVolumeGB != 0 && !payload.SharedVolume {
+		conflict("shared_volume_size requires shared_volume")
+	}
+	if payload.VolumeMode == string(corev1.PersistentVolumeBlock) {
+		if !payload.DynamicProvisioning && payload.StorageClass == "" && payload.DatabaseStorageClass == "" {
+			conflict("volume_mode Block requires dynamic provisioning: hostPath volumes are always filesystems")
+		}
+		if payload.MySQLConfig == "" {
+			conflict("volume_mode Block requires mysql_config placing InnoDB on %s", mysqlBlockDevicePath)
+		}
+		if payload.MySQLReadReplica {
+			conflict("volume_mode Block cannot be combined with mysql_read_replica")
+		}
+	}
+	if payload.WaitForDBImage != "" && !payload.WaitForDB {
+		conflict("wait_for_database_image requires wait_for_database")
+	}
+
+	if !payload.AutoInstall {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"wp_plugins", len(payload.WPPlugins) > 0},
+			{"wp_themes", len(payload.WPThemes) > 0},
+			{"wp_admin_user", payload.WPAdminUser != ""},
+			{"wp_admin_password", payload.WPAdminPassword != ""},
+			{"wp_admin_email", payload.WPAdminEmail != ""},
+			{"wp_site_title", payload.WPSiteTitle != ""},
+			{"wp_admin_delivery", payload.WPAdminDelivery != ""},
+			{"wp_site_url", payload.WPSiteURL != ""},
+		} {
+			if f.set {
+				conflict("%s requires auto_install", f.name)
+			}
+		}
+	}
+
+	// Routing: a Gateway listener left without its Gateway, or a site URL the route never
+	// answers for, deploys fine and then serves nothing or redirects visitors elsewhere.
+	if gw := payload.Gateway; gw != nil && gw.GatewayName == "" {
+		if gw.Hostname != "" {
+			conflict("gateway.hostname requires gateway.gateway_name")
+		}
+		if gw.Namespace != "" {
+			conflict("gateway.namespace requires gateway.gateway_name")
+		}
+	}
+	if gw := payload.Gateway; gw != nil && gw.Hostname != "" && payload.WPSiteURL != "" {
+		if u, err := url.Parse(payload.WPSiteURL); err == nil && u.Hostname() != "" && !hostnameMatches(gw.Hostname, u.Hostname()) {
+			conflict("wp_site_url host %s is not served by gateway.hostname %s", u.Hostname(), gw.Hostname)
+		}
+	}
+
+	if objs := payload.ObjectStorage; objs != nil && objs.CredentialsSecret != "" &&
+		(objs.AccessKeyID != "" || objs.SecretAccessKey != "") {
+		conflict("object_storage takes either credentials_secret or access_key_id/secret_access_key, not both")
+	}
+
+	if payload.Vault != nil && payload.SecretBackend != secretBackendVault {
+		conflict("vault requires secret_backend %q", secretBackendVault)
+	}
+	if payload.ExternalSecret != nil && payload.SecretMode != secretModeExternalSecret {
+		conflict("external_secret requires secret_mode %q", secretModeExternalSecret)
+	}
+	if payload.SecretMode == secretModeExternalSecret {
+		if payload.SecretBackend == secretBackendVault {
+			conflict("secret_mode %q cannot be combined with secret_backend %q, which exports generated credentials",
+				secretModeExternalSecret, secretBackendVault)
+		}
+		if payload.ExternalDatabase != nil {
+			conflict("secret_mode %q cannot be combined with external_database, whose credentials are given in the request",
+				secretModeExternalSecret)
+		}
+		if payload.SharedDatabase != nil {
+			conflict("secret_mode %q cannot be combined with shared_database, which generates the credentials",
+				secretModeExternalSecret)
+		}
+	}
+	if d := payload.ProgressDeadline; d != nil && payload.MinReadySeconds > 0 && *d <= payload.MinReadySeconds {
+		// The API server rejects such deployments: no pod could become available in time.
+		conflict("progress_deadline_seconds (%d) must be greater than min_ready_seconds (%d)", *d, payload.MinReadySeconds)
+	}
+	if len(payload.ServiceAccountRules) > 0 && !payload.ServiceAccount {
+		conflict("service_account_rules requires service_account")
+	}
+	if payload.SaveManifest && payload.Output == outputManifest {
+		conflict("save_manifest cannot be combined with output %q, which never deploys", outputManifest)
+	}
+	if payload.CallbackURL != "" && !payload.Async {
+		conflict("callback_url requires async")
+	}
+	if payload.Async && payload.Output == outputManifest {
+		conflict("async cannot be combined with output %q, which never deploys", outputManifest)
+	}
+	if payload.Async && payload.WPAdminDelivery == adminDeliveryResponse {
+		// Job results are served to every GET /jobs/{id} for a day; a claim works only once.
+		conflict("async requires wp_admin_delivery %q", adminDeliveryClaim)
+	}
+	if !waitForReady(payload) {
+		// Both run against the live site, so they need it ready.
+		if payload.AutoInstall {
+			conflict("auto_install cannot be combined with wait_for_ready false")
+		}
+		if payload.VerifyDBConnection {
+			conflict("verify_db_connection cannot be combined with wait_for_ready false")
+		}
+	}
+
+	if payload.DNSPolicy == string(corev1.DNSNone) && (payload.DNSConfig == nil || len(payload.DNSConfig.Nameservers) == 0) {
+		conflict("dns_policy None requires dns_config.nameservers")
+	}
+	return conflicts
+}
+
+// deployStack creates every resource of the stack in order and waits for it to become ready.
+// It returns the response to send and its HTTP status, so the same steps serve both
+// synchronous requests and async jobs, and the names it deployed under: names with a new
+// suffix if theirs was taken, or none if it stopped before settling on unused ones, since
+// until then the names may belong to another stack.
+func deployStack(ctx context.Context, payload RequestPayload, names stackNames) (APIResponse, int, stackNames) {
+	if payload.Verbose {
+		ctx = withVerbose(ctx)
+	}
+	debugf(ctx, "Deploying stack %s with names %+v", names.ID(), names)
+
+	// Prepare Kubernetes client
+	log.Println("[INFO] Initializing Kubernetes client...")
+	clientSet, err := InitKubeClient(payload.Kubeconfig, payload.KubeContext)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		return APIResponse{
+			Success: false,
+			Message: message,
+		}, status, stackNames{}
+	}
+
+	// 1. Ensure namespace exists (or create if not).
+	log.Printf("[INFO] Ensuring namespace '%s' exists...", payload.Namespace)
+	nsCreated, nsErr := ensureNamespace(ctx, clientSet, payload.Namespace, payload.NamespaceLabels, payload.NamespaceAnnotations,
+		namespaceCreatedFor(payload, names))
+	if nsErr != nil {
+		log.Printf("[ERROR] Failed to ensure namespace: %v", nsErr)
+		return APIResponse{
+			Success: false,
+			Message: nsErr.Error(),
+		}, namespaceErrorStatus(nsErr), stackNames{}
+	}
+
+	// A generated namespace must be new, or the stack would land in someone else's.
+	if payload.NamespacePerDeployment && !nsCreated {
+		return APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Namespace %s already exists; retry to get a new suffix", payload.Namespace),
+		}, http.StatusConflict, stackNames{}
+	}
+
+	// 1b. Cap what a new tenant namespace may consume. Existing namespaces keep their own quotas.
+	var warnings []string
+	if payload.NamespaceQuota != nil {
+		if nsCreated {
+			log.Printf("[INFO] Creating ResourceQuota %s in namespace %s", namespaceQuotaName, payload.Namespace)
+			if err := createNamespaceQuota(ctx, clientSet, payload.Namespace, *payload.NamespaceQuota); err != nil {
+				log.Printf("[ERROR] Failed to create namespace quota: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: fmt.Sprintf("Failed to create namespace quota: %v", err),
+				}, http.StatusInternalServerError, stackNames{}
+			}
+		} else {
+			warning := fmt.Sprintf("namespace_quota ignored: namespace %s already existed", payload.Namespace)
+			log.Printf("[WARN] %s", warning)
+			warnings = append(warnings, warning)
+		}
+	}
+
+	// A missing PriorityClass would leave every pod unschedulable; catch it before creating anything.
+	if payload.PriorityClassName != "" {
+		_, err := clientSet.SchedulingV1().PriorityClasses().Get(ctx, payload.PriorityClassName, metaV1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("PriorityClass %q does not exist", payload.PriorityClassName),
+			}, http.StatusBadRequest, stackNames{}
+		}
+		if err != nil {
+			// Reading PriorityClasses needs cluster-wide RBAC; go on and let the scheduler decide.
+			log.Printf("[WARN] Could not check PriorityClass %s: %v", payload.PriorityClassName, err)
+		}
+	}
+
+	// Pinned to a node that doesn't exist, the PVs could never be mounted.
+	if payload.HostNode != "" {
+		_, err := clientSet.CoreV1().Nodes().Get(ctx, payload.HostNode, metaV1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Node %q does not exist", payload.HostNode),
+			}, http.StatusBadRequest, stackNames{}
+		}
+		if err != nil {
+			// Reading nodes needs cluster-wide RBAC; go on and let the scheduler decide.
+			log.Printf("[WARN] Could not check node %s: %v", payload.HostNode, err)
+		}
+	}
+
+	if len(payload.ExtraVolumes) > 0 {
+		if err := checkExtraVolumes(ctx, clientSet, payload.Namespace, payload.ExtraVolumes); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}, http.StatusBadRequest, stackNames{}
+		}
+	}
+	if len(payload.ExtraEnvFrom) > 0 {
+		if err := checkExtraEnvFrom(ctx, clientSet, payload.Namespace, payload.ExtraEnvFrom); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}, http.StatusBadRequest, stackNames{}
+		}
+		collisions, err := envCollisionWarnings(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[WARN] Could not check extra_env_from for duplicate variables: %v", err)
+			collisions = []string{fmt.Sprintf("extra_env_from was not checked for duplicate variables: %v", err)}
+		}
+		for _, warning := range collisions {
+			log.Printf("[WARN] %s", warning)
+		}
+		warnings = append(warnings, collisions...)
+	}
+
+	// The suffix is random, not guaranteed unique: regenerate it while any of its names is taken.
+	names, err = unusedStackNames(ctx, clientSet, payload, names)
+	if errors.Is(err, errStackNamesTaken) {
+		log.Printf("[ERROR] %v", err)
+		return APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}, http.StatusConflict, stackNames{}
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to find unused resource names: %v", err)
+		return APIResponse{
+			Success: false,
+			Message: "Could not find unused resource names",
+		}, http.StatusInternalServerError, stackNames{}
+	}
+	resolveSharedDatabase(payload, names)
+
+	// hostPath ignores the claimed capacity, so check it against the nodes up front if asked to.
+	if payload.NodeCapacityCheck != "" {
+		requestedGB := payload.DatabaseDiskGB + payload.PersistenceDiskGB
+		if payload.ExternalDatabase != nil {
+			requestedGB = payload.PersistenceDiskGB
+		}
+		if payload.SharedVolume {
+			requestedGB = payload.SharedVolumeGB
+		}
+		warning, err := hostPathCapacityWarning(ctx, clientSet, requestedGB)
+		if err != nil {
+			// Listing nodes needs cluster-wide RBAC the deployer may not have; don't block on it.
+			log.Printf("[WARN] Could not check node capacity: %v", err)
+			warning = fmt.Sprintf("node capacity was not checked: %v", err)
+		} else if warning != "" && payload.NodeCapacityCheck == capacityCheckReject {
+			log.Printf("[ERROR] Requested disks don't fit: %s", warning)
+			return APIResponse{
+				Success: false,
+				Message: "Requested disk sizes exceed node capacity: " + warning,
+			}, http.StatusUnprocessableEntity, names
+		}
+		if warning != "" {
+			log.Printf("[WARN] %s", warning)
+			warnings = append(warnings, warning)
+		}
+	}
+
+	if warning := readOnlyRootWarning(payload); warning != "" {
+		log.Printf("[WARN] %s", warning)
+		warnings = append(warnings, warning)
+	}
+
+	// A missing image variant only shows up as ErrImagePull or a slow emulated pod, so say so now.
+	if payload.ImageArchCheck {
+		archWarnings, err := imageArchWarnings(ctx, clientSet, payload)
+		if err != nil {
+			log.Printf("[WARN] Could not check node architectures: %v", err)
+			archWarnings = []string{fmt.Sprintf("image architectures were not checked: %v", err)}
+		}
+		for _, warning := range archWarnings {
+			log.Printf("[WARN] %s", warning)
+		}
+		warnings = append(warnings, archWarnings...)
+	}
+
+	// A missing image otherwise only shows up as ImagePullBackOff and a readiness timeout minutes later.
+	if payload.ImagePullCheck {
+		warning, err := checkImagesPullable(ctx, clientSet, payload, names)
+		if errors.Is(err, errImageNotPullable) {
+			log.Printf("[ERROR] %v", err)
+			return APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}, http.StatusUnprocessableEntity, names
+		}
+		if err != nil {
+			log.Printf("[ERROR] Image pull check failed: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Could not check images: %v", err),
+			}, http.StatusInternalServerError, names
+		}
+		if warning != "" {
+			log.Printf("[WARN] %s", warning)
+			warnings = append(warnings, warning)
+		}
+	}
+
+	// First pulls on every node otherwise count against the readiness timeouts below.
+	if payload.PrepullImages {
+		warning, err := prepullImages(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Pre-pulling images failed: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Could not pre-pull images: %v", err),
+			}, http.StatusInternalServerError, names
+		}
+		if warning != "" {
+			log.Printf("[WARN] %s", warning)
+			warnings = append(warnings, warning)
+		}
+	}
+
+	// Without a default StorageClass, a claim naming no class is never provisioned and stays
+	// Pending forever; catch that, and a misspelt class, before creating anything.
+	if payload.DynamicProvisioning {
+		components := []string{componentDatabase, componentWordPress}
+		if payload.ExternalDatabase != nil {
+			components = components[1:]
+		}
+		checked := map[string]bool{}
+		for _, component := range components {
+			class := componentStorageClass(payload, component)
+			if checked[class] {
+				continue
+			}
+			checked[class] = true
+			if err := checkStorageClass(ctx, clientSet, class); err != nil {
+				log.Printf("[ERROR] %v", err)
+				return APIResponse{
+					Success: false,
+					Message: err.Error(),
+				}, http.StatusUnprocessableEntity, names
+			}
+		}
+	}
+
+	// The HTTPRoute is created last; without the Gateway API it would fail after everything else.
+	if payload.Gateway != nil {
+		installed, err := gatewayAPIInstalled(clientSet)
+		if err == nil && !installed {
+			err = fmt.Errorf("the cluster does not serve %s; install the Gateway API CRDs or drop gateway", gatewayGroupVersion)
+		}
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			return APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}, http.StatusUnprocessableEntity, names
+		}
+	}
+
+	if payload.SharedDatabase != nil {
+		if err := checkSharedDatabase(ctx, clientSet, payload); err != nil {
+			log.Printf("[ERROR] %v", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, errSharedDatabaseSetup) {
+				status = http.StatusUnprocessableEntity
+			}
+			return APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}, status, names
+		}
+	}
+
+	if payload.SecretMode == secretModeExternalSecret {
+		installed, err := externalSecretsInstalled(clientSet)
+		if err == nil && !installed {
+			err = fmt.Errorf("the cluster does not serve %s; install the external-secrets operator or use secret_mode %q",
+				externalSecretsGroupVersion, secretModeManaged)
+		}
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			return APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}, http.StatusUnprocessableEntity, names
+		}
+	}
+
+	// 1c. Optionally give the stack's pods a ServiceAccount of their own.
+	if payload.ServiceAccount {
+		log.Printf("[INFO] Creating service account: %s", names.ServiceAccount)
+		err := createStackServiceAccount(ctx, clientSet, payload, names)
+		if err == nil && len(payload.ServiceAccountRules) > 0 {
+			log.Printf("[INFO] Creating role and role binding: %s", names.ServiceAccount)
+			if err = createStackRole(ctx, clientSet, payload, names); err == nil {
+				err = createStackRoleBinding(ctx, clientSet, payload, names)
+			}
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to create service account: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to create service account: %v", err),
+			}, http.StatusInternalServerError, names
+		}
+	}
+
+	if payload.SharedVolume {
+		// 2-3. Mount the namespace's shared PV/PVC, creating it for the first stack.
+		log.Printf("[INFO] Ensuring shared PV/PVC: PV=%s, PVC=%s", sharedPVName(payload.Namespace), sharedPVCName)
+		if err := ensureSharedVolume(ctx, clientSet, payload); err != nil {
+			log.Printf("[ERROR] Failed to ensure shared volume: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to ensure shared volume: %v", err),
+			}, http.StatusInternalServerError, names
+		}
+	} else {
+		// 2-3. Create the PVs (hostPath only) and PVCs of MySQL and WordPress.
+		components := []string{componentDatabase, componentWordPress}
+		if payload.ExternalDatabase != nil {
+			components = components[1:]
+		}
+		results := createStackVolumes(ctx, clientSet, payload, names, components)
+		for _, res := range results {
+			if res.Note != "" {
+				log.Printf("[WARN] %s", res.Note)
+				warnings = append(warnings, res.Note)
+			}
+		}
+		if res, failed := firstVolumeFailure(ctx, results); failed {
+			log.Printf("[ERROR] Failed to create %s: %v", res.What, res.Err)
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to create %s: %v", res.What, res.Err),
+			}, res.Status, names
+		}
+	}
+
+	// 4. Create Secret with random credentials for MySQL root and wordpress user, or have the
+	// external-secrets operator create it from the remote key.
+	if payload.SecretMode == secretModeExternalSecret {
+		log.Printf("[INFO] Creating ExternalSecret %s from %s key %s", names.DBSecret,
+			payload.ExternalSecret.SecretStore, payload.ExternalSecret.Key)
+		err = createStackExternalSecret(ctx, clientSet, payload, names)
+		if err == nil {
+			err = waitForExternalSecret(ctx, clientSet, payload, names)
+		}
+	} else {
+		log.Printf("[INFO] Creating combined MySQL & WordPress secret: %s", names.DBSecret)
+		err = createWPMySQLSecret(ctx, clientSet, payload, names)
+	}
+	if errors.Is(err, errExternalSecretNotSynced) {
+		log.Printf("[ERROR] %v", err)
+		return APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}, http.StatusBadGateway, names
+	}
+	if errors.Is(err, errSecretSink) {
+		log.Printf("[ERROR] Failed to export MySQL/WordPress credentials: %v", err)
+		return APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}, http.StatusBadGateway, names
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to create MySQL/WordPress Secret: %v", err)
+		return APIResponse{
+			Success: false,
+			Message: "Failed to create MySQL/WordPress Secret",
+		}, http.StatusInternalServerError, names
+	}
+
+	if payload.DBCACert != "" {
+		log.Printf("[INFO] Creating database CA secret: %s", names.DBCASecret)
+		err = createDBCASecret(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create database CA Secret: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create database CA Secret",
+			}, http.StatusInternalServerError, names
+		}
+	}
+	if ownsObjectStorageSecret(payload) {
+		log.Printf("[INFO] Creating object storage secret: %s", names.WPObjectStorageSecret)
+		err = createObjectStorageSecret(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create object storage Secret: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create object storage Secret",
+			}, http.StatusInternalServerError, names
+		}
+	}
+
+	if ext := payload.ExternalDatabase; ext != nil {
+		// 5-6. No MySQL of our own: create the stack's database on the shared one, and optionally
+		// make sure the external one answers before WordPress starts.
+		if payload.SharedDatabase != nil {
+			log.Printf("[INFO] Creating database %s and user %s on %s:%d with job %s", ext.Name, ext.User,
+				ext.Host, ext.Port, names.DBProvisionJob)
+			err = provisionSharedDatabase(ctx, clientSet, payload, names, 120*time.Second)
+			if err != nil {
+				log.Printf("[ERROR] Shared database provisioning failed: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: fmt.Sprintf("Cannot create database %s on %s:%d as %s: %v",
+						ext.Name, ext.Host, ext.Port, payload.SharedDatabase.AdminUser, err),
+				}, http.StatusBadGateway, names
+			}
+			log.Println("[INFO] Shared database provisioned.")
+		}
+		if payload.CheckExternalDatabase {
+			log.Printf("[INFO] Checking external database %s:%d with job %s", ext.Host, ext.Port, names.DBCheckJob)
+			err = checkExternalDatabase(ctx, clientSet, payload, names, 120*time.Second)
+			if err != nil {
+				log.Printf("[ERROR] External database check failed: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: fmt.Sprintf("Cannot connect to external database %s:%d as %s: %v",
+						ext.Host, ext.Port, ext.User, err),
+				}, http.StatusBadGateway, names
+			}
+			log.Println("[INFO] External database is reachable.")
+		}
+	} else {
+		if payload.MySQLConfig != "" {
+			log.Printf("[INFO] Creating MySQL configuration configmap: %s", names.DBConfig)
+			err = createMySQLConfigMap(ctx, clientSet, payload, names)
+			if err != nil {
+				log.Printf("[ERROR] Failed to create MySQL configuration configmap: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: "Failed to create MySQL configuration configmap",
+				}, http.StatusInternalServerError, names
+			}
+		}
+		if payload.MySQLReadReplica {
+			log.Printf("[INFO] Creating MySQL replication configmap: %s", names.DBReplicationConfig)
+			err = createMySQLReplicationConfigMap(ctx, clientSet, payload, names)
+			if err != nil {
+				log.Printf("[ERROR] Failed to create replication configmap: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: "Failed to create MySQL replication configmap",
+				}, http.StatusInternalServerError, names
+			}
+		}
+
+		// 5. Deploy MySQL (Deployment + Service)
+		log.Printf("[INFO] Creating MySQL deployment: %s", names.DBDeployment)
+		err = createMySQLDeployment(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create MySQL deployment: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create MySQL deployment",
+			}, http.StatusInternalServerError, names
+		}
+
+		log.Printf("[INFO] Creating MySQL service: %s", names.DBService)
+		err = createMySQLService(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create MySQL service: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create MySQL service",
+			}, http.StatusInternalServerError, names
+		}
+
+		// 6. Wait for MySQL deployment to be ready
+		if waitForReady(payload) {
+			log.Println("[INFO] Waiting for MySQL deployment to be ready...")
+			err = waitForDeploymentReady(ctx, clientSet, payload.Namespace, names.DBDeployment, readinessTimeout(payload), pollInterval(payload))
+			if err != nil {
+				log.Printf("[ERROR] MySQL deployment not ready in time: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: notReadyMessage("MySQL deployment", err),
+				}, http.StatusInternalServerError, names
+			}
+			log.Println("[INFO] MySQL deployment is running and ready.")
+		}
+
+		// 6b. Optionally add the read replica; it needs the primary up to start replicating.
+		if payload.MySQLReadReplica {
+			log.Printf("[INFO] Creating MySQL read replica: %s", names.DBReplicaDeployment)
+			err = createMySQLReplicaDeployment(ctx, clientSet, payload, names)
+			if err == nil {
+				err = createMySQLReplicaService(ctx, clientSet, payload, names)
+			}
+			if err != nil {
+				log.Printf("[ERROR] Failed to create MySQL read replica: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: "Failed to create MySQL read replica",
+				}, http.StatusInternalServerError, names
+			}
+			if waitForReady(payload) {
+				err = waitForDeploymentReady(ctx, clientSet, payload.Namespace, names.DBReplicaDeployment, readinessTimeout(payload), pollInterval(payload))
+				if err != nil {
+					log.Printf("[ERROR] MySQL read replica not ready in time: %v", err)
+					return APIResponse{
+						Success: false,
+						Message: notReadyMessage("MySQL read replica", err),
+					}, http.StatusInternalServerError, names
+				}
+				log.Println("[INFO] MySQL read replica is running and ready.")
+			}
+		}
+	}
+
+	// 6c. Optionally add phpMyAdmin; nothing waits on it, WordPress doesn't need it.
+	if payload.PhpMyAdmin {
+		log.Printf("[INFO] Creating phpMyAdmin deployment: %s", names.PMADeployment)
+		err = createPhpMyAdminDeployment(ctx, clientSet, payload, names)
+		if err == nil {
+			err = createPhpMyAdminService(ctx, clientSet, payload, names)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to create phpMyAdmin: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create phpMyAdmin",
+			}, http.StatusInternalServerError, names
+		}
+	}
+
+	// 6d. Optionally add the Redis object cache; WordPress runs without it until the plugin is enabled.
+	if payload.RedisCache {
+		log.Printf("[INFO] Creating Redis deployment: %s", names.RedisDeployment)
+		err = createRedisDeployment(ctx, clientSet, payload, names)
+		if err == nil {
+			err = createRedisService(ctx, clientSet, payload, names)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to create Redis: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create Redis",
+			}, http.StatusInternalServerError, names
+		}
+	}
+
+	// 7. Deploy WordPress (Deployment + Service)
+	log.Printf("[INFO] Creating WordPress deployment: %s", names.WPDeployment)
+	err = createWordPressDeployment(ctx, clientSet, payload, names)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create WordPress deployment: %v", err)
+		return APIResponse{
+			Success: false,
+			Message: "Failed to create WordPress deployment",
+		}, http.StatusInternalServerError, names
+	}
+
+	log.Printf("[INFO] Creating WordPress service: %s", names.WPService)
+	err = createWordPressService(ctx, clientSet, payload, names)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create WordPress service: %v", err)
+		return APIResponse{
+			Success: false,
+			Message: "Failed to create WordPress service",
+		}, http.StatusInternalServerError, names
+	}
+
+	// 7a. Optionally route an existing Gateway to the service.
+	if payload.Gateway != nil {
+		log.Printf("[INFO] Creating HTTPRoute %s for gateway %s", names.HTTPRoute, payload.Gateway.GatewayName)
+		if err := createWordPressHTTPRoute(ctx, clientSet, payload, names); err != nil {
+			log.Printf("[ERROR] Failed to create HTTPRoute: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to create HTTPRoute: %v", err),
+			}, http.StatusInternalServerError, names
+		}
+	}
+
+	// 8. Wait for WordPress deployment to be ready
+	if waitForReady(payload) {
+		log.Println("[INFO] Waiting for WordPress deployment to be ready...")
+		err = waitForDeploymentReady(ctx, clientSet, payload.Namespace, names.WPDeployment, readinessTimeout(payload), pollInterval(payload))
+		if err != nil {
+			log.Printf("[ERROR] WordPress deployment not ready in time: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: notReadyMessage("WordPress deployment", err),
+			}, http.StatusInternalServerError, names
+		}
+		log.Println("[INFO] WordPress deployment is running and ready.")
+	}
+
+	// 8a. Optionally add the canary next to it; the Service picks its pods up by label.
+	if payload.Canary != nil {
+		log.Printf("[INFO] Creating WordPress canary deployment: %s (%s)", names.WPCanaryDeployment, payload.Canary.Image)
+		err = createWordPressCanaryDeployment(ctx, clientSet, payload, names)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create WordPress canary deployment: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Failed to create WordPress canary deployment",
+			}, http.StatusInternalServerError, names
+		}
+		if waitForReady(payload) {
+			err = waitForDeploymentReady(ctx, clientSet, payload.Namespace, names.WPCanaryDeployment, readinessTimeout(payload), pollInterval(payload))
+			if err != nil {
+				log.Printf("[ERROR] WordPress canary not ready in time: %v", err)
+				return APIResponse{
+					Success: false,
+					Message: notReadyMessage("WordPress canary deployment", err),
+				}, http.StatusInternalServerError, names
+			}
+			log.Println("[INFO] WordPress canary deployment is running and ready.")
+		}
+	}
+
+	// 8b. Optionally confirm WordPress can reach MySQL; readiness alone doesn't prove it.
+	if payload.VerifyDBConnection {
+		err = verifyWordPressDBConnection(ctx, clientSet, payload.Namespace, names.WPService, 60*time.Second)
+		if errors.Is(err, errWordPressDBConnection) {
+			log.Printf("[ERROR] WordPress cannot reach MySQL: %v", err)
+			dbHost := "MySQL service " + names.DBService
+			if ext := payload.ExternalDatabase; ext != nil {
+				dbHost = fmt.Sprintf("external database %s:%d", ext.Host, ext.Port)
+			}
+			return APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("WordPress is running but cannot connect to %s; check secret %s",
+					dbHost, names.DBSecret),
+			}, http.StatusBadGateway, names
+		}
+		if err != nil {
+			log.Printf("[ERROR] Could not verify database connectivity: %v", err)
+			return APIResponse{
+				Success: false,
+				Message: "Could not verify WordPress database connectivity",
+			}, http.StatusInternalServerError, names
+		}
+		log.Println("[INFO] WordPress database connectivity verified.")
+	}
+
+	// 9. Build a summary
+	resources := names.summary(payload)
+	message := "WordPress + MySQL stack created successfully. Strong random credentials have been set for MySQL."
+	if payload.Canary != nil {
+		message += fmt.Sprintf(" Canary %s receives about %d%% of traffic.", payload.Canary.Image, canaryWeightPercent(payload))
+	}
+	if !waitForReady(payload) {
+		message = "WordPress + MySQL stack created; readiness was not verified, poll /status for it. Strong random credentials have been set for MySQL."
+	}
+	if payload.ObjectStorage != nil && !payload.AutoInstall {
+		message += fmt.Sprintf(" Media offload to %s is configured; install and activate the %s plugin to enable it.",
+			payload.ObjectStorage.Bucket, objectStoragePlugin)
+	}
+	if payload.RedisCache && !payload.AutoInstall {
+		message += fmt.Sprintf(" Redis is configured as object cache; install the %s plugin and run `wp redis enable` to use it.",
+			redisCachePlugin)
+	}
+	resp := APIResponse{Success: true, Warnings: warnings}
+	if payload.NamespaceQuota != nil && nsCreated {
+		resources.add("ResourceQuota", namespaceQuotaName)
+	}
+	if payload.MySQLReadReplica {
+		resources.add("ConfigMap", names.DBReplicationConfig)
+		resources.add("MySQL Replica Deployment", names.DBReplicaDeployment)
+		resources.add("MySQL Replica Service", names.DBReplicaService)
+	}
+
+	// 10. Optionally run the WordPress installer so the site is ready to log in.
+	if payload.AutoInstall {
+		log.Printf("[INFO] Running automated WordPress install job: %s", names.WPInstallJob)
+		err = installWordPress(ctx, clientSet, payload, names, 180*time.Second)
+		installed := err == nil
+		resp.WordPressInstalled = &installed
+		resources.add("Secret", names.WPAdminSecret)
+		resources.add("Job", names.WPInstallJob)
+		if err != nil {
+			// The stack itself is up; report the install failure without failing the request.
+			log.Printf("[ERROR] Automated WordPress install failed: %v", err)
+			message += fmt.Sprintf(" Automated install failed (%v); finish it in the browser.", err)
+		} else {
+			message += " WordPress was installed automatically."
+			creds := AdminCredentials{
+				User:     payload.WPAdminUser,
+				Password: payload.WPAdminPassword,
+				Email:    payload.WPAdminEmail,
+				LoginURL: wpSiteURL(payload, names) + "/wp-login.php",
+			}
+			if payload.WPAdminDelivery == adminDeliveryClaim {
+				claim, err := newAdminClaim(creds)
+				if err != nil {
+					log.Printf("[ERROR] Failed to store admin credentials for claiming: %v", err)
+					message += fmt.Sprintf(" The admin credentials could not be offered for claiming; read them from secret %s.",
+						names.WPAdminSecret)
+				} else {
+					resp.WPAdminClaim = &claim
+				}
+			} else {
+				resp.WPAdmin = &creds
+			}
+		}
+
+		// 11. Install the requested plugins and themes on the freshly installed site.
+		if installed && len(payload.WPPlugins)+len(payload.WPThemes) > 0 {
+			log.Printf("[INFO] Installing plugins/themes with job: %s", names.WPExtensionsJob)
+			resp.Extensions, err = installExtensions(ctx, clientSet, payload, names, 300*time.Second)
+			resources.add("Job", names.WPExtensionsJob)
+			if err != nil {
+				log.Printf("[ERROR] Plugin/theme install failed: %v", err)
+				message += fmt.Sprintf(" Plugin/theme install failed: %v.", err)
+			}
+			for _, ext := range resp.Extensions {
+				if !ext.Installed {
+					message += fmt.Sprintf(" %s %s was not installed.", ext.Type, ext.Slug)
+				}
+			}
+		}
+	}
+
+	// 12. Optionally keep the list of what was created, for /stacks/{id}/manifest.
+	if payload.SaveManifest {
+		refs := append(append([]ResourceRef(nil), resources.Refs...),
+			ResourceRef{Kind: "ConfigMap", Name: names.StackMetadata, Namespace: payload.Namespace})
+		if err := createStackMetadata(ctx, clientSet, payload, names, refs); err != nil {
+			log.Printf("[WARN] Failed to save the stack manifest: %v", err)
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("The stack manifest was not saved: %v", err))
+		} else {
+			resources.add("ConfigMap", names.StackMetadata)
+		}
+	}
+
+	log.Printf("[INFO] Successfully created resources: %+v", resources.Summaries)
+
+	resp.Message = message
+	resp.Resources = resources.Summaries
+	resp.ResourceRefs = resources.Refs
+	resp.Suffix = names.Suffix
+	resp.SiteURL = wpSiteURL(payload, names)
+	return resp, http.StatusOK, names
+}
+
+// statusClientClosedRequest is the non-standard status nginx logs when the client hangs up
+// before the response; nobody reads the body, but it keeps such aborts apart from 500s.
+const statusClientClosedRequest = 499
+
+// generatedNamespace returns the namespace to report back when the deployer chose it.
+func generatedNamespace(payload RequestPayload) string {
+	if payload.NamespacePerDeployment {
+		return payload.Namespace
+	}
+	return ""
+}
+
+// requireJSON answers 415 unless the request body is declared as JSON, and reports whether
+// the handler may go on.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return true
+	}
+	w.WriteHeader(http.StatusUnsupportedMediaType)
+	respondJSON(w, APIResponse{
+		Success: false,
+		Message: "Content-Type must be application/json",
+	})
+	return false
+}
+
+// respondJSON is a helper to send JSON responses.
+func respondJSON(w http.ResponseWriter, resp APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// buildResourceName constructs a Kubernetes resource name that is guaranteed
+// to be ≤ 60 characters. It uses the format:
+//
+//	<prefix> + "-" + <suffix> + "-" + <resourceType>
+//
+// Where:
+//   - <prefix> is truncated if it’s too long
+//   - <suffix> is 5 random chars
+//   - <resourceType> is a short string like "db-pv" or "wp-svc"
+func buildResourceName(userPrefix, resourceType, suffix string) string {
+	// We want the final string <= 60 chars total.
+	// We'll do: userPrefix + "-" + suffix + "-" + resourceType
+	// So total length = len(userPrefix) + 1 + len(suffix) + 1 + len(resourceType).
+	// That is len(userPrefix) + len(resourceType) + len(suffix) + 2.
+	// Without a suffix it is userPrefix + "-" + resourceType, one dash fewer.
+	maxTotal := maxResourceNameLength
+	fixedLen := len(resourceType) + len(suffix) + 2 // resourceType + suffix + 2 dashes
+	if suffix == "" {
+		fixedLen = len(resourceType) + 1
+	}
+
+	// The user prefix can occupy the remainder:
+	allowed := maxTotal - fixedLen
+	if allowed < 0 {
+		// if resourceType + suffix + 2 alone exceed 60 (extremely unlikely), fallback to a minimal prefix
+		allowed = 0
+	}
+	if len(userPrefix) > allowed {
+		userPrefix = userPrefix[:allowed]
+	}
+	if suffix == "" {
+		return fmt.Sprintf("%s-%s", userPrefix, resourceType)
+	}
+	return fmt.Sprintf("%s-%s-%s", userPrefix, suffix, resourceType)
+}
+
+// validateLabels checks that every key and value is a valid Kubernetes label.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("value %q for key %q: %s", v, k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// maxSuffixAttempts bounds how many random suffixes are tried before giving up on a free name.
+const maxSuffixAttempts = 5
+
+// defaultSuffixLength and maxSuffixLength bound suffix_length. Short suffixes collide sooner;
+// the collision check regenerates them up to maxSuffixAttempts times.
+const (
+	defaultSuffixLength = 5
+	maxSuffixLength     = 16
+)
+
+// stackNames holds the generated name of every resource in one WordPress + MySQL stack.
+type stackNames struct {
+	Prefix string
+	Suffix string
+
+	DBPV         string
+	DBPVC        string
+	DBDeployment string
+	DBService    string
+	DBSecret     string
+
+	WPPV         string
+	WPPVC        string
+	WPDeployment string
+	WPService    string
+
+	// Only created when a canary is requested.
+	WPCanaryDeployment string
+
+	// Only created when external_database is checked.
+	DBCheckJob string
+	// Only created with shared_database.
+	DBProvisionJob string
+
+	// Only created, and deleted again, while image_pull_check runs.
+	ImageCheckPod string
+
+	// Only created, and deleted again, while prepull_images runs.
+	PrepullDaemonSet string
+
+	// Only created when mysql_config is given.
+	DBConfig string
+
+	// Only created when mysql_read_replica is requested.
+	DBReplicationConfig string
+	DBReplicaDeployment string
+	DBReplicaService    string
+
+	// Only created when phpmyadmin is requested.
+	PMADeployment string
+	PMAService    string
+
+	// Only created when redis_cache is requested.
+	RedisDeployment string
+	RedisService    string
+
+	// Only created when gateway is given.
+	HTTPRoute string
+
+	// Only created when save_manifest is requested.
+	StackMetadata string
+
+	// Only created when service_account is requested; its Role and RoleBinding share the name.
+	ServiceAccount string
+
+	// Only created when db_ca_cert is given.
+	DBCASecret string
+
+	// Only created when object_storage brings its own keys.
+	WPObjectStorageSecret string
+
+	// Only created when auto_install is requested.
+	WPAdminSecret   string
+	WPInstallJob    string
+	WPExtensionsJob string
+
+	// Only created when a stack on the shared volume is deleted with keep_data false.
+	SharedDataCleanupJob string
+}
+
+// newStackNames derives all resource names of a stack from the user prefix and the random suffix.
+func newStackNames(prefix, suffix string) stackNames {
+	return newTemplatedStackNames(nil, "", prefix, suffix)
+}
+
+// newTemplatedStackNames is newStackNames with the names rendered from a name_template, unless tmpl is nil.
+func newTemplatedStackNames(tmpl *template.Template, namespace, prefix, suffix string) stackNames {
+	name := func(resourceType string) string {
+		return renderResourceName(tmpl, namespace, prefix, resourceType, suffix)
+	}
+	return stackNames{
+		Prefix: prefix,
+		Suffix: suffix,
+
+		DBPV:         name("db-pv"),
+		DBPVC:        name("db-pvc"),
+		DBDeployment: name("db"),
+		DBService:    name("db-svc"),
+		DBSecret:     name("db-secret"),
+
+		DBCheckJob:          name("db-check"),
+		DBProvisionJob:      name("db-provision"),
+		ImageCheckPod:       name("img-check"),
+		PrepullDaemonSet:    name("prepull"),
+		DBConfig:            name("db-cnf"),
+		DBReplicationConfig: name("db-repl"),
+		DBReplicaDeployment: name("db-ro"),
+		DBReplicaService:    name("db-ro-svc"),
+
+		WPPV:         name("wp-pv"),
+		WPPVC:        name("wp-pvc"),
+		WPDeployment: name("wp"),
+		WPService:    name("wp-svc"),
+
+		WPCanaryDeployment: name("wp-canary"),
+
+		PMADeployment: name("pma"),
+		PMAService:    name("pma-svc"),
+
+		RedisDeployment: name("redis"),
+		RedisService:    name("redis-svc"),
+
+		HTTPRoute:     name("route"),
+		StackMetadata: name("meta"),
+
+		ServiceAccount: name("sa"),
+
+		DBCASecret:            name("db-ca"),
+		WPObjectStorageSecret: name("wp-s3"),
+
+		WPAdminSecret:   name("wp-admin"),
+		WPInstallJob:    name("wp-install"),
+		WPExtensionsJob: name("wp-ext"),
+
+		SharedDataCleanupJob: name("data-rm"),
+	}
+}
+
+// ID identifies the stack in labels as "<prefix>-<suffix>", shortened to fit a label value.
+func (n stackNames) ID() string {
+	prefix := n.Prefix
+	if n.Suffix == "" {
+		if len(prefix) > 63 {
+			prefix = prefix[:63]
+		}
+		return prefix
+	}
+	if max := 63 - len(n.Suffix) - 1; len(prefix) > max {
+		prefix = prefix[:max]
+	}
+	return prefix + "-" + n.Suffix
+}
+
+// validate checks that every resource name is one Kubernetes accepts: a DNS-1123 label, and
+// for Services a DNS-1035 label, which must also start with a letter.
+func (n stackNames) validate() error {
+	v := reflect.ValueOf(n)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i).Name
+		if field == "Prefix" || field == "Suffix" {
+			continue
+		}
+		name := v.Field(i).String()
+		if len(name) > maxResourceNameLength {
+			return fmt.Errorf("%q is longer than %d characters", name, maxResourceNameLength)
+		}
+		errs := validation.IsDNS1123Label(name)
+		if strings.HasSuffix(field, "Service") {
+			errs = validation.IsDNS1035Label(name)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%q: %s", name, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// summary lists the stack's resources in creation order for the API response.
+func (n stackNames) summary(payload RequestPayload) *resourceList {
+	resources := newResourceList(payload.Namespace)
+	resources.add("Namespace", payload.Namespace)
+	switch {
+	case payload.SharedVolume:
+		resources.add("PV", sharedPVName(payload.Namespace))
+		resources.add("PVC", sharedPVCName)
+	case payload.DynamicProvisioning && payload.ExternalDatabase != nil:
+		resources.add("PVC", n.WPPVC)
+	case payload.DynamicProvisioning:
+		resources.add("PVC", n.DBPVC)
+		resources.add("PVC", n.WPPVC)
+	case payload.ExternalDatabase != nil:
+		resources.add("PV", n.WPPV)
+		resources.add("PVC", n.WPPVC)
+	default:
+		resources.add("PV", n.DBPV)
+		resources.add("PVC", n.DBPVC)
+		resources.add("PV", n.WPPV)
+		resources.add("PVC", n.WPPVC)
+	}
+	if payload.ServiceAccount {
+		resources.add("ServiceAccount", n.ServiceAccount)
+		if len(payload.ServiceAccountRules) > 0 {
+			resources.add("Role", n.ServiceAccount)
+			resources.add("RoleBinding", n.ServiceAccount)
+		}
+	}
+	if payload.SecretMode == secretModeExternalSecret {
+		resources.add("ExternalSecret", n.DBSecret)
+	}
+	resources.add("Secret", n.DBSecret)
+	if payload.DBCACert != "" {
+		resources.add("Secret", n.DBCASecret)
+	}
+	if ownsObjectStorageSecret(payload) {
+		resources.add("Secret", n.WPObjectStorageSecret)
+	}
+	if payload.ExternalDatabase == nil {
+		if payload.MySQLConfig != "" {
+			resources.add("ConfigMap", n.DBConfig)
+		}
+		resources.add("MySQL Deployment", n.DBDeployment)
+		resources.add("MySQL Service", n.DBService)
+	} else {
+		if payload.SharedDatabase != nil {
+			resources.add("Job", n.DBProvisionJob)
+		}
+		if payload.CheckExternalDatabase {
+			resources.add("Job", n.DBCheckJob)
+		}
+	}
+	if payload.PhpMyAdmin {
+		resources.add("phpMyAdmin Deployment", n.PMADeployment)
+		resources.add("phpMyAdmin Service", n.PMAService)
+	}
+	if payload.RedisCache {
+		resources.add("Redis Deployment", n.RedisDeployment)
+		resources.add("Redis Service", n.RedisService)
+	}
+	resources.add("WordPress Deployment", n.WPDeployment)
+	if payload.Canary != nil {
+		resources.add("WordPress Canary Deployment", n.WPCanaryDeployment)
+	}
+	resources.add("WordPress Service", n.WPService)
+	if payload.Gateway != nil {
+		resources.add("HTTPRoute", n.HTTPRoute)
+	}
+	return resources
+}
+
+// hostPathRoot is the node directory under which hostPath PVs keep their data.
+const hostPathRoot = "/mnt/data"
+
+// hostPathTypes are the values of host_path_type.
+var hostPathTypes = []string{string(corev1.HostPathDirectoryOrCreate), string(corev1.HostPathDirectory)}
+
+// Limits on hostPath directories: NAME_MAX for each path segment and PATH_MAX, less its
+// terminating NUL, for the whole path, as on Linux nodes.
+const (
+	maxHostPathSegment = 255
+	maxHostPathLength  = 4095
+)
+
+// hostPathFor returns the node directory backing a hostPath PV.
+func hostPathFor(namespace, pvName string) string {
+	return hostPathRoot + "/" + namespace + "/" + pvName + "_data"
+}
+
+// validateHostPath checks that a hostPath from hostPathFor is a clean directory below
+// hostPathRoot that node filesystems accept. The API server takes any path for a PV; a bad
+// one only shows up once the kubelet fails to mount it.
+func validateHostPath(dir string) error {
+	if path.Clean(dir) != dir || !strings.HasPrefix(dir, hostPathRoot+"/") {
+		return fmt.Errorf("%q is not a plain directory under %s", dir, hostPathRoot)
+	}
+	if len(dir) > maxHostPathLength {
+		return fmt.Errorf("%q is longer than %d characters", dir, maxHostPathLength)
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(dir, hostPathRoot+"/"), "/") {
+		if len(segment) > maxHostPathSegment {
+			return fmt.Errorf("%q has a directory name longer than %d characters", dir, maxHostPathSegment)
+		}
+	}
+	return nil
+}
+
+// randSuffixFunc generates the suffix of resource names. Tests can override it to get a fixed
+// suffix and so predictable names; job and request IDs always use generateRandomSuffix.
+var randSuffixFunc = generateRandomSuffix
 
 // generateRandomSuffix creates a random string of length n from [a-z0-9].
 func generateRandomSuffix(n int) (string, error) {
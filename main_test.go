@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidatePayloadConflicts(t *testing.T) {
+	no := false
+	deadline := int32(30)
+
+	tests := []struct {
+		name   string
+		modify func(p *RequestPayload)
+		want   string // Substring of the single expected conflict; empty for none
+	}{
+		{"no conflicts", func(p *RequestPayload) {}, ""},
+		{"replicas on one hostPath node", func(p *RequestPayload) { p.Replicas = 3; p.HostNode = "node-1" }, ""},
+		{"replicas with a storage class", func(p *RequestPayload) { p.Replicas = 3; p.StorageClass = "fast" }, ""},
+
+		{"external and shared database", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.SharedDatabase = &SharedDatabase{}
+		}, "external_database and shared_database"},
+		{"shared database in a generated namespace", func(p *RequestPayload) {
+			p.Namespace = ""
+			p.SharedDatabase = &SharedDatabase{}
+			p.NamespacePerDeployment = true
+		}, "shared_database needs the shared server"},
+		{"external database with mysql_read_replica", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.MySQLReadReplica = true
+		}, "mysql_read_replica cannot be combined with external_database"},
+		{"external database with database_replicas", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.DatabaseReplicas = 1
+		}, "database_replicas cannot be combined with external_database"},
+		{"external database with mysql_resources", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.MySQLResources = &ResourceSpec{}
+		}, "mysql_resources cannot be combined with external_database"},
+		{"external database with mysql_args", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.MySQLArgs = []string{"--skip-log-bin"}
+		}, "mysql_args cannot be combined with external_database"},
+		{"external database with mysql_config", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.MySQLConfig = "[mysqld]"
+		}, "mysql_config cannot be combined with external_database"},
+		{"external database with mysql_innodb_buffer_pool_mb", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.MySQLInnoDBBufferPoolMB = 128
+		}, "mysql_innodb_buffer_pool_mb cannot be combined with external_database"},
+		{"external database with mysql_data_path", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.MySQLDataPath = "/data"
+		}, "mysql_data_path cannot be combined with external_database"},
+		{"external database with database_disk_size", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.DatabaseDiskGB = 5
+		}, "database_disk_size cannot be combined with external_database"},
+		{"external database with database_storage_class", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.DatabaseStorageClass = "fast"
+		}, "database_storage_class cannot be combined with external_database"},
+		{"external database with volume_mode", func(p *RequestPayload) {
+			p.ExternalDatabase = &ExternalDatabase{}
+			p.VolumeMode = "Filesystem"
+		}, "volume_mode cannot be combined with external_database"},
+		{"shared database with mysql_config", func(p *RequestPayload) {
+			p.SharedDatabase = &SharedDatabase{}
+			p.MySQLConfig = "[mysqld]"
+		}, "mysql_config cannot be combined with shared_database"},
+		{"check_external_database alone", func(p *RequestPayload) { p.CheckExternalDatabase = true }, "check_external_database requires"},
+		{"db_ca_cert alone", func(p *RequestPayload) { p.DBCACert = "pem" }, "db_ca_cert requires"},
+
+		{"read replica on MySQL 5", func(p *RequestPayload) {
+			p.MySQLReadReplica = true
+			p.MySQLImage = "mysql:5.7"
+		}, "mysql_read_replica requires MySQL 8"},
+		{"read replica before CHANGE REPLICATION SOURCE", func(p *RequestPayload) {
+			p.MySQLReadReplica = true
+			p.MySQLImage = "mysql:8.0.22"
+		}, "mysql_read_replica requires MySQL 8.0.23"},
+		{"database_replicas without read replica", func(p *RequestPayload) { p.DatabaseReplicas = 3 }, "database_replicas above 1 requires mysql_read_replica"},
+		{"read replica with one database replica", func(p *RequestPayload) {
+			p.MySQLReadReplica = true
+			p.DatabaseReplicas = 1
+		}, "mysql_read_replica requires database_replicas of at least 2"},
+
+		{"namespace with generated namespace", func(p *RequestPayload) { p.NamespacePerDeployment = true }, "namespace cannot be combined"},
+		{"extra_volumes in a generated namespace", func(p *RequestPayload) {
+			p.Namespace = ""
+			p.NamespacePerDeployment = true
+			p.ExtraVolumes = []ExtraVolume{{ConfigMap: "cfg", MountPath: "/cfg"}}
+		}, "extra_volumes cannot be combined with namespace_per_deployment"},
+		{"extra_env_from in a generated namespace", func(p *RequestPayload) {
+			p.Namespace = ""
+			p.NamespacePerDeployment = true
+			p.ExtraEnvFrom = []EnvSource{{ConfigMap: "cfg"}}
+		}, "extra_env_from cannot be combined with namespace_per_deployment"},
+
+		{"shared_volume with dynamic provisioning", func(p *RequestPayload) {
+			p.DynamicProvisioning = true
+			p.SharedVolume = true
+		}, "shared_volume cannot be combined with dynamic provisioning"},
+		{"node_capacity_check with a storage class", func(p *RequestPayload) {
+			p.StorageClass = "fast"
+			p.NodeCapacityCheck = capacityCheckWarn
+		}, "node_capacity_check only applies"},
+		{"host_node with dynamic provisioning", func(p *RequestPayload) {
+			p.DynamicProvisioning = true
+			p.HostNode = "node-1"
+		}, "host_node only applies"},
+		{"host_path_type with a WordPress storage class", func(p *RequestPayload) {
+			p.WordPressStorageClass = "fast"
+			p.HostPathType = "Directory"
+		}, "host_path_type only applies"},
+		{"reuse_existing_pv with a database storage class", func(p *RequestPayload) {
+			p.DatabaseStorageClass = "fast"
+			p.ReuseExistingPV = true
+		}, "reuse_existing_pv only applies"},
+		{"replicas on a hostPath volume", func(p *RequestPayload) { p.Replicas = 2 }, "replicas above 1 requires host_node"},
+		{"shared_volume_size alone", func(p *RequestPayload) { p.SharedVolumeGB = 5 }, "shared_volume_size requires shared_volume"},
+
+		{"block volume on hostPath", func(p *RequestPayload) {
+			p.VolumeMode = "Block"
+			p.MySQLConfig = "[mysqld]"
+		}, "volume_mode Block requires dynamic provisioning"},
+		{"block volume without mysql_config", func(p *RequestPayload) {
+			p.VolumeMode = "Block"
+			p.DynamicProvisioning = true
+		}, "volume_mode Block requires mysql_config"},
+		{"block volume with read replica", func(p *RequestPayload) {
+			p.VolumeMode = "Block"
+			p.DynamicProvisioning = true
+			p.MySQLConfig = "[mysqld]"
+			p.MySQLReadReplica = true
+		}, "volume_mode Block cannot be combined with mysql_read_replica"},
+		{"wait_for_database_image alone", func(p *RequestPayload) { p.WaitForDBImage = "busybox" }, "wait_for_database_image requires"},
+
+		{"wp_plugins without auto_install", func(p *RequestPayload) { p.WPPlugins = []string{"akismet"} }, "wp_plugins requires auto_install"},
+		{"wp_themes without auto_install", func(p *RequestPayload) { p.WPThemes = []string{"twentytwenty"} }, "wp_themes requires auto_install"},
+		{"wp_admin_user without auto_install", func(p *RequestPayload) { p.WPAdminUser = "admin" }, "wp_admin_user requires auto_install"},
+		{"wp_admin_password without auto_install", func(p *RequestPayload) { p.WPAdminPassword = "secret" }, "wp_admin_password requires auto_install"},
+		{"wp_admin_email without auto_install", func(p *RequestPayload) { p.WPAdminEmail = "a@example.com" }, "wp_admin_email requires auto_install"},
+		{"wp_site_title without auto_install", func(p *RequestPayload) { p.WPSiteTitle = "Blog" }, "wp_site_title requires auto_install"},
+		{"wp_admin_delivery without auto_install", func(p *RequestPayload) { p.WPAdminDelivery = adminDeliveryClaim }, "wp_admin_delivery requires auto_install"},
+
+		{"object storage with two kinds of credentials", func(p *RequestPayload) {
+			p.ObjectStorage = &ObjectStorage{CredentialsSecret: "s3", AccessKeyID: "key"}
+		}, "object_storage takes either"},
+		{"vault without the vault backend", func(p *RequestPayload) { p.Vault = &VaultConfig{} }, "vault requires secret_backend"},
+		{"external_secret without its mode", func(p *RequestPayload) { p.ExternalSecret = &ExternalSecretConfig{} }, "external_secret requires secret_mode"},
+		{"external-secret mode with vault", func(p *RequestPayload) {
+			p.SecretMode = secretModeExternalSecret
+			p.SecretBackend = secretBackendVault
+		}, "cannot be combined with secret_backend"},
+		{"external-secret mode with external database", func(p *RequestPayload) {
+			p.SecretMode = secretModeExternalSecret
+			p.ExternalDatabase = &ExternalDatabase{}
+		}, "cannot be combined with external_database, whose credentials"},
+		{"external-secret mode with shared database", func(p *RequestPayload) {
+			p.SecretMode = secretModeExternalSecret
+			p.SharedDatabase = &SharedDatabase{}
+		}, "cannot be combined with shared_database, which generates"},
+		{"progress deadline within min_ready_seconds", func(p *RequestPayload) {
+			p.ProgressDeadline = &deadline
+			p.MinReadySeconds = 30
+		}, "must be greater than min_ready_seconds"},
+		{"service_account_rules alone", func(p *RequestPayload) {
+			p.ServiceAccountRules = []PolicyRule{{Resources: []string{"pods"}, Verbs: []string{"get"}}}
+		}, "service_account_rules requires service_account"},
+		{"save_manifest with manifest output", func(p *RequestPayload) {
+			p.SaveManifest = true
+			p.Output = outputManifest
+		}, "save_manifest cannot be combined"},
+		{"callback_url without async", func(p *RequestPayload) { p.CallbackURL = "https://example.com/hook" }, "callback_url requires async"},
+		{"async with manifest output", func(p *RequestPayload) {
+			p.Async = true
+			p.Output = outputManifest
+		}, "async cannot be combined"},
+		{"async with admin credentials in the response", func(p *RequestPayload) {
+			p.Async = true
+			p.AutoInstall = true
+			p.WPAdminEmail = "admin@example.com"
+			p.WPAdminDelivery = adminDeliveryResponse
+		}, `async requires wp_admin_delivery "claim"`},
+		{"auto_install without waiting", func(p *RequestPayload) {
+			p.AutoInstall = true
+			p.WaitForReady = &no
+		}, "auto_install cannot be combined with wait_for_ready false"},
+		{"verify_db_connection without waiting", func(p *RequestPayload) {
+			p.VerifyDBConnection = true
+			p.WaitForReady = &no
+		}, "verify_db_connection cannot be combined with wait_for_ready false"},
+		{"dns_policy None without nameservers", func(p *RequestPayload) { p.DNSPolicy = "None" }, "dns_policy None requires"},
+
+		{"wp_site_url without auto_install", func(p *RequestPayload) { p.WPSiteURL = "https://blog.example.com" }, "wp_site_url requires auto_install"},
+		{"gateway hostname without gateway", func(p *RequestPayload) {
+			p.Gateway = &GatewayConfig{Hostname: "blog.example.com"}
+		}, "gateway.hostname requires gateway.gateway_name"},
+		{"gateway namespace without gateway", func(p *RequestPayload) {
+			p.Gateway = &GatewayConfig{Namespace: "infra"}
+		}, "gateway.namespace requires gateway.gateway_name"},
+		{"site URL the route does not serve", func(p *RequestPayload) {
+			p.AutoInstall = true
+			p.WPAdminEmail = "admin@example.com"
+			p.WPSiteURL = "https://blog.example.com"
+			p.Gateway = &GatewayConfig{GatewayName: "public", Hostname: "*.example.org"}
+		}, "wp_site_url host blog.example.com is not served by gateway.hostname *.example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := RequestPayload{Namespace: "blog"}
+			tt.modify(&payload)
+			conflicts := validatePayload(payload)
+			if tt.want == "" {
+				if len(conflicts) > 0 {
+					t.Fatalf("validatePayload() = %q, want no conflicts", conflicts)
+				}
+				return
+			}
+			if len(conflicts) != 1 || !strings.Contains(conflicts[0], tt.want) {
+				t.Fatalf("validatePayload() = %q, want one conflict containing %q", conflicts, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePayloadListsEveryConflict(t *testing.T) {
+	payload := RequestPayload{
+		Namespace:       "blog",
+		SharedVolumeGB:  5,
+		CallbackURL:     "https://example.com/hook",
+		WaitForDBImage:  "busybox",
+		DBCACert:        "pem",
+		WPAdminPassword: "secret",
+	}
+	if conflicts := validatePayload(payload); len(conflicts) != 5 {
+		t.Fatalf("validatePayload() = %q, want 5 conflicts", conflicts)
+	}
+}
+
+func TestAsyncDeliversAdminCredentialsByClaim(t *testing.T) {
+	payload := RequestPayload{Namespace: "blog", Async: true, AutoInstall: true, WPAdminEmail: "admin@example.com"}
+	if conflicts := validatePayload(payload); len(conflicts) > 0 {
+		t.Fatalf("validatePayload() = %q, want no conflicts", conflicts)
+	}
+	if status, err := preparePayload(&payload); err != nil {
+		t.Fatalf("preparePayload() = %d, %v", status, err)
+	}
+	if payload.WPAdminDelivery != adminDeliveryClaim {
+		t.Errorf("wp_admin_delivery = %q, want %q for async deploys", payload.WPAdminDelivery, adminDeliveryClaim)
+	}
+}
+
+func TestMySQLVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"", true},
+		{"8", true},
+		{"8.0", true},
+		{"8.0.23", true},
+		{"8.0.36", true},
+		{"8.4", true},
+		{"9.1", true},
+		{"8.0.22", false},
+		{"5.7", false},
+		{"5.7.44", false},
+	}
+	for _, tt := range tests {
+		if got := mysqlVersionAtLeast(tt.version, minReplicaMySQLVersion); got != tt.want {
+			t.Errorf("mysqlVersionAtLeast(%q, %q) = %v, want %v", tt.version, minReplicaMySQLVersion, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaForUnmappedTypeAcceptsAnything(t *testing.T) {
+	type payload struct {
+		Ratio float64 `json:"ratio"`
+		Name  string  `json:"name"`
+	}
+	s := schemaForType(reflect.TypeOf(payload{}), "")
+	if errs := validateAgainstSchema(s, map[string]any{"ratio": json.Number("0.5"), "name": "wp"}, ""); len(errs) > 0 {
+		t.Errorf("validateAgainstSchema() = %v, want no errors", errs)
+	}
+	if errs := validateAgainstSchema(s, map[string]any{"name": true}, ""); len(errs) != 1 {
+		t.Errorf("validateAgainstSchema() = %v, want the mapped field still checked", errs)
+	}
+}
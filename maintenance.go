@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maintenanceReplicasAnnotation records on a WordPress deployment in maintenance how many
+// replicas it had, so leaving maintenance restores exactly that many.
+const maintenanceReplicasAnnotation = "my-wordpress-deployer/maintenance-replicas"
+
+// MaintenanceRequest is the body of /maintenance: the stack, and whether to take it offline.
+type MaintenanceRequest struct {
+	Kubeconfig     string `json:"kubeconfig,omitempty"`
+	KubeContext    string `json:"context,omitempty"`
+	Namespace      string `json:"namespace"`
+	DeploymentName string `json:"deployment_name,omitempty"` // Defaults to "wp"
+	Suffix         string `json:"suffix"`
+	NameTemplate   string `json:"name_template,omitempty"` // The stack's name_template, if it was created with one
+	Enabled        bool   `json:"enabled"`                 // true scales WordPress to zero; false restores it
+}
+
+// MaintenanceResult reports the WordPress deployments of a stack a maintenance request scaled.
+type MaintenanceResult struct {
+	Enabled     bool                    `json:"enabled"`
+	Deployments []DeploymentMaintenance `json:"deployments"`
+}
+
+// DeploymentMaintenance is one deployment's replica count before and after the request.
+type DeploymentMaintenance struct {
+	Name             string `json:"name"`
+	Replicas         int32  `json:"replicas"`
+	PreviousReplicas int32  `json:"previous_replicas"`
+}
+
+// errMaintenanceState means the stack is already in, or already out of, maintenance.
+var errMaintenanceState = errors.New("maintenance state unchanged")
+
+// handleMaintenance takes a stack's WordPress offline by scaling its deployments to zero, or
+// brings it back with the replica counts it had. The Service stays, so clients get connection
+// errors from it rather than DNS failures. MySQL keeps running for the maintenance work.
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var req MaintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] Failed to decode request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Invalid JSON payload",
+		})
+		return
+	}
+	tmpl, err := nameTemplateParam(req.NameTemplate)
+	if err == nil {
+		err = validateMaintenanceRequest(&req)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	clientSet, err := InitKubeClient(req.Kubeconfig, req.KubeContext)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	names := newTemplatedStackNames(tmpl, req.Namespace, req.DeploymentName, req.Suffix)
+	result, err := setMaintenance(r.Context(), clientSet, req.Namespace, names, req.Enabled)
+	switch {
+	case apierrors.IsNotFound(err):
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("WordPress deployment %s not found in namespace %s", names.WPDeployment, req.Namespace),
+		})
+		return
+	case errors.Is(err, errMaintenanceState):
+		w.WriteHeader(http.StatusConflict)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		log.Printf("[ERROR] Failed to change maintenance of stack %s: %v", names.ID(), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to change maintenance of stack %s: %v", names.ID(), err),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("Stack %s is in maintenance: WordPress is scaled to zero", names.ID())
+	if !req.Enabled {
+		message = fmt.Sprintf("Stack %s is out of maintenance: WordPress replicas are restored", names.ID())
+	}
+	respondJSON(w, APIResponse{
+		Success:     true,
+		Message:     message,
+		Maintenance: result,
+	})
+}
+
+// validateMaintenanceRequest checks the request and fills in the default deployment name.
+func validateMaintenanceRequest(req *MaintenanceRequest) error {
+	if req.Namespace == "" {
+		return errors.New("namespace is required")
+	}
+	if !suffixPattern.MatchString(req.Suffix) {
+		return errors.New("suffix must be the suffix returned when the stack was created")
+	}
+	if req.DeploymentName == "" {
+		req.DeploymentName = "wp"
+	}
+	return nil
+}
+
+// setMaintenance scales the stack's WordPress deployment, and its canary if there is one, to
+// zero or back through the scale subresource. The previous replica count is annotated on each
+// deployment before scaling down, so a failure halfway still leaves it recoverable.
+func setMaintenance(ctx context.Context, clientSet *kubernetes.Clientset, namespace string,
+	names stackNames, enabled bool) (*MaintenanceResult, error) {
+
+	deployments := clientSet.AppsV1().Deployments(namespace)
+	result := &MaintenanceResult{Enabled: enabled}
+	for _, name := range []string{names.WPDeployment, names.WPCanaryDeployment} {
+		deployment, err := deployments.Get(ctx, name, metaV1.GetOptions{})
+		if apierrors.IsNotFound(err) && name == names.WPCanaryDeployment {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scale, err := deployments.GetScale(ctx, name, metaV1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the scale of %s: %w", name, err)
+		}
+
+		// Deployments already in the requested state are left alone, so a request that failed
+		// halfway can simply be repeated.
+		saved, inMaintenance := deployment.Annotations[maintenanceReplicasAnnotation]
+		if inMaintenance == enabled {
+			continue
+		}
+
+		status := DeploymentMaintenance{Name: name, PreviousReplicas: scale.Spec.Replicas}
+		if enabled {
+			err = annotateMaintenance(ctx, clientSet, namespace, name, strconv.Itoa(int(scale.Spec.Replicas)))
+			if err == nil {
+				err = scaleDeployment(ctx, clientSet, namespace, scale, 0)
+			}
+		} else {
+			replicas, convErr := strconv.ParseInt(saved, 10, 32)
+			if convErr != nil || replicas < 0 {
+				replicas = 1
+				log.Printf("[WARN] Deployment %s has an invalid %s annotation %q; restoring 1 replica",
+					name, maintenanceReplicasAnnotation, saved)
+			}
+			status.Replicas = int32(replicas)
+			err = scaleDeployment(ctx, clientSet, namespace, scale, status.Replicas)
+			if err == nil {
+				err = annotateMaintenance(ctx, clientSet, namespace, name, "")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Scaled deployment %s/%s from %d to %d replicas for maintenance", namespace, name,
+			status.PreviousReplicas, status.Replicas)
+		result.Deployments = append(result.Deployments, status)
+	}
+	if len(result.Deployments) == 0 {
+		state := "already"
+		if !enabled {
+			state = "not"
+		}
+		return nil, fmt.Errorf("%w: stack %s is %s in maintenance", errMaintenanceState, names.ID(), state)
+	}
+	return result, nil
+}
+
+// annotateMaintenance records the replicas to restore on a deployment, or removes the record
+// when replicas is empty.
+func annotateMaintenance(ctx context.Context, clientSet *kubernetes.Clientset, namespace, name, replicas string) error {
+	var value any
+	if replicas != "" {
+		value = replicas
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{maintenanceReplicasAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, patch, metaV1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to annotate deployment %s: %w", name, err)
+	}
+	return nil
+}
+
+// scaleDeployment sets the replicas of a deployment through the scale subresource.
+func scaleDeployment(ctx context.Context, clientSet *kubernetes.Clientset, namespace string,
+	scale *autoscalingv1.Scale, replicas int32) error {
+
+	scale.Spec.Replicas = replicas
+	_, err := clientSet.AppsV1().Deployments(namespace).UpdateScale(ctx, scale.Name, scale, metaV1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to scale deployment %s: %w", scale.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the ID of a request: the caller's, when it sends one, or a generated one.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds caller-supplied request IDs; longer ones are replaced.
+const maxRequestIDLength = 128
+
+// requestIDKey holds the request ID in the request context.
+type requestIDKey struct{}
+
+// requestID returns the ID accessLogMiddleware gave the request of ctx, or "" outside a request.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// statusWriter remembers the status code and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+// accessLogMiddleware logs one key=value line per request with its method, path, status,
+// duration, response size and request ID. The ID is echoed in the X-Request-ID response
+// header and kept in the request context, so a client can quote it when reporting a failure.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength || strings.ContainsAny(id, " \t\r\n\"") {
+			id, _ = generateRandomSuffix(16)
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		log.Printf("[INFO] request method=%s path=%q status=%d duration_ms=%d bytes=%d request_id=%s",
+			r.Method, r.URL.Path, sw.status, time.Since(start).Milliseconds(), sw.bytes, id)
+	})
+}
+
+// parseAllowedOrigins splits a comma-separated origin list (e.g. from CORS_ALLOWED_ORIGINS),
+// dropping blanks and trailing slashes. An empty result means CORS is disabled.
+func parseAllowedOrigins(raw string) []string {
+	var origins []string
+	for _, o := range strings.Split(raw, ",") {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		if o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// corsMiddleware adds Access-Control-Allow-* headers for requests from an allowed origin
+// and answers OPTIONS preflights itself. With no allowed origins it passes requests through untouched.
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	if len(allowedOrigins) == 0 {
+		return next
+	}
+
+	allowAll := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			allowAll = true
+		}
+		allowed[o] = true
+	}
+
+	methods := map[string]bool{http.MethodOptions: true}
+	allowMethods := []string{http.MethodOptions}
+	for _, rt := range routes {
+		if !methods[rt.Method] {
+			methods[rt.Method] = true
+			allowMethods = append(allowMethods, rt.Method)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!allowAll && !allowed[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", requestIDHeader)
+
+		// Preflight: answer directly so the method check never sees OPTIONS.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", strings.Join(allowMethods, ", "))
+			h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+idempotencyHeader+", "+requestIDHeader)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// mysqlConfigPath is where mysql_config is mounted; the official images include every *.cnf
+// file of /etc/mysql/conf.d after their own settings, so it can override them.
+const mysqlConfigPath = "/etc/mysql/conf.d/custom.cnf"
+
+// mysqlConfigKey is the ConfigMap key holding mysql_config.
+const mysqlConfigKey = "custom.cnf"
+
+// buildMySQLConfigMap returns the ConfigMap holding the request's my.cnf snippet.
+func buildMySQLConfigMap(payload RequestPayload, names stackNames) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.DBConfig,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.DBConfig, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
+		},
+		Data: map[string]string{
+			mysqlConfigKey: payload.MySQLConfig,
+		},
+	}
+}
+
+// createMySQLConfigMap creates the ConfigMap described by buildMySQLConfigMap.
+func createMySQLConfigMap(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	cm := buildMySQLConfigMap(payload, names)
+	_, err := clientSet.CoreV1().ConfigMaps(payload.Namespace).Create(ctx, cm, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create configmap %s: %w", names.DBConfig, err)
+	}
+	return nil
+}
+
+// mountMySQLConfig mounts the mysql_config ConfigMap into the MySQL container of spec. Only the
+// one file is mounted, so the image's own files in the directory stay visible.
+func mountMySQLConfig(spec *corev1.PodSpec, payload RequestPayload, names stackNames) {
+	if payload.MySQLConfig == "" {
+		return
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "mysql-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: names.DBConfig},
+			},
+		},
+	})
+	spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "mysql-config",
+		MountPath: mysqlConfigPath,
+		SubPath:   mysqlConfigKey,
+		ReadOnly:  true,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NamespaceSummary describes one namespace managed by the deployer.
+type NamespaceSummary struct {
+	Name   string   `json:"name"`
+	Stacks int      `json:"stacks"`              // Number of WordPress stacks found in the namespace
+	IDs    []string `json:"stack_ids,omitempty"` // Their instance label values
+}
+
+// handleListNamespaces lists every namespace carrying the managed-by label with a count of its stacks.
+// The optional "kubeconfig" query parameter selects the cluster, as in the create request.
+func handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	clientSet, err := InitKubeClient(r.URL.Query().Get("kubeconfig"), r.URL.Query().Get("context"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	summaries, err := listManagedNamespaces(r.Context(), clientSet)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list managed namespaces: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Found %d managed namespace(s)", len(summaries)),
+		Namespaces: summaries,
+	})
+}
+
+// listManagedNamespaces returns the managed namespaces, each with the distinct stacks
+// identified by the instance label on its WordPress deployments.
+func listManagedNamespaces(ctx context.Context, clientSet *kubernetes.Clientset) ([]NamespaceSummary, error) {
+	managed := labels.Set{managedByLabel: managedByValue}.String()
+	nsList, err := clientSet.CoreV1().Namespaces().List(ctx, metaV1.ListOptions{LabelSelector: managed})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %w", err)
+	}
+
+	wpSelector := labels.Set{managedByLabel: managedByValue, componentLabel: componentWordPress}.String()
+	summaries := make([]NamespaceSummary, 0, len(nsList.Items))
+	for _, ns := range nsList.Items {
+		deployments, err := clientSet.AppsV1().Deployments(ns.Name).List(ctx, metaV1.ListOptions{LabelSelector: wpSelector})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list deployments in %s: %w", ns.Name, err)
+		}
+
+		seen := map[string]bool{}
+		var ids []string
+		for _, d := range deployments.Items {
+			id := d.Labels[stackLabel]
+			if id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		summaries = append(summaries, NamespaceSummary{Name: ns.Name, Stacks: len(ids), IDs: ids})
+	}
+	return summaries, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// maxResourceNameLength bounds every resource name, leaving room below the 63 characters of
+// a DNS-1123 label for the suffixes Kubernetes appends to generated pod and Job names.
+const maxResourceNameLength = 60
+
+// nameTemplateVars are the variables a name_template can use, e.g.
+// "{{.Type}}-{{.Prefix}}-{{.Suffix}}" or "prod-{{.Prefix}}-{{.Suffix}}-{{.Type}}".
+type nameTemplateVars struct {
+	Prefix    string // deployment_name
+	Suffix    string // The random suffix; may be empty with suffix_length 0
+	Type      string // Short resource type such as "db-pv" or "wp-svc"
+	Namespace string
+}
+
+// parseNameTemplate parses a name_template and checks that it renders, that every resource
+// of a stack gets its own name and, with needsSuffix, that stacks differ by suffix.
+func parseNameTemplate(text string, needsSuffix bool) (*template.Template, error) {
+	tmpl, err := template.New("name_template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	render := func(suffix, resourceType string) (string, error) {
+		return executeNameTemplate(tmpl, nameTemplateVars{Prefix: "wp", Suffix: suffix, Type: resourceType, Namespace: "default"})
+	}
+	a, err := render("0", "db")
+	if err != nil {
+		return nil, err
+	}
+	if b, _ := render("0", "wp"); a == b {
+		return nil, errors.New("it must use {{.Type}}, or every resource gets the same name")
+	}
+	if b, _ := render("1", "db"); needsSuffix && a == b {
+		return nil, errors.New("it must use {{.Suffix}}, or stacks with the same deployment_name collide")
+	}
+	return tmpl, nil
+}
+
+// executeNameTemplate renders one resource name.
+func executeNameTemplate(tmpl *template.Template, vars nameTemplateVars) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderResourceName names a resource from the name_template, or with buildResourceName when
+// there is none. Like buildResourceName it shortens the prefix to keep the name within
+// maxResourceNameLength; names that are still too long or invalid fail stackNames.validate.
+func renderResourceName(tmpl *template.Template, namespace, prefix, resourceType, suffix string) string {
+	if tmpl == nil {
+		return buildResourceName(prefix, resourceType, suffix)
+	}
+	vars := nameTemplateVars{Prefix: prefix, Suffix: suffix, Type: resourceType, Namespace: namespace}
+	name, err := executeNameTemplate(tmpl, vars)
+	if err != nil {
+		return ""
+	}
+	if over := len(name) - maxResourceNameLength; over > 0 && over < len(prefix) {
+		vars.Prefix = prefix[:len(prefix)-over]
+		if shortened, err := executeNameTemplate(tmpl, vars); err == nil {
+			name = shortened
+		}
+	}
+	return name
+}
+
+// stackNamesFor derives the stack's resource names for a create request, rendering its
+// name_template when one was given.
+func stackNamesFor(payload RequestPayload, suffix string) stackNames {
+	names := newStackNames(payload.DeploymentName, suffix)
+	if payload.nameTemplate == nil {
+		return names
+	}
+	namespace := payload.Namespace
+	if payload.NamespacePerDeployment {
+		namespace = names.ID()
+	}
+	return newTemplatedStackNames(payload.nameTemplate, namespace, payload.DeploymentName, suffix)
+}
+
+// nameTemplateParam parses the optional name_template of a request that identifies an
+// existing stack, which must be the one the stack was created with.
+func nameTemplateParam(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := parseNameTemplate(text, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name_template: %w", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// objectStoragePlugin is the wordpress.org slug of WP Offload Media Lite, which copies uploads to
+// S3 and rewrites their URLs. It is configured entirely through the AS3CF_SETTINGS constant.
+const objectStoragePlugin = "amazon-s3-and-cloudfront"
+
+// objectStorageConfig defines AS3CF_SETTINGS in wp-config.php, reading the values from the
+// environment so the keys never appear in the Deployment spec.
+const objectStorageConfig = `define('AS3CF_SETTINGS', serialize(array(
+  'provider' => 'aws',
+  'access-key-id' => getenv('AWS_ACCESS_KEY_ID'),
+  'secret-access-key' => getenv('AWS_SECRET_ACCESS_KEY'),
+  'bucket' => getenv('S3_UPLOADS_BUCKET'),
+  'region' => getenv('S3_UPLOADS_REGION'),
+  'copy-to-s3' => true,
+  'serve-from-s3' => true,
+  'remove-local-file' => true,
+)));`
+
+// ObjectStorage offloads the media library to an S3 bucket, so uploads don't live on the PVC.
+// The keys are stored in their own Secret; CredentialsSecret names an existing one instead,
+// holding AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type ObjectStorage struct {
+	Bucket            string `json:"bucket"`
+	Region            string `json:"region"`
+	AccessKeyID       string `json:"access_key_id,omitempty"`
+	SecretAccessKey   string `json:"secret_access_key,omitempty"`
+	CredentialsSecret string `json:"credentials_secret,omitempty"`
+}
+
+// objectStorageSecretName returns the Secret holding the bucket credentials.
+func objectStorageSecretName(payload RequestPayload, names stackNames) string {
+	if payload.ObjectStorage.CredentialsSecret != "" {
+		return payload.ObjectStorage.CredentialsSecret
+	}
+	return names.WPObjectStorageSecret
+}
+
+// objectStorageEnv returns the variables the offload plugin's settings are read from,
+// for the WordPress pods and the wp-cli Jobs alike.
+func objectStorageEnv(payload RequestPayload, names stackNames) []corev1.EnvVar {
+	secretKey := func(key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: objectStorageSecretName(payload, names)},
+					Key:                  key,
+				},
+			},
+		}
+	}
+	return []corev1.EnvVar{
+		secretKey("AWS_ACCESS_KEY_ID"),
+		secretKey("AWS_SECRET_ACCESS_KEY"),
+		{Name: "S3_UPLOADS_BUCKET", Value: payload.ObjectStorage.Bucket},
+		{Name: "S3_UPLOADS_REGION", Value: payload.ObjectStorage.Region},
+	}
+}
+
+// buildObjectStorageSecret returns the Secret holding the bucket credentials, kept apart from
+// the database Secret so either can be rotated or shared on its own.
+func buildObjectStorageSecret(payload RequestPayload, names stackNames) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.WPObjectStorageSecret,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.WPObjectStorageSecret, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"AWS_ACCESS_KEY_ID":     []byte(payload.ObjectStorage.AccessKeyID),
+			"AWS_SECRET_ACCESS_KEY": []byte(payload.ObjectStorage.SecretAccessKey),
+		},
+	}
+}
+
+// createObjectStorageSecret creates the bucket credentials Secret.
+func createObjectStorageSecret(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	secret := buildObjectStorageSecret(payload, names)
+	_, err := clientSet.CoreV1().Secrets(payload.Namespace).Create(ctx, secret, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create secret %s: %w", names.WPObjectStorageSecret, err)
+	}
+	return nil
+}
+
+// ownsObjectStorageSecret reports whether the deployer creates the credentials Secret itself.
+func ownsObjectStorageSecret(payload RequestPayload) bool {
+	return payload.ObjectStorage != nil && payload.ObjectStorage.CredentialsSecret == ""
+}
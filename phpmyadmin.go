@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultPhpMyAdminImage is the official phpMyAdmin image, served by Apache on port 80.
+const defaultPhpMyAdminImage = "phpmyadmin:5.2"
+
+// buildPhpMyAdminDeployment returns a single-replica phpMyAdmin for the stack's database. It
+// shows its own login form rather than being logged in already, so reaching the Service is not
+// enough to read the database. Its Service is ClusterIP only: reach it with port-forward.
+func buildPhpMyAdminDeployment(payload RequestPayload, names stackNames) *appsv1.Deployment {
+	namespace, deployName := payload.Namespace, names.PMADeployment
+	env := []corev1.EnvVar{
+		{Name: "PMA_HOST", Value: names.DBService},
+	}
+	if ext := payload.ExternalDatabase; ext != nil {
+		env[0].Value = ext.Host
+		env = append(env, corev1.EnvVar{Name: "PMA_PORT", Value: strconv.Itoa(ext.Port)})
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        deployName,
+			Namespace:   namespace,
+			Labels:      stackLabels(deployName, names, componentDatabase),
+			Annotations: deploymentAnnotations(payload),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:             int32Ptr(1),
+			RevisionHistoryLimit: payload.RevisionHistoryLimit,
+			Selector: &metaV1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": deployName,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      stackLabels(deployName, names, componentDatabase),
+					Annotations: podAnnotations(payload),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadDeployment),
+					Containers: []corev1.Container{
+						{
+							Name:            "phpmyadmin",
+							Image:           defaultPhpMyAdminImage,
+							ImagePullPolicy: imagePullPolicy(payload, defaultPhpMyAdminImage),
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: 80,
+									Name:          "http",
+								},
+							},
+							Env: env,
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									TCPSocket: &corev1.TCPSocketAction{
+										Port: intstr.FromString("http"),
+									},
+								},
+								InitialDelaySeconds: 5,
+								PeriodSeconds:       10,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	applyDNS(&deployment.Spec.Template.Spec, payload)
+	applyServiceAccount(&deployment.Spec.Template.Spec, payload, names)
+	return deployment
+}
+
+// createPhpMyAdminDeployment creates the Deployment described by buildPhpMyAdminDeployment.
+func createPhpMyAdminDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	deployment := buildPhpMyAdminDeployment(payload, names)
+	_, err := clientSet.AppsV1().Deployments(payload.Namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create phpMyAdmin deployment %s: %w", names.PMADeployment, err)
+	}
+	return nil
+}
+
+// buildPhpMyAdminService returns a ClusterIP service for phpMyAdmin on port 80.
+func buildPhpMyAdminService(payload RequestPayload, names stackNames) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.PMAService,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.PMADeployment, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": names.PMADeployment,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       80,
+					TargetPort: intstr.FromString("http"),
+				},
+			},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+// createPhpMyAdminService creates the Service described by buildPhpMyAdminService.
+func createPhpMyAdminService(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	service := buildPhpMyAdminService(payload, names)
+	_, err := clientSet.CoreV1().Services(payload.Namespace).Create(ctx, service, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create phpMyAdmin service %s: %w", names.PMAService, err)
+	}
+	return nil
+}
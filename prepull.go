@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// prepullTimeout bounds the wait for every node to pull the stack's images. Slow first pulls
+// are what prepull_images is for, so it is more generous than the image pull check.
+const prepullTimeout = 10 * time.Minute
+
+// buildPrepullDaemonSet returns a DaemonSet whose pods have one container per stack image, each
+// running only `true`, so that every node the stack may be scheduled on pulls the images. The
+// containers exit at once and are restarted until the DaemonSet is deleted; a container whose
+// image lacks `true` fails to start, which still leaves the image pulled.
+func buildPrepullDaemonSet(payload RequestPayload, names stackNames, images []string) *appsv1.DaemonSet {
+	podLabels := stackLabels(names.PrepullDaemonSet, names, componentWordPress)
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.PrepullDaemonSet,
+			Namespace:   payload.Namespace,
+			Labels:      podLabels,
+			Annotations: stackAnnotations(payload),
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metaV1.LabelSelector{
+				MatchLabels: map[string]string{"app": names.PrepullDaemonSet},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      podLabels,
+					Annotations: podAnnotations(payload),
+				},
+				Spec: corev1.PodSpec{
+					TerminationGracePeriodSeconds: int64Ptr(0),
+				},
+			},
+		},
+	}
+	for i, image := range images {
+		// Pulling is the point, so Never, meant for the stack's own pods, pulls if needed here.
+		policy := imagePullPolicy(payload, image)
+		if policy == corev1.PullNever {
+			policy = corev1.PullIfNotPresent
+		}
+		ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: policy,
+			Command:         []string{"true"},
+		})
+	}
+	return ds
+}
+
+// imagePulled reports whether a container's image is on its node: the container ran, or the
+// kubelet recorded the image it resolved.
+func imagePulled(status corev1.ContainerStatus) bool {
+	return status.ImageID != "" || status.State.Running != nil || status.State.Terminated != nil ||
+		status.LastTerminationState.Terminated != nil
+}
+
+// prepullImages runs the prepull DaemonSet until every node it is scheduled on has pulled or
+// failed to pull each image, deleting it afterwards. Failed or unfinished pulls only yield a
+// warning: the deployments pull the images themselves anyway, and image_pull_check is the
+// option that rejects unpullable ones.
+func prepullImages(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) (string, error) {
+
+	images := uniqueStrings(stackImages(payload))
+	daemonSets := clientSet.AppsV1().DaemonSets(payload.Namespace)
+	ds := buildPrepullDaemonSet(payload, names, images)
+	if _, err := daemonSets.Create(ctx, ds, metaV1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("unable to create prepull daemonset %s: %w", ds.Name, err)
+	}
+	defer func() {
+		// Best effort on a fresh context, since ctx may be what ended the wait.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		background := metaV1.DeletePropagationBackground
+		err := daemonSets.Delete(cleanupCtx, ds.Name, metaV1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil {
+			log.Printf("[WARN] Failed to delete prepull daemonset %s: %v", ds.Name, err)
+		}
+	}()
+
+	log.Printf("[INFO] Pre-pulling images onto the nodes: %s", strings.Join(images, ", "))
+	selector := fmt.Sprintf("app=%s", names.PrepullDaemonSet)
+	var failures []string
+	var nodes int32
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, prepullTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := daemonSets.Get(ctx, ds.Name, metaV1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		nodes = current.Status.DesiredNumberScheduled
+		if current.Status.ObservedGeneration < current.Generation || nodes == 0 {
+			return false, nil
+		}
+		pods, err := clientSet.CoreV1().Pods(payload.Namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+
+		failures = nil
+		done := 0
+		for _, pod := range pods.Items {
+			resolved := 0
+			for _, status := range pod.Status.ContainerStatuses {
+				switch {
+				case imagePulled(status):
+					resolved++
+				case status.State.Waiting != nil && imagePullFailures[status.State.Waiting.Reason]:
+					resolved++
+					failures = append(failures, fmt.Sprintf("%s on %s: %s", status.Image, pod.Spec.NodeName, status.State.Waiting.Message))
+				}
+			}
+			if resolved == len(images) {
+				done++
+			}
+		}
+		debugf(ctx, "Prepull daemonset %s: %d/%d nodes done", ds.Name, done, nodes)
+		return done >= int(nodes), nil
+	})
+	if isContextError(ctx.Err()) {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return fmt.Sprintf("images were not pre-pulled onto every node within %s: %v", prepullTimeout, err), nil
+	}
+	if len(failures) > 0 {
+		return "some images could not be pre-pulled: " + strings.Join(failures, "; "), nil
+	}
+	log.Printf("[INFO] Images pre-pulled onto %d nodes.", nodes)
+	return "", nil
+}
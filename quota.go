@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Names of the quota objects created in a new namespace.
+const (
+	namespaceQuotaName      = "wp-quota"
+	namespaceLimitRangeName = "wp-limits"
+)
+
+// Requests given to containers that declare none, once a CPU or memory quota makes them mandatory.
+const (
+	defaultContainerCPURequest    = "100m"
+	defaultContainerMemoryRequest = "128Mi"
+)
+
+// NamespaceQuota caps the total consumption of a namespace. Empty fields are not limited.
+type NamespaceQuota struct {
+	CPU     string `json:"cpu,omitempty"`     // Total CPU requests, e.g. "2"
+	Memory  string `json:"memory,omitempty"`  // Total memory requests, e.g. "4Gi"
+	Storage string `json:"storage,omitempty"` // Total PVC storage requests, e.g. "50Gi"
+	Pods    int32  `json:"pods,omitempty"`
+}
+
+// buildNamespaceQuota returns the ResourceQuota and, when CPU or memory is capped, a LimitRange.
+// Kubernetes rejects pods without requests for a quota'd resource, and the WordPress and wp-cli
+// containers declare none, so the LimitRange fills in defaults for them.
+func buildNamespaceQuota(namespace string, q NamespaceQuota) (*corev1.ResourceQuota, *corev1.LimitRange, error) {
+	hard := corev1.ResourceList{}
+	for _, f := range []struct {
+		name  corev1.ResourceName
+		value string
+	}{
+		{corev1.ResourceRequestsCPU, q.CPU},
+		{corev1.ResourceRequestsMemory, q.Memory},
+		{corev1.ResourceRequestsStorage, q.Storage},
+	} {
+		if f.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(f.value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		hard[f.name] = quantity
+	}
+	if q.Pods < 0 {
+		return nil, nil, errors.New("pods must not be negative")
+	}
+	if q.Pods > 0 {
+		hard[corev1.ResourcePods] = *resource.NewQuantity(int64(q.Pods), resource.DecimalSI)
+	}
+	if len(hard) == 0 {
+		return nil, nil, errors.New("set at least one of cpu, memory, storage or pods")
+	}
+
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      namespaceQuotaName,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedByValue},
+		},
+		Spec: corev1.ResourceQuotaSpec{Hard: hard},
+	}
+	if q.CPU == "" && q.Memory == "" {
+		return quota, nil, nil
+	}
+
+	limits := &corev1.LimitRange{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      namespaceLimitRangeName,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedByValue},
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type: corev1.LimitTypeContainer,
+					DefaultRequest: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse(defaultContainerCPURequest),
+						corev1.ResourceMemory: resource.MustParse(defaultContainerMemoryRequest),
+					},
+				},
+			},
+		},
+	}
+	return quota, limits, nil
+}
+
+// createNamespaceQuota creates the objects described by buildNamespaceQuota.
+func createNamespaceQuota(ctx context.Context, clientSet *kubernetes.Clientset, namespace string, q NamespaceQuota) error {
+	quota, limits, err := buildNamespaceQuota(namespace, q)
+	if err != nil {
+		return err
+	}
+	if limits != nil {
+		_, err = clientSet.CoreV1().LimitRanges(namespace).Create(ctx, limits, metaV1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to create limit range %s: %w", namespaceLimitRangeName, err)
+		}
+	}
+	_, err = clientSet.CoreV1().ResourceQuotas(namespace).Create(ctx, quota, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create resource quota %s: %w", namespaceQuotaName, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdle is how long a client's bucket is kept after its last request.
+const rateLimiterIdle = 10 * time.Minute
+
+// parseRateLimit reads RATE_LIMIT_RPS and RATE_LIMIT_BURST. An empty or zero rate disables
+// limiting; the burst defaults to the rate rounded up, and at least 1.
+func parseRateLimit(rps, burst string) (rate.Limit, int, error) {
+	if rps == "" {
+		return 0, 0, nil
+	}
+	limit, err := strconv.ParseFloat(rps, 64)
+	if err != nil || limit < 0 || math.IsInf(limit, 0) || math.IsNaN(limit) {
+		return 0, 0, fmt.Errorf("RATE_LIMIT_RPS must be a non-negative number, got %q", rps)
+	}
+	if burst == "" {
+		return rate.Limit(limit), max(1, int(math.Ceil(limit))), nil
+	}
+	b, err := strconv.Atoi(burst)
+	if err != nil || b < 1 {
+		return 0, 0, fmt.Errorf("RATE_LIMIT_BURST must be a positive integer, got %q", burst)
+	}
+	return rate.Limit(limit), b, nil
+}
+
+// clientLimiter is the token bucket of one client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimitMiddleware gives every client IP a token bucket of limit requests per second with
+// room for burst, and answers 429 with Retry-After once it is empty. The IP is that of the
+// connection, so clients behind a shared proxy share a bucket. A zero limit disables it.
+func rateLimitMiddleware(limit rate.Limit, burst int, next http.Handler) http.Handler {
+	if limit == 0 {
+		return next
+	}
+
+	var mu sync.Mutex
+	clients := map[string]*clientLimiter{}
+	lastPrune := time.Now()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		now := time.Now()
+		mu.Lock()
+		if now.Sub(lastPrune) > rateLimiterIdle {
+			for key, c := range clients {
+				if now.Sub(c.lastSeen) > rateLimiterIdle {
+					delete(clients, key)
+				}
+			}
+			lastPrune = now
+		}
+		c, ok := clients[ip]
+		if !ok {
+			c = &clientLimiter{limiter: rate.NewLimiter(limit, burst)}
+			clients[ip] = c
+		}
+		c.lastSeen = now
+		reservation := c.limiter.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			// Don't spend the token on a request that is turned away.
+			reservation.CancelAt(now)
+		}
+		mu.Unlock()
+
+		if delay > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			respondJSON(w, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Rate limit exceeded; retry in %s", delay.Round(time.Second)),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
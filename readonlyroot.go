@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// readOnlyRootWritablePaths are the directories the official WordPress images write to outside
+// the WordPress volume: /tmp, which is also PHP's session directory since the images leave
+// session.save_path unset, and Apache's pid and lock directories. Each gets an emptyDir with
+// read_only_root_filesystem.
+var readOnlyRootWritablePaths = []corev1.VolumeMount{
+	{Name: "wordpress-tmp", MountPath: "/tmp"},
+	{Name: "wordpress-apache-run", MountPath: "/var/run/apache2"},
+	{Name: "wordpress-apache-lock", MountPath: "/var/lock/apache2"},
+}
+
+// readOnlyRootImageRepository is the image the writable paths above were taken from.
+const readOnlyRootImageRepository = "wordpress"
+
+// applyReadOnlyRootFilesystem makes the root filesystem of the WordPress container, the first
+// one of spec, read-only, and mounts an emptyDir over every path it still has to write to.
+func applyReadOnlyRootFilesystem(spec *corev1.PodSpec, payload RequestPayload) {
+	if !payload.ReadOnlyRootFilesystem {
+		return
+	}
+	container := &spec.Containers[0]
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	readOnly := true
+	container.SecurityContext.ReadOnlyRootFilesystem = &readOnly
+	for _, mount := range readOnlyRootWritablePaths {
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name:         mount.Name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+}
+
+// readOnlyRootWarning describes the WordPress images a read-only root filesystem was not
+// verified with: images other than the official one may write elsewhere and fail to start.
+func readOnlyRootWarning(payload RequestPayload) string {
+	if !payload.ReadOnlyRootFilesystem {
+		return ""
+	}
+	images := []string{payload.WordPressImage}
+	if payload.Canary != nil {
+		images = append(images, payload.Canary.Image)
+	}
+	var unverified []string
+	for _, image := range uniqueStrings(images) {
+		if path.Base(imageRepository(image)) != readOnlyRootImageRepository {
+			unverified = append(unverified, image)
+		}
+	}
+	if len(unverified) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("read_only_root_filesystem was only verified with the official %s image, not %s; "+
+		"pods fail to start if the image writes outside %s and the mounted emptyDirs",
+		readOnlyRootImageRepository, strings.Join(unverified, ", "), payload.WordPressDataPath)
+}
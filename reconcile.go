@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ReconcileRequest repeats the original create payload along with the suffix of the stack to repair.
+type ReconcileRequest struct {
+	RequestPayload
+	Suffix string `json:"suffix"`
+}
+
+// suffixPattern matches the suffixes generated by generateRandomSuffix, including the empty
+// suffix of stacks deployed with suffix_length 0.
+var suffixPattern = regexp.MustCompile(`^[a-z0-9]{0,16}$`)
+
+// reconcileStep is one resource of the stack: how to tell whether it exists and how to create it.
+type reconcileStep struct {
+	Kind   string
+	Name   string
+	Get    func(ctx context.Context) error // NotFound when the resource is missing
+	Create func(ctx context.Context) error
+}
+
+// reconcileSteps lists the stack's resources in creation order, following the same options as
+// deployStack. Jobs are left out: they ran once, and rerunning an install is not a repair.
+func reconcileSteps(clientSet *kubernetes.Clientset, payload RequestPayload, names stackNames) []reconcileStep {
+	ns := payload.Namespace
+	core, apps := clientSet.CoreV1(), clientSet.AppsV1()
+	get := metaV1.GetOptions{}
+
+	pvStep := func(component string) reconcileStep {
+		pvName, _, sizeGB := stackVolume(payload, names, component)
+		return reconcileStep{
+			Kind: "PV", Name: pvName,
+			Get: func(ctx context.Context) error { _, err := core.PersistentVolumes().Get(ctx, pvName, get); return err },
+			Create: func(ctx context.Context) error {
+				_, err := createPersistentVolume(ctx, clientSet, ns, pvName, hostPathFor(ns, pvName), sizeGB,
+					stackLabels(pvName, names, component), payload.HostNode, corev1.HostPathType(payload.HostPathType), false)
+				return err
+			},
+		}
+	}
+	pvcStep := func(component string) reconcileStep {
+		pvName, pvcName, _ := stackVolume(payload, names, component)
+		return reconcileStep{
+			Kind: "PVC", Name: pvcName,
+			Get: func(ctx context.Context) error {
+				_, err := core.PersistentVolumeClaims(ns).Get(ctx, pvcName, get)
+				return err
+			},
+			Create: func(ctx context.Context) error {
+				pvc, err := buildStackPVC(payload, names, component)
+				if err != nil {
+					return err
+				}
+				_, err = createPersistentVolumeClaim(ctx, clientSet, pvc, pvName, false)
+				return err
+			},
+		}
+	}
+	deploymentStep := func(kind, name string, create func(context.Context, *kubernetes.Clientset, RequestPayload, stackNames) error) reconcileStep {
+		return reconcileStep{
+			Kind: kind, Name: name,
+			Get:    func(ctx context.Context) error { _, err := apps.Deployments(ns).Get(ctx, name, get); return err },
+			Create: func(ctx context.Context) error { return create(ctx, clientSet, payload, names) },
+		}
+	}
+	serviceStep := func(kind, name string, create func(context.Context, *kubernetes.Clientset, RequestPayload, stackNames) error) reconcileStep {
+		return reconcileStep{
+			Kind: kind, Name: name,
+			Get:    func(ctx context.Context) error { _, err := core.Services(ns).Get(ctx, name, get); return err },
+			Create: func(ctx context.Context) error { return create(ctx, clientSet, payload, names) },
+		}
+	}
+
+	var steps []reconcileStep
+	switch {
+	case payload.SharedVolume:
+		steps = append(steps, reconcileStep{
+			Kind: "Shared volume", Name: sharedPVCName,
+			Get: func(ctx context.Context) error {
+				_, err := core.PersistentVolumeClaims(ns).Get(ctx, sharedPVCName, get)
+				return err
+			},
+			Create: func(ctx context.Context) error { return ensureSharedVolume(ctx, clientSet, payload) },
+		})
+	default:
+		components := []string{componentDatabase, componentWordPress}
+		if payload.ExternalDatabase != nil {
+			components = components[1:]
+		}
+		for _, component := range components {
+			if !payload.DynamicProvisioning {
+				steps = append(steps, pvStep(component))
+			}
+			steps = append(steps, pvcStep(component))
+		}
+	}
+
+	if payload.ServiceAccount {
+		rbac := clientSet.RbacV1()
+		steps = append(steps, reconcileStep{
+			Kind: "ServiceAccount", Name: names.ServiceAccount,
+			Get: func(ctx context.Context) error {
+				_, err := core.ServiceAccounts(ns).Get(ctx, names.ServiceAccount, get)
+				return err
+			},
+			Create: func(ctx context.Context) error { return createStackServiceAccount(ctx, clientSet, payload, names) },
+		})
+		if len(payload.ServiceAccountRules) > 0 {
+			steps = append(steps, reconcileStep{
+				Kind: "Role", Name: names.ServiceAccount,
+				Get: func(ctx context.Context) error {
+					_, err := rbac.Roles(ns).Get(ctx, names.ServiceAccount, get)
+					return err
+				},
+				Create: func(ctx context.Context) error { return createStackRole(ctx, clientSet, payload, names) },
+			}, reconcileStep{
+				Kind: "RoleBinding", Name: names.ServiceAccount,
+				Get: func(ctx context.Context) error {
+					_, err := rbac.RoleBindings(ns).Get(ctx, names.ServiceAccount, get)
+					return err
+				},
+				Create: func(ctx context.Context) error { return createStackRoleBinding(ctx, clientSet, payload, names) },
+			})
+		}
+	}
+
+	if payload.SecretMode == secretModeExternalSecret {
+		// The operator recreates a deleted Secret from the remote key by itself.
+		steps = append(steps, reconcileStep{
+			Kind: "ExternalSecret", Name: names.DBSecret,
+			Get:    func(ctx context.Context) error { return getExternalSecret(ctx, clientSet, ns, names.DBSecret) },
+			Create: func(ctx context.Context) error { return createStackExternalSecret(ctx, clientSet, payload, names) },
+		})
+	} else {
+		steps = append(steps, reconcileStep{
+			Kind: "Secret", Name: names.DBSecret,
+			Get:    func(ctx context.Context) error { _, err := core.Secrets(ns).Get(ctx, names.DBSecret, get); return err },
+			Create: func(ctx context.Context) error { return createWPMySQLSecret(ctx, clientSet, payload, names) },
+		})
+	}
+	if payload.DBCACert != "" {
+		steps = append(steps, reconcileStep{
+			Kind: "Secret", Name: names.DBCASecret,
+			Get: func(ctx context.Context) error {
+				_, err := core.Secrets(ns).Get(ctx, names.DBCASecret, get)
+				return err
+			},
+			Create: func(ctx context.Context) error { return createDBCASecret(ctx, clientSet, payload, names) },
+		})
+	}
+	if ownsObjectStorageSecret(payload) {
+		steps = append(steps, reconcileStep{
+			Kind: "Secret", Name: names.WPObjectStorageSecret,
+			Get: func(ctx context.Context) error {
+				_, err := core.Secrets(ns).Get(ctx, names.WPObjectStorageSecret, get)
+				return err
+			},
+			Create: func(ctx context.Context) error { return createObjectStorageSecret(ctx, clientSet, payload, names) },
+		})
+	}
+
+	if payload.ExternalDatabase == nil {
+		if payload.MySQLConfig != "" {
+			steps = append(steps, reconcileStep{
+				Kind: "ConfigMap", Name: names.DBConfig,
+				Get: func(ctx context.Context) error {
+					_, err := core.ConfigMaps(ns).Get(ctx, names.DBConfig, get)
+					return err
+				},
+				Create: func(ctx context.Context) error { return createMySQLConfigMap(ctx, clientSet, payload, names) },
+			})
+		}
+		if payload.MySQLReadReplica {
+			steps = append(steps, reconcileStep{
+				Kind: "ConfigMap", Name: names.DBReplicationConfig,
+				Get: func(ctx context.Context) error {
+					_, err := core.ConfigMaps(ns).Get(ctx, names.DBReplicationConfig, get)
+					return err
+				},
+				Create: func(ctx context.Context) error {
+					return createMySQLReplicationConfigMap(ctx, clientSet, payload, names)
+				},
+			})
+		}
+		steps = append(steps,
+			deploymentStep("MySQL Deployment", names.DBDeployment, createMySQLDeployment),
+			serviceStep("MySQL Service", names.DBService, createMySQLService))
+		if payload.MySQLReadReplica {
+			steps = append(steps,
+				deploymentStep("MySQL Replica Deployment", names.DBReplicaDeployment, createMySQLReplicaDeployment),
+				serviceStep("MySQL Replica Service", names.DBReplicaService, createMySQLReplicaService))
+		}
+	}
+
+	if payload.PhpMyAdmin {
+		steps = append(steps,
+			deploymentStep("phpMyAdmin Deployment", names.PMADeployment, createPhpMyAdminDeployment),
+			serviceStep("phpMyAdmin Service", names.PMAService, createPhpMyAdminService))
+	}
+	if payload.RedisCache {
+		steps = append(steps,
+			deploymentStep("Redis Deployment", names.RedisDeployment, createRedisDeployment),
+			serviceStep("Redis Service", names.RedisService, createRedisService))
+	}
+	steps = append(steps, deploymentStep("WordPress Deployment", names.WPDeployment, createWordPressDeployment))
+	if payload.Canary != nil {
+		steps = append(steps,
+			deploymentStep("WordPress Canary Deployment", names.WPCanaryDeployment, createWordPressCanaryDeployment))
+	}
+	steps = append(steps, serviceStep("WordPress Service", names.WPService, createWordPressService))
+	if payload.Gateway != nil {
+		steps = append(steps, reconcileStep{
+			Kind: "HTTPRoute", Name: names.HTTPRoute,
+			Get:    func(ctx context.Context) error { return getHTTPRoute(ctx, clientSet, ns, names.HTTPRoute) },
+			Create: func(ctx context.Context) error { return createWordPressHTTPRoute(ctx, clientSet, payload, names) },
+		})
+	}
+	return steps
+}
+
+// reconcileStack creates whatever is missing from an existing stack, leaves the rest untouched,
+// and waits for every deployment to become ready.
+func reconcileStack(ctx context.Context, payload RequestPayload, names stackNames) (APIResponse, int) {
+	if payload.Verbose {
+		ctx = withVerbose(ctx)
+	}
+	clientSet, err := InitKubeClient(payload.Kubeconfig, payload.KubeContext)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		return APIResponse{
+			Success: false,
+			Message: message,
+		}, status
+	}
+
+	_, err = ensureNamespace(ctx, clientSet, payload.Namespace, payload.NamespaceLabels, payload.NamespaceAnnotations,
+		namespaceCreatedFor(payload, names))
+	if err != nil {
+		log.Printf("[ERROR] Failed to ensure namespace: %v", err)
+		return APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}, namespaceErrorStatus(err)
+	}
+
+	resolveSharedDatabase(payload, names)
+	var warnings []string
+	resources := newResourceList(payload.Namespace)
+	created := map[string]bool{}
+	for _, step := range reconcileSteps(clientSet, payload, names) {
+		err := step.Get(ctx)
+		if err == nil {
+			debugf(ctx, "Reconcile: %s %s is present", step.Kind, step.Name)
+			resources.addNoted(step.Kind, step.Name, "(present)")
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			log.Printf("[ERROR] Failed to look up %s %s: %v", step.Kind, step.Name, err)
+			return APIResponse{
+				Success:      false,
+				Message:      fmt.Sprintf("Could not look up %s %s: %v", step.Kind, step.Name, err),
+				Resources:    resources.Summaries,
+				ResourceRefs: resources.Refs,
+			}, http.StatusInternalServerError
+		}
+
+		log.Printf("[INFO] Reconcile: creating missing %s %s", step.Kind, step.Name)
+		if err := step.Create(ctx); err != nil {
+			log.Printf("[ERROR] Failed to create %s %s: %v", step.Kind, step.Name, err)
+			return APIResponse{
+				Success:      false,
+				Message:      fmt.Sprintf("Failed to create %s %s: %v", step.Kind, step.Name, err),
+				Resources:    resources.Summaries,
+				ResourceRefs: resources.Refs,
+			}, http.StatusInternalServerError
+		}
+		created[step.Name] = true
+		resources.addNoted(step.Kind, step.Name, "(created)")
+	}
+
+	// MySQL only reads its credentials when initialising an empty data dir.
+	if created[names.DBSecret] && payload.ExternalDatabase == nil && payload.SecretMode != secretModeExternalSecret &&
+		!created[names.DBPVC] {
+		warning := fmt.Sprintf("secret %s was recreated with new passwords; an existing MySQL data directory still expects the old ones",
+			names.DBSecret)
+		log.Printf("[WARN] %s", warning)
+		warnings = append(warnings, warning)
+	}
+
+	// The shared server still has the user with the old password; provisioning again resets it.
+	if created[names.DBSecret] && payload.SharedDatabase != nil {
+		log.Printf("[INFO] Resetting the password of user %s with job %s", payload.ExternalDatabase.User, names.DBProvisionJob)
+		if err := provisionSharedDatabase(ctx, clientSet, payload, names, 120*time.Second); err != nil {
+			log.Printf("[ERROR] Shared database provisioning failed: %v", err)
+			return APIResponse{
+				Success:   false,
+				Message:   fmt.Sprintf("Cannot reset the database password on %s: %v", payload.SharedDatabase.Service, err),
+				Resources: resources.Summaries,
+			}, http.StatusBadGateway
+		}
+		resources.add("Job", names.DBProvisionJob)
+	}
+
+	waitFor := []string{names.WPDeployment}
+	if payload.ExternalDatabase == nil {
+		waitFor = append([]string{names.DBDeployment}, waitFor...)
+		if payload.MySQLReadReplica {
+			waitFor = append(waitFor, names.DBReplicaDeployment)
+		}
+	}
+	if payload.Canary != nil {
+		waitFor = append(waitFor, names.WPCanaryDeployment)
+	}
+	message := fmt.Sprintf("Stack reconciled; %d missing resource(s) created.", len(created))
+	if !waitForReady(payload) {
+		waitFor = nil
+		message += " Readiness was not verified."
+	}
+	for _, deployName := range waitFor {
+		if err := waitForDeploymentReady(ctx, clientSet, payload.Namespace, deployName, readinessTimeout(payload), pollInterval(payload)); err != nil {
+			log.Printf("[ERROR] Deployment %s not ready in time: %v", deployName, err)
+			return APIResponse{
+				Success:      false,
+				Message:      notReadyMessage("Deployment "+deployName, err),
+				Resources:    resources.Summaries,
+				ResourceRefs: resources.Refs,
+				Warnings:     warnings,
+			}, http.StatusInternalServerError
+		}
+	}
+
+	return APIResponse{
+		Success:      true,
+		Message:      message,
+		Resources:    resources.Summaries,
+		ResourceRefs: resources.Refs,
+		Suffix:       names.Suffix,
+		SiteURL:      wpSiteURL(payload, names),
+		Warnings:     warnings,
+	}, http.StatusOK
+}
+
+// handleReconcile drives a half-deployed stack to completion. The body is the original create
+// payload plus the stack's suffix; only missing resources are created.
+func handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req ReconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] Failed to decode request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Invalid JSON payload",
+		})
+		return
+	}
+	if !suffixPattern.MatchString(req.Suffix) {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "suffix must be the suffix returned when the stack was created",
+		})
+		return
+	}
+
+	payload := req.RequestPayload
+	if conflicts := validatePayload(payload); len(conflicts) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("The request has %d conflicting option(s)", len(conflicts)),
+			Errors:  conflicts,
+		})
+		return
+	}
+	if status, err := preparePayload(&payload); err != nil {
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	names := stackNamesFor(payload, req.Suffix)
+	if payload.NamespacePerDeployment {
+		payload.Namespace = names.ID()
+	}
+	log.Printf("[INFO] Reconciling stack %s in namespace %s", names.ID(), payload.Namespace)
+	resp, status := reconcileStack(r.Context(), payload, names)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	respondJSON(w, resp)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultRedisImage is the official Redis image used for the object cache.
+const defaultRedisImage = "redis:7-alpine"
+
+// redisPort is the port Redis listens on and its Service exposes.
+const redisPort = 6379
+
+// redisCachePlugin is the wordpress.org slug of Redis Object Cache, which reads WP_REDIS_HOST
+// and WP_REDIS_PORT and takes effect once `wp redis enable` installs its drop-in.
+const redisCachePlugin = "redis-cache"
+
+// redisCacheArgs run Redis as a pure cache: bounded memory, evicting the least recently used
+// keys, and nothing written to disk, so the pod needs no volume.
+var redisCacheArgs = []string{
+	"--maxmemory", "256mb",
+	"--maxmemory-policy", "allkeys-lru",
+	"--save", "",
+	"--appendonly", "no",
+}
+
+// redisConfig points WordPress at the stack's Redis Service in wp-config.php.
+func redisConfig(names stackNames) string {
+	return fmt.Sprintf("define('WP_REDIS_HOST', '%s');\ndefine('WP_REDIS_PORT', %d);", names.RedisService, redisPort)
+}
+
+// buildRedisDeployment returns a single-replica Redis serving as WordPress's object cache.
+func buildRedisDeployment(payload RequestPayload, names stackNames) *appsv1.Deployment {
+	namespace, deployName := payload.Namespace, names.RedisDeployment
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        deployName,
+			Namespace:   namespace,
+			Labels:      stackLabels(deployName, names, componentCache),
+			Annotations: deploymentAnnotations(payload),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:             int32Ptr(1),
+			RevisionHistoryLimit: payload.RevisionHistoryLimit,
+			Selector: &metaV1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": deployName,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      stackLabels(deployName, names, componentCache),
+					Annotations: podAnnotations(payload),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadDeployment),
+					Containers: []corev1.Container{
+						{
+							Name:            "redis",
+							Image:           defaultRedisImage,
+							ImagePullPolicy: imagePullPolicy(payload, defaultRedisImage),
+							Args:            redisCacheArgs,
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: redisPort,
+									Name:          "redis",
+								},
+							},
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									Exec: &corev1.ExecAction{
+										Command: []string{"redis-cli", "ping"},
+									},
+								},
+								InitialDelaySeconds: 5,
+								PeriodSeconds:       10,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	applyDNS(&deployment.Spec.Template.Spec, payload)
+	applyServiceAccount(&deployment.Spec.Template.Spec, payload, names)
+	return deployment
+}
+
+// createRedisDeployment creates the Deployment described by buildRedisDeployment.
+func createRedisDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	deployment := buildRedisDeployment(payload, names)
+	_, err := clientSet.AppsV1().Deployments(payload.Namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create Redis deployment %s: %w", names.RedisDeployment, err)
+	}
+	return nil
+}
+
+// buildRedisService returns a ClusterIP service for Redis on its standard port.
+func buildRedisService(payload RequestPayload, names stackNames) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.RedisService,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.RedisDeployment, names, componentCache),
+			Annotations: stackAnnotations(payload),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": names.RedisDeployment,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "redis",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       redisPort,
+					TargetPort: intstr.FromString("redis"),
+				},
+			},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+// createRedisService creates the Service described by buildRedisService.
+func createRedisService(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	service := buildRedisService(payload, names)
+	_, err := clientSet.CoreV1().Services(payload.Namespace).Create(ctx, service, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create Redis service %s: %w", names.RedisService, err)
+	}
+	return nil
+}
+
+// redisEnableEnv tells the extensions Job to install the plugin's drop-in after activating it.
+func redisEnableEnv(payload RequestPayload) []corev1.EnvVar {
+	if !payload.RedisCache {
+		return nil
+	}
+	return []corev1.EnvVar{{Name: "WP_REDIS_ENABLE", Value: "1"}}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "strings"
+
+// ResourceRef identifies one Kubernetes object by kind, name and, unless it is
+// cluster-scoped, namespace, e.g. to feed it to kubectl or the delete endpoints.
+type ResourceRef struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// resourceList collects the resources of a response twice: as the human-readable summaries of
+// APIResponse.Resources and as the ResourceRefs meant for programs.
+type resourceList struct {
+	namespace string
+	Summaries []string
+	Refs      []ResourceRef
+}
+
+// newResourceList returns an empty list of resources in namespace.
+func newResourceList(namespace string) *resourceList {
+	return &resourceList{namespace: namespace}
+}
+
+// add records a resource under its summary label, such as "PVC" or "MySQL Deployment".
+func (l *resourceList) add(label, name string) {
+	l.addNoted(label, name, "")
+}
+
+// addNoted records a resource whose summary carries a note, such as "(created)".
+func (l *resourceList) addNoted(label, name, note string) {
+	summary := label + ": " + name
+	if note != "" {
+		summary += " " + note
+	}
+	l.Summaries = append(l.Summaries, summary)
+
+	ref := ResourceRef{Kind: resourceKind(label), Name: name}
+	if ref.Kind != "Namespace" && ref.Kind != "PersistentVolume" {
+		ref.Namespace = l.namespace
+	}
+	l.Refs = append(l.Refs, ref)
+}
+
+// resourceKind maps a summary label to the Kubernetes kind it stands for.
+func resourceKind(label string) string {
+	switch {
+	case label == "PV":
+		return "PersistentVolume"
+	case label == "PVC", label == "Shared volume":
+		return "PersistentVolumeClaim"
+	case strings.HasSuffix(label, " Deployment"):
+		return "Deployment"
+	case strings.HasSuffix(label, " Service"):
+		return "Service"
+	}
+	return label // Namespace, Secret, ConfigMap, Job, ResourceQuota, VolumeSnapshot
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// renderStackManifest builds every object of the stack with the same builders used for a live
+// deploy and serializes them as multi-document YAML, ready for `kubectl apply -f`.
+func renderStackManifest(payload RequestPayload, names stackNames) (string, error) {
+	resolveSharedDatabase(payload, names)
+	objects := []runtime.Object{buildNamespace(payload.Namespace, payload.NamespaceLabels, payload.NamespaceAnnotations,
+		namespaceCreatedFor(payload, names))}
+	if payload.NamespaceQuota != nil {
+		quota, limits, err := buildNamespaceQuota(payload.Namespace, *payload.NamespaceQuota)
+		if err != nil {
+			return "", err
+		}
+		if limits != nil {
+			objects = append(objects, limits)
+		}
+		objects = append(objects, quota)
+	}
+
+	if payload.SharedVolume {
+		pv, pvc, err := buildSharedVolume(payload)
+		if err != nil {
+			return "", err
+		}
+		objects = append(objects, pv, pvc)
+	} else {
+		components := []string{componentDatabase, componentWordPress}
+		if payload.ExternalDatabase != nil {
+			components = components[1:]
+		}
+		for _, component := range components {
+			if pvName, _, sizeGB := stackVolume(payload, names, component); pvName != "" {
+				pv, err := buildPersistentVolume(payload.Namespace, pvName, hostPathFor(payload.Namespace, pvName), sizeGB,
+					stackLabels(pvName, names, component), payload.HostNode, corev1.HostPathType(payload.HostPathType))
+				if err != nil {
+					return "", err
+				}
+				objects = append(objects, pv)
+			}
+			pvc, err := buildStackPVC(payload, names, component)
+			if err != nil {
+				return "", err
+			}
+			objects = append(objects, pvc)
+		}
+	}
+
+	if payload.ServiceAccount {
+		// The pull secrets copied from the default ServiceAccount are only known in the cluster.
+		objects = append(objects, buildStackServiceAccount(payload, names, nil))
+		if len(payload.ServiceAccountRules) > 0 {
+			objects = append(objects, buildStackRole(payload, names), buildStackRoleBinding(payload, names))
+		}
+	}
+
+	if payload.SecretMode == secretModeExternalSecret {
+		objects = append(objects, buildStackExternalSecret(payload, names))
+	} else {
+		secret, err := buildWPMySQLSecret(payload, names)
+		if err != nil {
+			return "", err
+		}
+		objects = append(objects, secret)
+	}
+	if payload.DBCACert != "" {
+		objects = append(objects, buildDBCASecret(payload, names))
+	}
+	if ownsObjectStorageSecret(payload) {
+		objects = append(objects, buildObjectStorageSecret(payload, names))
+	}
+
+	if payload.ExternalDatabase == nil {
+		dbDeployment, err := buildMySQLDeployment(payload, names)
+		if err != nil {
+			return "", err
+		}
+		if payload.MySQLConfig != "" {
+			objects = append(objects, buildMySQLConfigMap(payload, names))
+		}
+		if payload.MySQLReadReplica {
+			objects = append(objects, buildMySQLReplicationConfigMap(payload, names))
+		}
+		objects = append(objects, dbDeployment, buildMySQLService(payload, names))
+		if payload.MySQLReadReplica {
+			replica, err := buildMySQLReplicaDeployment(payload, names)
+			if err != nil {
+				return "", err
+			}
+			objects = append(objects, replica, buildMySQLReplicaService(payload, names))
+		}
+	} else {
+		if payload.SharedDatabase != nil {
+			objects = append(objects, buildSharedDBProvisionJob(payload, names))
+		}
+		if payload.CheckExternalDatabase {
+			objects = append(objects, buildExternalDBCheckJob(payload, names))
+		}
+	}
+
+	if payload.PhpMyAdmin {
+		objects = append(objects, buildPhpMyAdminDeployment(payload, names), buildPhpMyAdminService(payload, names))
+	}
+	if payload.RedisCache {
+		objects = append(objects, buildRedisDeployment(payload, names), buildRedisService(payload, names))
+	}
+	objects = append(objects,
+		buildWordPressDeployment(payload, names),
+	)
+	if payload.Canary != nil {
+		objects = append(objects, buildWordPressCanaryDeployment(payload, names))
+	}
+	objects = append(objects, buildWordPressService(payload, names))
+	if payload.Gateway != nil {
+		objects = append(objects, buildWordPressHTTPRoute(payload, names))
+	}
+	if payload.AutoInstall {
+		// Applied together, the Job retries with backoff until WordPress answers.
+		objects = append(objects,
+			buildWPAdminSecret(payload, names),
+			buildWPCLIJob(payload, names, names.WPInstallJob, wpInstallScript, nil, names.WPAdminSecret))
+		if len(payload.WPPlugins)+len(payload.WPThemes) > 0 {
+			// wp-cli refuses to install extensions before core is installed, so this one retries too.
+			objects = append(objects, buildWPExtensionsJob(payload, names))
+		}
+	}
+	return encodeYAMLDocuments(objects)
+}
+
+// encodeYAMLDocuments serializes objects with the client-go scheme, filling in apiVersion/kind
+// (typed objects leave TypeMeta empty), and joins them with YAML document separators.
+func encodeYAMLDocuments(objects []runtime.Object) (string, error) {
+	serializer := k8sjson.NewSerializerWithOptions(k8sjson.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+		k8sjson.SerializerOptions{Yaml: true})
+
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil || len(gvks) == 0 {
+			return "", fmt.Errorf("unknown kind for %T: %v", obj, err)
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		if err := serializer.Encode(obj, &buf); err != nil {
+			return "", fmt.Errorf("failed to encode %s: %w", gvks[0].Kind, err)
+		}
+	}
+	return buf.String(), nil
+}
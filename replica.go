@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// mysqlInitDir is where the MySQL image runs init scripts on first start of an empty data dir.
+const mysqlInitDir = "/docker-entrypoint-initdb.d"
+
+// mysqlReplicationUser is the account the read replica uses to pull the primary's binlog.
+const mysqlReplicationUser = "replicator"
+
+// mysqlPrimaryStartScript starts the primary with an --init-file that creates or updates the
+// replication account. mysqld runs it on every start, the first one included, so a primary on a
+// kept or reused volume, whose init scripts never run again, still gets the account. It is left
+// out of the binlog: only the primary needs it.
+const mysqlPrimaryStartScript = `umask 077
+f=/tmp/replication-user.sql
+cat > "$f" <<SQL
+SET sql_log_bin = 0;
+CREATE USER IF NOT EXISTS '` + mysqlReplicationUser + `'@'%' IDENTIFIED BY '$MYSQL_REPLICATION_PASSWORD';
+ALTER USER '` + mysqlReplicationUser + `'@'%' IDENTIFIED BY '$MYSQL_REPLICATION_PASSWORD';
+GRANT REPLICATION SLAVE ON *.* TO '` + mysqlReplicationUser + `'@'%';
+SET sql_log_bin = 1;
+SQL
+[ "$(id -u)" != 0 ] || chown mysql "$f"
+exec docker-entrypoint.sh mysqld "$@" --init-file="$f"`
+
+// mysqlPrimaryBinlogArgs keep the primary's binlog for good. Replicas start empty and replay
+// it from the first transaction, so a purged binlog, after 30 days by default, would leave
+// every restarted replica failing with error 1236. The price is a binlog that grows with
+// every write on the primary's volume; size it for that.
+var mysqlPrimaryBinlogArgs = []string{"--binlog-expire-logs-seconds=0"}
+
+// mysqlReplicaInitScript points the replica at the primary. With GTID auto-positioning it
+// replays the primary's binlog from the start, including the WordPress database and user,
+// which mysqlPrimaryBinlogArgs keeps complete.
+const mysqlReplicaInitScript = `mysql --protocol=socket -uroot -p"$MYSQL_ROOT_PASSWORD" <<SQL
+CHANGE REPLICATION SOURCE TO SOURCE_HOST='$MYSQL_SOURCE_HOST', SOURCE_USER='` + mysqlReplicationUser + `',
+  SOURCE_PASSWORD='$MYSQL_REPLICATION_PASSWORD', SOURCE_AUTO_POSITION=1, GET_SOURCE_PUBLIC_KEY=1;
+START REPLICA;
+SQL
+`
+
+// minReplicaMySQLVersion is the first MySQL release with CHANGE REPLICATION SOURCE TO and
+// START REPLICA, which mysqlReplicaInitScript uses.
+const minReplicaMySQLVersion = "8.0.23"
+
+// mysqlVersionAtLeast reports whether version, as taken from an image tag, is min or later.
+// Components the tag leaves out are not compared: "8" and "8.0" float to the latest 8.0
+// release, and an image without a version tag is given the benefit of the doubt.
+func mysqlVersionAtLeast(version, min string) bool {
+	if version == "" {
+		return true
+	}
+	have, want := strings.Split(version, "."), strings.Split(min, ".")
+	for i := 0; i < len(have) && i < len(want); i++ {
+		h, _ := strconv.Atoi(have[i])
+		w, _ := strconv.Atoi(want[i])
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// maxDatabaseReplicas bounds database_replicas: every replica replays the primary's whole
+// binlog into an emptyDir, which gets expensive quickly.
+const maxDatabaseReplicas = 6
+
+// mysqlReplicaServerIDScript starts MySQL with a server ID derived from the pod name, since
+// replicas of one Deployment share a spec but each needs its own ID. IDs 0 and 1 are taken
+// by "unset" and the primary.
+const mysqlReplicaServerIDScript = `id=$(printf %s "$HOSTNAME" | cksum | cut -d' ' -f1)
+[ "$id" -gt 1 ] || id=2
+exec docker-entrypoint.sh mysqld "$@" --server-id="$id"`
+
+// mysqlReplicationArgs returns the mysqld flags GTID replication needs on both sides.
+func mysqlReplicationArgs(serverID int) []string {
+	return []string{
+		fmt.Sprintf("--server-id=%d", serverID),
+		"--gtid-mode=ON",
+		"--enforce-gtid-consistency=ON",
+	}
+}
+
+// mysqlInitVolume returns the volume exposing the replica init script to the MySQL
+// entrypoint, and the matching mount.
+func mysqlInitVolume(names stackNames, scriptKey string) (corev1.Volume, corev1.VolumeMount) {
+	volume := corev1.Volume{
+		Name: "mysql-init",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: names.DBReplicationConfig},
+				Items:                []corev1.KeyToPath{{Key: scriptKey, Path: "replication.sh"}},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: "mysql-init", MountPath: mysqlInitDir, ReadOnly: true}
+	return volume, mount
+}
+
+// buildMySQLReplicationConfigMap returns the ConfigMap holding the replica init script.
+func buildMySQLReplicationConfigMap(payload RequestPayload, names stackNames) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.DBReplicationConfig,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.DBReplicationConfig, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
+		},
+		Data: map[string]string{
+			"replica.sh": mysqlReplicaInitScript,
+		},
+	}
+}
+
+// createMySQLReplicationConfigMap creates the ConfigMap described by buildMySQLReplicationConfigMap.
+func createMySQLReplicationConfigMap(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	cm := buildMySQLReplicationConfigMap(payload, names)
+	_, err := clientSet.CoreV1().ConfigMaps(payload.Namespace).Create(ctx, cm, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create configmap %s: %w", names.DBReplicationConfig, err)
+	}
+	return nil
+}
+
+// buildMySQLReplicaDeployment returns the read-only MySQL pods replicating from the primary,
+// one fewer than database_replicas. It starts from the primary's spec, so resources, probes and
+// tuning match, but keeps their data in emptyDirs: a restarted replica replays the primary's
+// binlog again, which the primary keeps in full for that, see mysqlPrimaryBinlogArgs.
+//
+// The replicas are a Deployment rather than a StatefulSet with volumeClaimTemplates: the tree
+// has no StatefulSet path, and per-pod claims would need dynamic provisioning, while stacks
+// default to hostPath PVs created ahead of their claims. Since a replica holds no data of its
+// own, stable names and volumes would buy nothing but slower restarts. The cost is that every
+// replica start re-reads the whole binlog, which maxDatabaseReplicas keeps in check, and that
+// the primary never purges it.
+func buildMySQLReplicaDeployment(payload RequestPayload, names stackNames) (*appsv1.Deployment, error) {
+	deployment, err := buildMySQLDeployment(payload, names)
+	if err != nil {
+		return nil, err
+	}
+
+	deployName := names.DBReplicaDeployment
+	deployment.Name = deployName
+	deployment.Labels = stackLabels(deployName, names, componentDatabase)
+	deployment.Spec.Selector.MatchLabels = map[string]string{"app": deployName}
+	deployment.Spec.Template.Labels = stackLabels(deployName, names, componentDatabase)
+
+	deployment.Spec.Replicas = int32Ptr(payload.DatabaseReplicas - 1)
+
+	pod := &deployment.Spec.Template.Spec
+	container := &pod.Containers[0]
+	container.Args = append(append(mysqlContainerArgs(payload), mysqlReplicationArgs(2)...), "--read-only=ON")
+	container.Command = nil // Only the primary needs the replication account.
+	if payload.DatabaseReplicas > 2 {
+		// The script's --server-id comes last, so it wins over the fixed one above.
+		container.Command = []string{"sh", "-c", mysqlReplicaServerIDScript, "sh"}
+	}
+
+	// Only root is set up locally: the database and WordPress user arrive through replication,
+	// and creating them here as well would break the first replicated statements.
+	secretKey := func(key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: names.DBSecret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+	container.EnvFrom = nil
+	container.Env = append(container.Env,
+		secretKey("MYSQL_ROOT_PASSWORD"),
+		secretKey("MYSQL_REPLICATION_PASSWORD"),
+		corev1.EnvVar{Name: "MYSQL_SOURCE_HOST", Value: names.DBService},
+	)
+
+	for i := range pod.Volumes {
+		if pod.Volumes[i].Name == "mysql-persistent-storage" {
+			pod.Volumes[i].VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		}
+	}
+	initVolume, initMount := mysqlInitVolume(names, "replica.sh")
+	pod.Volumes = append(pod.Volumes, initVolume)
+	container.VolumeMounts = append(container.VolumeMounts, initMount)
+	return deployment, nil
+}
+
+// createMySQLReplicaDeployment creates the Deployment described by buildMySQLReplicaDeployment.
+func createMySQLReplicaDeployment(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	deployment, err := buildMySQLReplicaDeployment(payload, names)
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.AppsV1().Deployments(payload.Namespace).Create(ctx, deployment, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create MySQL replica deployment %s: %w", names.DBReplicaDeployment, err)
+	}
+	return nil
+}
+
+// buildMySQLReplicaService returns the read-only Service in front of the replica.
+func buildMySQLReplicaService(payload RequestPayload, names stackNames) *corev1.Service {
+	service := buildMySQLService(payload, names)
+	service.Name = names.DBReplicaService
+	service.Labels = stackLabels(names.DBReplicaDeployment, names, componentDatabase)
+	service.Spec.Selector = map[string]string{"app": names.DBReplicaDeployment}
+	return service
+}
+
+// createMySQLReplicaService creates the Service described by buildMySQLReplicaService.
+func createMySQLReplicaService(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	service := buildMySQLReplicaService(payload, names)
+	_, err := clientSet.CoreV1().Services(payload.Namespace).Create(ctx, service, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create MySQL replica service %s: %w", names.DBReplicaService, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// resizeWait is how long /resize watches the claim for the resize to finish before answering.
+const resizeWait = 30 * time.Second
+
+// ResizeRequest is the body of /resize: the stack, the component whose claim grows and its new size.
+type ResizeRequest struct {
+	Kubeconfig     string `json:"kubeconfig,omitempty"`
+	KubeContext    string `json:"context,omitempty"`
+	Namespace      string `json:"namespace"`
+	DeploymentName string `json:"deployment_name,omitempty"` // Defaults to "wp"
+	Suffix         string `json:"suffix"`
+	Component      string `json:"component"`               // "wordpress" or "database"
+	SizeGB         int    `json:"size_gb"`                 // Must be larger than the current size
+	NameTemplate   string `json:"name_template,omitempty"` // The stack's name_template, if it was created with one
+}
+
+// ResizeResult reports a claim expansion.
+type ResizeResult struct {
+	PVC             string `json:"pvc"`
+	OldSize         string `json:"old_size"`
+	NewSize         string `json:"new_size"`
+	Capacity        string `json:"capacity"`         // What the volume reports so far
+	RestartRequired bool   `json:"restart_required"` // The filesystem only grows once the pod is restarted
+}
+
+// errNotExpandable means the claim cannot grow: it is smaller than asked, on a hostPath
+// volume, or its StorageClass does not allow expansion.
+var errNotExpandable = errors.New("PVC cannot be expanded")
+
+// handleResize grows one of a stack's PVCs.
+func handleResize(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var req ResizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] Failed to decode request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "Invalid JSON payload",
+		})
+		return
+	}
+	tmpl, err := nameTemplateParam(req.NameTemplate)
+	if err == nil {
+		err = validateResizeRequest(&req)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	clientSet, err := InitKubeClient(req.Kubeconfig, req.KubeContext)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	names := newTemplatedStackNames(tmpl, req.Namespace, req.DeploymentName, req.Suffix)
+	_, pvcName, _ := stackVolume(RequestPayload{}, names, req.Component)
+	result, err := resizePVC(r.Context(), clientSet, req.Namespace, pvcName, req.SizeGB)
+	switch {
+	case apierrors.IsNotFound(err):
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("PVC %s not found in namespace %s", pvcName, req.Namespace),
+		})
+		return
+	case errors.Is(err, errNotExpandable):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		log.Printf("[ERROR] Failed to resize PVC %s: %v", pvcName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to resize PVC %s: %v", pvcName, err),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("PVC %s resized to %s", pvcName, result.NewSize)
+	switch {
+	case result.RestartRequired:
+		message = fmt.Sprintf("PVC %s was expanded to %s; restart the pods using it so the filesystem grows", pvcName, result.NewSize)
+	case result.Capacity != result.NewSize:
+		message = fmt.Sprintf("PVC %s is being expanded to %s; capacity is %s so far", pvcName, result.NewSize, result.Capacity)
+	}
+	respondJSON(w, APIResponse{
+		Success: true,
+		Message: message,
+		Resize:  result,
+	})
+}
+
+// validateResizeRequest checks the request and fills in the default deployment name.
+func validateResizeRequest(req *ResizeRequest) error {
+	if req.Namespace == "" {
+		return errors.New("namespace is required")
+	}
+	if !suffixPattern.MatchString(req.Suffix) {
+		return errors.New("suffix must be the suffix returned when the stack was created")
+	}
+	if req.DeploymentName == "" {
+		req.DeploymentName = "wp"
+	}
+	if req.Component != componentWordPress && req.Component != componentDatabase {
+		return fmt.Errorf("component must be %q or %q", componentWordPress, componentDatabase)
+	}
+	if req.SizeGB < 1 {
+		return errors.New("size_gb must be at least 1")
+	}
+	return nil
+}
+
+// resizePVC raises a claim's storage request after checking the claim can grow, then watches
+// it for up to resizeWait to tell whether the filesystem needs a pod restart to follow.
+func resizePVC(ctx context.Context, clientSet *kubernetes.Clientset, namespace, pvcName string, sizeGB int) (*ResizeResult, error) {
+	claims := clientSet.CoreV1().PersistentVolumeClaims(namespace)
+	pvc, err := claims.Get(ctx, pvcName, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	newSize := resource.MustParse(fmt.Sprintf("%dGi", sizeGB))
+	oldSize := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if newSize.Cmp(oldSize) <= 0 {
+		return nil, fmt.Errorf("%w: new size %s must be larger than the current %s", errNotExpandable, newSize.String(), oldSize.String())
+	}
+	if err := checkExpandable(ctx, clientSet, pvc); err != nil {
+		return nil, err
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"resources": map[string]any{
+				"requests": map[string]string{string(corev1.ResourceStorage): newSize.String()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Resizing PVC %s/%s from %s to %s", namespace, pvcName, oldSize.String(), newSize.String())
+	if _, err := claims.Patch(ctx, pvcName, types.MergePatchType, patch, metaV1.PatchOptions{}); err != nil {
+		return nil, fmt.Errorf("unable to patch PVC: %w", err)
+	}
+
+	result := &ResizeResult{PVC: pvcName, OldSize: oldSize.String(), NewSize: newSize.String()}
+	err = wait.PollUntilContextTimeout(ctx, 2*time.Second, resizeWait, true, func(ctx context.Context) (bool, error) {
+		pvc, err := claims.Get(ctx, pvcName, metaV1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+		result.Capacity = capacity.String()
+		result.RestartRequired = false
+		for _, cond := range pvc.Status.Conditions {
+			if cond.Type == corev1.PersistentVolumeClaimFileSystemResizePending && cond.Status == corev1.ConditionTrue {
+				result.RestartRequired = true
+			}
+		}
+		return capacity.Cmp(newSize) >= 0, nil
+	})
+	if err != nil && !wait.Interrupted(err) {
+		return result, fmt.Errorf("unable to follow the resize: %w", err)
+	}
+	return result, nil
+}
+
+// checkExpandable refuses claims on hostPath PVs, whose size is only nominal, and claims whose
+// StorageClass does not set allowVolumeExpansion.
+func checkExpandable(ctx context.Context, clientSet *kubernetes.Clientset, pvc *corev1.PersistentVolumeClaim) error {
+	if pvc.Spec.VolumeName != "" {
+		pv, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metaV1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get PV %s: %w", pvc.Spec.VolumeName, err)
+		}
+		if pv.Spec.HostPath != nil {
+			return fmt.Errorf("%w: PV %s is a hostPath volume, which has no size to expand", errNotExpandable, pv.Name)
+		}
+	}
+
+	className := ""
+	if pvc.Spec.StorageClassName != nil {
+		className = *pvc.Spec.StorageClassName
+	}
+	if className == "" {
+		return fmt.Errorf("%w: PVC %s has no StorageClass", errNotExpandable, pvc.Name)
+	}
+	class, err := clientSet.StorageV1().StorageClasses().Get(ctx, className, metaV1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: StorageClass %s does not exist", errNotExpandable, className)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get StorageClass %s: %w", className, err)
+	}
+	if class.AllowVolumeExpansion == nil || !*class.AllowVolumeExpansion {
+		return fmt.Errorf("%w: StorageClass %s does not set allowVolumeExpansion", errNotExpandable, className)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the subset of JSON Schema generated for, and enforced on, the request payload.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"` // Empty accepts any value
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"` // false, or a *jsonSchema for maps
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+}
+
+// schemaEnums lists the allowed values of string fields, keyed by their dotted JSON path.
+var schemaEnums = map[string][]string{
+	"output":                             {outputApply, outputManifest},
+	"node_capacity_check":                {capacityCheckWarn, capacityCheckReject},
+	"dns_policy":                         dnsPolicies,
+	"secret_backend":                     {secretBackendKubernetes, secretBackendVault},
+	"secret_mode":                        secretModes,
+	"external_secret.secret_store_kind":  secretStoreKinds,
+	"volume_mode":                        volumeModes,
+	"host_path_type":                     hostPathTypes,
+	"image_pull_policy":                  imagePullPolicies,
+	"wp_admin_delivery":                  adminDeliveries,
+	"probe_scheme":                       {"HTTP", "HTTPS"},
+	"topology_spread.when_unsatisfiable": {"DoNotSchedule", "ScheduleAnyway"},
+}
+
+// requestSchema is generated once from the RequestPayload struct tags.
+var requestSchema = func() *jsonSchema {
+	s := schemaForType(reflect.TypeOf(RequestPayload{}), "")
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.Title = "RequestPayload"
+	return s
+}()
+
+// schemaForType maps a Go type to its JSON schema; path is the dotted JSON path used for enums.
+// Kinds without a mapping get a schema that accepts any value, leaving them to the decoder.
+func schemaForType(t reflect.Type, path string) *jsonSchema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.String:
+		return &jsonSchema{Type: "string", Enum: schemaEnums[path]}
+	case reflect.Slice:
+		return &jsonSchema{Type: "array", Items: schemaForType(t.Elem(), path)}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaForType(t.Elem(), path)}
+	case reflect.Struct:
+		s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			s.Properties[name] = schemaForType(t.Field(i).Type, fieldPath)
+		}
+		return s
+	}
+	return &jsonSchema{}
+}
+
+// validateAgainstSchema checks a decoded JSON value (numbers as json.Number) against s and
+// returns one error per offending field. null is accepted anywhere, as the decoder treats it as unset.
+func validateAgainstSchema(s *jsonSchema, value any, path string) []string {
+	if value == nil || s.Type == "" {
+		return nil
+	}
+	at := path
+	if at == "" {
+		at = "payload"
+	}
+	mismatch := func(got string) []string {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", at, s.Type, got)}
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if s.Type != "boolean" {
+			return mismatch("boolean")
+		}
+	case json.Number:
+		if s.Type != "integer" {
+			return mismatch("number")
+		}
+		if _, err := v.Int64(); err != nil {
+			return []string{fmt.Sprintf("%s: expected integer, got %s", at, v)}
+		}
+	case string:
+		if s.Type != "string" {
+			return mismatch("string")
+		}
+		// Empty strings mean "use the default", like null.
+		if v != "" && len(s.Enum) > 0 && !containsString(s.Enum, v) {
+			return []string{fmt.Sprintf("%s: must be one of %s", at, strings.Join(s.Enum, ", "))}
+		}
+	case []any:
+		if s.Type != "array" {
+			return mismatch("array")
+		}
+		var errs []string
+		for i, item := range v {
+			errs = append(errs, validateAgainstSchema(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case map[string]any:
+		if s.Type != "object" {
+			return mismatch("object")
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var errs []string
+		for _, k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			if prop, ok := s.Properties[k]; ok {
+				errs = append(errs, validateAgainstSchema(prop, v[k], fieldPath)...)
+				continue
+			}
+			if extra, ok := s.AdditionalProperties.(*jsonSchema); ok {
+				errs = append(errs, validateAgainstSchema(extra, v[k], fieldPath)...)
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("%s: unknown field", fieldPath))
+		}
+		return errs
+	}
+	return nil
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSchema serves the JSON schema of the /create-wordpress payload.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	_ = json.NewEncoder(w).Encode(requestSchema)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Values of secret_backend.
+const (
+	secretBackendKubernetes = "kubernetes"
+	secretBackendVault      = "vault"
+)
+
+// defaultVaultMount is the KV version 2 engine Vault enables at "secret/" in dev mode.
+const defaultVaultMount = "secret"
+
+// defaultVaultPathPrefix is where below the mount the deployer's own VAULT_TOKEN may write,
+// unless VAULT_PATH_PREFIX says otherwise. Requests with a token of their own write anywhere.
+const defaultVaultPathPrefix = "wordpress"
+
+// vaultPathPrefix returns the KV path prefix requests using the deployer's token are kept to.
+func vaultPathPrefix() string {
+	if prefix := strings.Trim(os.Getenv("VAULT_PATH_PREFIX"), "/"); prefix != "" {
+		return prefix
+	}
+	return defaultVaultPathPrefix
+}
+
+// vaultPathWithin reports whether path lies at or below prefix, without any "." or ".."
+// segment that Vault would resolve to somewhere else.
+func vaultPathWithin(path, prefix string) bool {
+	path = strings.Trim(path, "/")
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return false
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// errSecretSink means the credentials could not be written to an external secret manager.
+var errSecretSink = errors.New("unable to export credentials")
+
+// VaultConfig says where in Vault's KV version 2 engine the database credentials are written.
+// Address falls back to the deployer's VAULT_ADDR, and Token to its VAULT_TOKEN, but only
+// when the address is VAULT_ADDR too, so the token is never sent to a server a caller chose.
+type VaultConfig struct {
+	Address   string `json:"address,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Namespace string `json:"namespace,omitempty"` // Vault Enterprise namespace, sent as X-Vault-Namespace
+	Mount     string `json:"mount,omitempty"`     // KV v2 mount; defaults to "secret"
+	Path      string `json:"path,omitempty"`      // Defaults to "wordpress/<namespace>/<db secret name>"
+}
+
+// SecretSink stores a stack's generated credentials somewhere.
+type SecretSink interface {
+	// Name describes the destination, for logs.
+	Name() string
+	Write(ctx context.Context, secret *corev1.Secret) error
+}
+
+// secretSinks returns where the credentials Secret is written. The Kubernetes Secret always
+// comes first, since the MySQL and WordPress pods read their credentials from it; the selected
+// secret_backend, if not Kubernetes, gets a copy for the organisation's own secret store.
+func secretSinks(clientSet *kubernetes.Clientset, payload RequestPayload) []SecretSink {
+	sinks := []SecretSink{kubernetesSecretSink{clientSet: clientSet}}
+	if payload.SecretBackend == secretBackendVault {
+		sinks = append(sinks, vaultSecretSink{config: *payload.Vault, client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	return sinks
+}
+
+// kubernetesSecretSink creates the Secret in the stack's namespace.
+type kubernetesSecretSink struct {
+	clientSet *kubernetes.Clientset
+}
+
+func (s kubernetesSecretSink) Name() string { return "Kubernetes" }
+
+func (s kubernetesSecretSink) Write(ctx context.Context, secret *corev1.Secret) error {
+	_, err := s.clientSet.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create secret %s: %w", secret.Name, err)
+	}
+	return nil
+}
+
+// vaultSecretSink writes the Secret's keys as one KV version 2 secret, replacing any previous
+// version at the same path.
+type vaultSecretSink struct {
+	config VaultConfig
+	client *http.Client
+}
+
+func (s vaultSecretSink) Name() string { return "Vault" }
+
+// vaultSecretPath returns the KV path of a stack's credentials, below the mount.
+func vaultSecretPath(config VaultConfig, secret *corev1.Secret) string {
+	if config.Path != "" {
+		return strings.Trim(config.Path, "/")
+	}
+	return fmt.Sprintf("%s/%s/%s", vaultPathPrefix(), secret.Namespace, secret.Name)
+}
+
+func (s vaultSecretSink) Write(ctx context.Context, secret *corev1.Secret) error {
+	data := map[string]string{}
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	body, err := json.Marshal(map[string]any{"data": data})
+	if err != nil {
+		return err
+	}
+
+	path := vaultSecretPath(s.config, secret)
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(s.config.Address, "/"), strings.Trim(s.config.Mount, "/"), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w to Vault: %v", errSecretSink, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", s.config.Token)
+	if s.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.config.Namespace)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w to Vault: %v", errSecretSink, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// Vault explains failures in {"errors": [...]}, which never echoes the request body.
+		var answer struct {
+			Errors []string `json:"errors"`
+		}
+		detail := res.Status
+		if raw, _ := io.ReadAll(io.LimitReader(res.Body, 4096)); json.Unmarshal(raw, &answer) == nil && len(answer.Errors) > 0 {
+			detail = fmt.Sprintf("%s: %s", res.Status, strings.Join(answer.Errors, "; "))
+		}
+		return fmt.Errorf("%w to Vault at %s/%s: %s", errSecretSink, s.config.Mount, path, detail)
+	}
+	return nil
+}
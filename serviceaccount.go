@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PolicyRule grants the stack's ServiceAccount access to the Kubernetes API, as a Role rule does.
+type PolicyRule struct {
+	APIGroups     []string `json:"api_groups,omitempty"` // Omitted or "" for the core group
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resource_names,omitempty"`
+	Verbs         []string `json:"verbs"`
+}
+
+// buildStackServiceAccount returns the stack's own ServiceAccount. Its pods only get an API
+// token when service_account_rules grant them something to do with it.
+func buildStackServiceAccount(payload RequestPayload, names stackNames,
+	pullSecrets []corev1.LocalObjectReference) *corev1.ServiceAccount {
+
+	automount := len(payload.ServiceAccountRules) > 0
+	return &corev1.ServiceAccount{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.ServiceAccount,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.ServiceAccount, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
+		},
+		ImagePullSecrets:             pullSecrets,
+		AutomountServiceAccountToken: &automount,
+	}
+}
+
+// createStackServiceAccount creates the stack's ServiceAccount with the image pull secrets of
+// the namespace's default one, which the stack's pods would otherwise have pulled with.
+func createStackServiceAccount(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	accounts := clientSet.CoreV1().ServiceAccounts(payload.Namespace)
+	var pullSecrets []corev1.LocalObjectReference
+	defaultAccount, err := accounts.Get(ctx, "default", metaV1.GetOptions{})
+	switch {
+	case err == nil:
+		pullSecrets = defaultAccount.ImagePullSecrets
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("unable to read the default service account: %w", err)
+	}
+
+	sa := buildStackServiceAccount(payload, names, pullSecrets)
+	if _, err := accounts.Create(ctx, sa, metaV1.CreateOptions{}); err != nil {
+		return fmt.Errorf("unable to create service account %s: %w", names.ServiceAccount, err)
+	}
+	return nil
+}
+
+// buildStackRole returns a Role holding service_account_rules, named like the ServiceAccount.
+func buildStackRole(payload RequestPayload, names stackNames) *rbacv1.Role {
+	role := &rbacv1.Role{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.ServiceAccount,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.ServiceAccount, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
+		},
+	}
+	for _, rule := range payload.ServiceAccountRules {
+		apiGroups := rule.APIGroups
+		if len(apiGroups) == 0 {
+			apiGroups = []string{""}
+		}
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{
+			APIGroups:     apiGroups,
+			Resources:     rule.Resources,
+			ResourceNames: rule.ResourceNames,
+			Verbs:         rule.Verbs,
+		})
+	}
+	return role
+}
+
+// createStackRole creates the Role described by buildStackRole.
+func createStackRole(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	role := buildStackRole(payload, names)
+	_, err := clientSet.RbacV1().Roles(payload.Namespace).Create(ctx, role, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create role %s: %w", names.ServiceAccount, err)
+	}
+	return nil
+}
+
+// buildStackRoleBinding returns the RoleBinding granting the stack's Role to its ServiceAccount.
+func buildStackRoleBinding(payload RequestPayload, names stackNames) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.ServiceAccount,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.ServiceAccount, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     names.ServiceAccount,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      names.ServiceAccount,
+			Namespace: payload.Namespace,
+		}},
+	}
+}
+
+// createStackRoleBinding creates the RoleBinding described by buildStackRoleBinding.
+func createStackRoleBinding(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames) error {
+
+	binding := buildStackRoleBinding(payload, names)
+	_, err := clientSet.RbacV1().RoleBindings(payload.Namespace).Create(ctx, binding, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create role binding %s: %w", names.ServiceAccount, err)
+	}
+	return nil
+}
+
+// applyServiceAccount runs a pod of the stack as the stack's ServiceAccount, if it has one.
+func applyServiceAccount(spec *corev1.PodSpec, payload RequestPayload, names stackNames) {
+	if payload.ServiceAccount {
+		spec.ServiceAccountName = names.ServiceAccount
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Defaults for the admin login of a shared database server.
+const (
+	defaultSharedDBAdminUser        = "root"
+	defaultSharedDBAdminPasswordKey = "MYSQL_ROOT_PASSWORD"
+)
+
+// MySQL limits the length of database and user names.
+const (
+	maxMySQLDatabaseName = 64
+	maxMySQLUserName     = 32
+)
+
+// sharedDBProvisionScript logs in to the shared server as its admin and creates the stack's
+// database and user. It is idempotent, and resets the user's password to the one in the
+// stack's Secret, so it can be rerun whenever that Secret changes. The admin password comes
+// from MYSQL_PWD; the names are plain identifiers, see sharedDatabaseIdentifier.
+const sharedDBProvisionScript = `mysql --connect-timeout=10 -h "$DB_HOST" -P "$DB_PORT" -u "$ADMIN_USER" \
+  ${DB_SSL_CA:+--ssl-ca="$DB_SSL_CA" --ssl-mode=VERIFY_CA} <<SQL
+CREATE DATABASE IF NOT EXISTS $DB_NAME;
+CREATE USER IF NOT EXISTS '$DB_USER'@'%' IDENTIFIED BY '$DB_PASSWORD';
+ALTER USER '$DB_USER'@'%' IDENTIFIED BY '$DB_PASSWORD';
+GRANT ALL PRIVILEGES ON $DB_NAME.* TO '$DB_USER'@'%';
+SQL
+`
+
+// SharedDatabase gives the stack a database and user of its own on a MySQL server that
+// already runs in the namespace, e.g. one deployed for many sites, instead of deploying one.
+// Both are named after the stack and stay on the server when the stack is deleted.
+type SharedDatabase struct {
+	Service          string `json:"service"`                      // MySQL Service in the stack's namespace
+	Port             int    `json:"port,omitempty"`               // Defaults to 3306
+	AdminSecret      string `json:"admin_secret"`                 // Secret in the stack's namespace holding the admin password
+	AdminPasswordKey string `json:"admin_password_key,omitempty"` // Defaults to MYSQL_ROOT_PASSWORD
+	AdminUser        string `json:"admin_user,omitempty"`         // Defaults to root; needs CREATE USER and GRANT OPTION
+}
+
+// errSharedDatabaseSetup means the shared server or its admin Secret is not usable as given.
+var errSharedDatabaseSetup = errors.New("shared database is not usable")
+
+// sharedDatabaseIdentifier turns a stack ID into a MySQL identifier of at most max bytes made of
+// letters, digits and underscores, starting with a letter, so it needs no quoting. IDs too long
+// for it are shortened with a hash of the whole ID, keeping the names of distinct stacks apart.
+func sharedDatabaseIdentifier(id string, max int) string {
+	ident := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+	if c := ident[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+		ident = "wp_" + ident
+	}
+	if len(ident) > max {
+		sum := sha256.Sum256([]byte(id))
+		ident = ident[:max-9] + "_" + hex.EncodeToString(sum[:4])
+	}
+	return ident
+}
+
+// resolveSharedDatabase names the stack's database and user on the shared server once the
+// stack's names are final. preparePayload already turned shared_database into the
+// external_database WordPress connects to.
+func resolveSharedDatabase(payload RequestPayload, names stackNames) {
+	if payload.SharedDatabase == nil {
+		return
+	}
+	payload.ExternalDatabase.Name = sharedDatabaseIdentifier(names.ID(), maxMySQLDatabaseName)
+	payload.ExternalDatabase.User = sharedDatabaseIdentifier(names.ID(), maxMySQLUserName)
+}
+
+// checkSharedDatabase checks that the shared server's Service and admin Secret exist, and that
+// the Secret holds the admin password, before anything is created.
+func checkSharedDatabase(ctx context.Context, clientSet *kubernetes.Clientset, payload RequestPayload) error {
+	shared := payload.SharedDatabase
+	_, err := clientSet.CoreV1().Services(payload.Namespace).Get(ctx, shared.Service, metaV1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: service %s not found in namespace %s", errSharedDatabaseSetup, shared.Service, payload.Namespace)
+	}
+	if err != nil {
+		return err
+	}
+	secret, err := clientSet.CoreV1().Secrets(payload.Namespace).Get(ctx, shared.AdminSecret, metaV1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: secret %s not found in namespace %s", errSharedDatabaseSetup, shared.AdminSecret, payload.Namespace)
+	}
+	if err != nil {
+		return err
+	}
+	if len(secret.Data[shared.AdminPasswordKey]) == 0 {
+		return fmt.Errorf("%w: secret %s has no %s key", errSharedDatabaseSetup, shared.AdminSecret, shared.AdminPasswordKey)
+	}
+	return nil
+}
+
+// buildSharedDBProvisionJob returns a Job that creates the stack's database and user on the
+// shared server with the admin login. It uses the MySQL image only for its client.
+func buildSharedDBProvisionJob(payload RequestPayload, names stackNames) *batchv1.Job {
+	shared, ext := payload.SharedDatabase, payload.ExternalDatabase
+	secretKey := func(name, secretName, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.DBProvisionJob,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.DBProvisionJob, names, componentDatabase),
+			Annotations: stackAnnotations(payload),
+		},
+		Spec: batchv1.JobSpec{
+			// A wrong admin password won't get better with retries.
+			BackoffLimit:            int32Ptr(1),
+			TTLSecondsAfterFinished: int32Ptr(600),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      stackLabels(names.DBProvisionJob, names, componentDatabase),
+					Annotations: podAnnotations(payload),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadJob),
+					Containers: []corev1.Container{
+						{
+							Name:            "db-provision",
+							Image:           payload.MySQLImage,
+							ImagePullPolicy: imagePullPolicy(payload, payload.MySQLImage),
+							Command:         []string{"sh", "-c", sharedDBProvisionScript},
+							Env: []corev1.EnvVar{
+								{Name: "DB_HOST", Value: ext.Host},
+								{Name: "DB_PORT", Value: strconv.Itoa(ext.Port)},
+								{Name: "ADMIN_USER", Value: shared.AdminUser},
+								secretKey("MYSQL_PWD", shared.AdminSecret, shared.AdminPasswordKey),
+								secretKey("DB_NAME", names.DBSecret, "WORDPRESS_DB_NAME"),
+								secretKey("DB_USER", names.DBSecret, "WORDPRESS_DB_USER"),
+								secretKey("DB_PASSWORD", names.DBSecret, "WORDPRESS_DB_PASSWORD"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	applyDNS(&job.Spec.Template.Spec, payload)
+	applyServiceAccount(&job.Spec.Template.Spec, payload, names)
+	if mountDBCACert(&job.Spec.Template.Spec, payload, names) {
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "DB_SSL_CA", Value: dbCAMountPath + "/" + dbCAKey})
+	}
+	return job
+}
+
+// provisionSharedDatabase runs the provisioning Job and waits for its verdict. A Job left
+// from an earlier run, as when reconcile provisions again, is deleted first.
+func provisionSharedDatabase(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, timeout time.Duration) error {
+
+	jobs := clientSet.BatchV1().Jobs(payload.Namespace)
+	background := metaV1.DeletePropagationBackground
+	err := jobs.Delete(ctx, names.DBProvisionJob, metaV1.DeleteOptions{PropagationPolicy: &background})
+	if err == nil {
+		log.Printf("[INFO] Deleted previous provisioning job %s", names.DBProvisionJob)
+		err = wait.PollUntilContextTimeout(ctx, time.Second, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+			_, err := jobs.Get(ctx, names.DBProvisionJob, metaV1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete previous job %s: %w", names.DBProvisionJob, err)
+	}
+
+	job := buildSharedDBProvisionJob(payload, names)
+	if _, err := jobs.Create(ctx, job, metaV1.CreateOptions{}); err != nil {
+		return fmt.Errorf("unable to create provisioning job %s: %w", names.DBProvisionJob, err)
+	}
+	return waitForJobComplete(ctx, clientSet, payload.Namespace, names.DBProvisionJob, timeout)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// snapshotGroupVersion is the CSI snapshot API; its CRDs ship with the external-snapshotter,
+// not with Kubernetes itself. client-go has no typed client for it, so it is called over REST.
+const snapshotGroupVersion = "snapshot.storage.k8s.io/v1"
+
+// volumeSnapshot is the part of a VolumeSnapshot the deployer writes and reads back.
+type volumeSnapshot struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metaV1.ObjectMeta `json:"metadata"`
+	Spec       struct {
+		VolumeSnapshotClassName *string `json:"volumeSnapshotClassName,omitempty"`
+		Source                  struct {
+			PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
+		} `json:"source"`
+	} `json:"spec"`
+	Status *struct {
+		ReadyToUse *bool `json:"readyToUse,omitempty"`
+		Error      *struct {
+			Message string `json:"message,omitempty"`
+		} `json:"error,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+// snapshotAPIInstalled reports whether the cluster serves the VolumeSnapshot API.
+func snapshotAPIInstalled(clientSet *kubernetes.Clientset) (bool, error) {
+	_, err := clientSet.Discovery().ServerResourcesForGroupVersion(snapshotGroupVersion)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// snapshotsPath returns the REST path of the VolumeSnapshots in a namespace, or of one of them.
+func snapshotsPath(namespace, name string) string {
+	path := "/apis/" + snapshotGroupVersion + "/namespaces/" + namespace + "/volumesnapshots"
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// createVolumeSnapshot snapshots a PVC and waits until the snapshot is ready to use, so the PVC
+// can be deleted safely afterwards. An empty class uses the cluster's default snapshot class.
+func createVolumeSnapshot(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, pvcName, class string, timeout time.Duration) (string, error) {
+
+	pvc, err := clientSet.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metaV1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to read PVC %s: %w", pvcName, err)
+	}
+	// Snapshots are taken by a CSI driver; statically provisioned hostPath volumes have none.
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return "", fmt.Errorf("PVC %s has no storage class, so its volume cannot be snapshotted", pvcName)
+	}
+
+	snap := volumeSnapshot{APIVersion: snapshotGroupVersion, Kind: "VolumeSnapshot"}
+	snap.Metadata = metaV1.ObjectMeta{
+		Name:      fmt.Sprintf("%s-%d", pvcName, time.Now().Unix()),
+		Namespace: namespace,
+		Labels:    pvc.Labels,
+	}
+	if class != "" {
+		snap.Spec.VolumeSnapshotClassName = &class
+	}
+	snap.Spec.Source.PersistentVolumeClaimName = pvcName
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return "", err
+	}
+
+	restClient := clientSet.Discovery().RESTClient()
+	err = restClient.Post().AbsPath(snapshotsPath(namespace, "")).
+		SetHeader("Content-Type", "application/json").Body(body).Do(ctx).Error()
+	if err != nil {
+		return "", fmt.Errorf("unable to create snapshot of PVC %s: %w", pvcName, err)
+	}
+
+	log.Printf("[INFO] Waiting for snapshot %s/%s", namespace, snap.Metadata.Name)
+	err = wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		raw, err := restClient.Get().AbsPath(snapshotsPath(namespace, snap.Metadata.Name)).DoRaw(ctx)
+		if isContextError(err) {
+			return false, err
+		}
+		if err != nil {
+			log.Printf("[WARN] Error fetching snapshot status: %v", err)
+			return false, nil
+		}
+		var current volumeSnapshot
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return false, err
+		}
+		if st := current.Status; st != nil {
+			if st.Error != nil && st.Error.Message != "" {
+				return false, fmt.Errorf("snapshot %s failed: %s", snap.Metadata.Name, st.Error.Message)
+			}
+			if st.ReadyToUse != nil && *st.ReadyToUse {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("snapshot %s of PVC %s not ready: %w", snap.Metadata.Name, pvcName, err)
+	}
+	return snap.Metadata.Name, nil
+}
+
+// snapshotDatabaseClaims snapshots every MySQL PVC matching selector and returns the snapshot names.
+func snapshotDatabaseClaims(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, selector, class string) ([]string, error) {
+
+	installed, err := snapshotAPIInstalled(clientSet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for the VolumeSnapshot API: %w", err)
+	}
+	if !installed {
+		return nil, fmt.Errorf("the cluster does not serve %s; install the CSI snapshot CRDs and controller", snapshotGroupVersion)
+	}
+
+	dbSelector, err := labels.Parse(selector + "," + componentLabel + "=" + componentDatabase)
+	if err != nil {
+		return nil, err
+	}
+	pvcs, err := clientSet.CoreV1().PersistentVolumeClaims(namespace).List(ctx,
+		metaV1.ListOptions{LabelSelector: dbSelector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list database PVCs: %w", err)
+	}
+
+	var snapshots []string
+	for _, pvc := range pvcs.Items {
+		name, err := createVolumeSnapshot(ctx, clientSet, namespace, pvc.Name, class, 5*time.Minute)
+		if err != nil {
+			return snapshots, err
+		}
+		log.Printf("[INFO] Snapshotted PVC %s as %s", pvc.Name, name)
+		snapshots = append(snapshots, name)
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stackManifestKey is the key of the metadata ConfigMap holding the stack's manifest.
+const stackManifestKey = "manifest.json"
+
+// metadataLabel marks the metadata ConfigMap, so /stacks/{id}/manifest can find it by stack ID
+// alone, whatever name_template named it.
+const metadataLabel = "my-wordpress-deployer/metadata"
+
+// StackManifest records what a deploy created, for archiving.
+type StackManifest struct {
+	ID        string        `json:"id"` // The stack's app.kubernetes.io/instance label
+	Namespace string        `json:"namespace"`
+	Suffix    string        `json:"suffix"`
+	RequestID string        `json:"request_id,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	Resources []ResourceRef `json:"resources"`
+}
+
+// buildStackMetadataConfigMap returns the ConfigMap holding the stack's manifest. It belongs to
+// the stack, so deleting the stack removes it too.
+func buildStackMetadataConfigMap(ctx context.Context, payload RequestPayload, names stackNames,
+	refs []ResourceRef) (*corev1.ConfigMap, error) {
+
+	manifest, err := json.MarshalIndent(StackManifest{
+		ID:        names.ID(),
+		Namespace: payload.Namespace,
+		Suffix:    names.Suffix,
+		RequestID: requestID(ctx),
+		CreatedAt: time.Now().UTC(),
+		Resources: refs,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	cmLabels := stackLabels(names.StackMetadata, names, componentWordPress)
+	cmLabels[metadataLabel] = "true"
+	return &corev1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.StackMetadata,
+			Namespace:   payload.Namespace,
+			Labels:      cmLabels,
+			Annotations: stackAnnotations(payload),
+		},
+		Data: map[string]string{
+			stackManifestKey: string(manifest),
+		},
+	}, nil
+}
+
+// createStackMetadata stores the manifest of refs, which should include the ConfigMap itself.
+func createStackMetadata(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, refs []ResourceRef) error {
+
+	cm, err := buildStackMetadataConfigMap(ctx, payload, names, refs)
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().ConfigMaps(payload.Namespace).Create(ctx, cm, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create configmap %s: %w", names.StackMetadata, err)
+	}
+	return nil
+}
+
+// handleStackManifest returns the manifest saved by a deploy with save_manifest as a JSON
+// download. The stack is identified by its ID, "<deployment_name>-<suffix>", and the
+// namespace query parameter.
+func handleStackManifest(w http.ResponseWriter, r *http.Request) {
+	id, q := r.PathValue("id"), r.URL.Query()
+	namespace := q.Get("namespace")
+	if namespace == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: "namespace query parameter is required",
+		})
+		return
+	}
+	if errs := validation.IsValidLabelValue(id); len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("invalid stack ID %q: %s", id, strings.Join(errs, "; ")),
+		})
+		return
+	}
+
+	clientSet, err := InitKubeClient(q.Get("kubeconfig"), q.Get("context"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	selector := fmt.Sprintf("%s=%s,%s=%s,%s=true", managedByLabel, managedByValue, stackLabel, id, metadataLabel)
+	list, err := clientSet.CoreV1().ConfigMaps(namespace).List(r.Context(), metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		log.Printf("[ERROR] Failed to look up the manifest of stack %s: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Could not look up the manifest of stack %s: %v", id, err),
+		})
+		return
+	}
+	if len(list.Items) == 0 || list.Items[0].Data[stackManifestKey] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("No saved manifest for stack %s in namespace %s; it is only kept for deploys with save_manifest", id, namespace),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+"-manifest.json"))
+	_, _ = w.Write([]byte(list.Items[0].Data[stackManifestKey]))
+}
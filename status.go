@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StackStatus reports the health of both halves of a stack.
+type StackStatus struct {
+	WordPress ComponentStatus `json:"wordpress"`
+	MySQL     ComponentStatus `json:"mysql"`
+}
+
+// ComponentStatus summarizes one deployment and its pods.
+type ComponentStatus struct {
+	Deployment    string      `json:"deployment"`
+	Replicas      int32       `json:"replicas"`
+	ReadyReplicas int32       `json:"ready_replicas"`
+	Pods          []PodStatus `json:"pods,omitempty"`
+}
+
+// PodStatus is the phase and restart history of a single pod.
+type PodStatus struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Reason   string `json:"reason,omitempty"` // e.g. CrashLoopBackOff or OOMKilled, when a container is unhealthy
+	Restarts int32  `json:"restarts"`         // Summed over all containers
+}
+
+// errStackNotFound is returned when the stack's deployments do not exist.
+var errStackNotFound = errors.New("stack not found")
+
+// stackFromQuery reads the namespace, deployment_name and suffix query parameters
+// that identify an existing stack, plus its name_template if it had one, and rebuilds its
+// resource names.
+func stackFromQuery(r *http.Request) (string, stackNames, error) {
+	q := r.URL.Query()
+	namespace, prefix, suffix := q.Get("namespace"), q.Get("deployment_name"), q.Get("suffix")
+	if namespace == "" || suffix == "" {
+		return "", stackNames{}, errors.New("namespace and suffix query parameters are required")
+	}
+	if strings.TrimSpace(prefix) == "" {
+		prefix = "wp"
+	}
+	tmpl, err := nameTemplateParam(q.Get("name_template"))
+	if err != nil {
+		return "", stackNames{}, err
+	}
+	return namespace, newTemplatedStackNames(tmpl, namespace, prefix, suffix), nil
+}
+
+// handleStackStatus reports deployment readiness plus per-pod phases and restart counts
+// for the stack identified by the query parameters.
+func handleStackStatus(w http.ResponseWriter, r *http.Request) {
+	namespace, names, err := stackFromQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	clientSet, err := InitKubeClient(r.URL.Query().Get("kubeconfig"), r.URL.Query().Get("context"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	status, err := getStackStatus(r.Context(), clientSet, namespace, names)
+	if errors.Is(err, errStackNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("No stack %s found in namespace %s", names.ID(), namespace),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to get stack status: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ready := status.WordPress.ReadyReplicas > 0 && status.MySQL.ReadyReplicas > 0
+	message := "Stack is ready"
+	if !ready {
+		message = "Stack is not ready"
+	}
+	respondJSON(w, APIResponse{
+		Success: ready,
+		Message: message,
+		Status:  status,
+	})
+}
+
+// getStackStatus collects the status of the stack's WordPress and MySQL deployments.
+func getStackStatus(ctx context.Context, clientSet *kubernetes.Clientset, namespace string, names stackNames) (*StackStatus, error) {
+	wp, err := getComponentStatus(ctx, clientSet, namespace, names.WPDeployment)
+	if err != nil {
+		return nil, err
+	}
+	db, err := getComponentStatus(ctx, clientSet, namespace, names.DBDeployment)
+	if err != nil {
+		return nil, err
+	}
+	return &StackStatus{WordPress: wp, MySQL: db}, nil
+}
+
+// getComponentStatus reads a deployment's replica counts and the state of the pods
+// selected by its "app" label.
+func getComponentStatus(ctx context.Context, clientSet *kubernetes.Clientset, namespace, deployName string) (ComponentStatus, error) {
+	deploy, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, deployName, metaV1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ComponentStatus{}, fmt.Errorf("deployment %s: %w", deployName, errStackNotFound)
+	}
+	if err != nil {
+		return ComponentStatus{}, fmt.Errorf("unable to get deployment %s: %w", deployName, err)
+	}
+
+	status := ComponentStatus{
+		Deployment:    deployName,
+		Replicas:      deploy.Status.Replicas,
+		ReadyReplicas: deploy.Status.ReadyReplicas,
+	}
+
+	selector := labels.Set{"app": deployName}.String()
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return status, fmt.Errorf("unable to list pods for %s: %w", deployName, err)
+	}
+	for _, pod := range pods.Items {
+		status.Pods = append(status.Pods, summarizePod(pod))
+	}
+	return status, nil
+}
+
+// summarizePod reduces a pod to its phase, readiness, restarts and the most telling
+// container reason (a waiting reason such as CrashLoopBackOff, or the last termination).
+func summarizePod(pod corev1.Pod) PodStatus {
+	ps := PodStatus{
+		Name:  pod.Name,
+		Phase: string(pod.Status.Phase),
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			ps.Ready = cond.Status == corev1.ConditionTrue
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		ps.Restarts += cs.RestartCount
+		switch {
+		case cs.State.Waiting != nil && cs.State.Waiting.Reason != "":
+			ps.Reason = cs.State.Waiting.Reason
+		case ps.Reason == "" && cs.LastTerminationState.Terminated != nil:
+			ps.Reason = cs.LastTerminationState.Terminated.Reason
+		}
+	}
+	return ps
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultClassAnnotations mark the cluster's default StorageClass; the beta one is still set
+// by some older provisioners.
+var defaultClassAnnotations = []string{
+	"storageclass.kubernetes.io/is-default-class",
+	"storageclass.beta.kubernetes.io/is-default-class",
+}
+
+// checkStorageClass makes sure a dynamically provisioned claim can bind: the named class exists,
+// or, when none is named, the cluster has a default class.
+func checkStorageClass(ctx context.Context, clientSet *kubernetes.Clientset, storageClass string) error {
+	classes := clientSet.StorageV1().StorageClasses()
+	if storageClass != "" {
+		_, err := classes.Get(ctx, storageClass, metaV1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("StorageClass %q does not exist; list the available ones with `kubectl get storageclass`", storageClass)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read StorageClass %s: %w", storageClass, err)
+		}
+		return nil
+	}
+
+	list, err := classes.List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list StorageClasses: %w", err)
+	}
+	for _, class := range list.Items {
+		if isDefaultStorageClass(class) {
+			return nil
+		}
+	}
+	return fmt.Errorf("dynamic provisioning was requested without a storage_class, but the cluster has no default " +
+		"StorageClass, so the PVCs would stay Pending; set storage_class, mark a class as default, " +
+		"or turn dynamic_provisioning off to use hostPath volumes")
+}
+
+// isDefaultStorageClass reports whether class is marked as the cluster's default.
+func isDefaultStorageClass(class storagev1.StorageClass) bool {
+	for _, key := range defaultClassAnnotations {
+		if class.Annotations[key] == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultPVCBindTimeoutSeconds bounds the wait for a dynamically provisioned PVC to bind.
+const defaultPVCBindTimeoutSeconds = 120
+
+// pvcBindTimeout is how long the deployer waits for a provisioned claim to bind.
+func pvcBindTimeout(payload RequestPayload) time.Duration {
+	return time.Duration(payload.PVCBindTimeout) * time.Second
+}
+
+// errPVCNotBound means a claim stayed Pending, typically because its provisioner failed.
+var errPVCNotBound = errors.New("PVC did not bind")
+
+// pvcErrorStatus maps a failure to create or bind a PVC to a status: a claim that never
+// binds points at unusable storage rather than a deployer fault.
+func pvcErrorStatus(err error) int {
+	if errors.Is(err, errPVCNotBound) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// waitForPVCBound polls a claim until it is Bound, returning errPVCNotBound with the claim's
+// events when it stays Pending. A claim waiting for its first consumer (a StorageClass with
+// volumeBindingMode WaitForFirstConsumer) only binds once a pod uses it, so that counts as done.
+func waitForPVCBound(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, pvcName string, timeout time.Duration) error {
+
+	log.Printf("[INFO] Waiting for PVC to bind: %s/%s", namespace, pvcName)
+	claims := clientSet.CoreV1().PersistentVolumeClaims(namespace)
+	var events []string
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := claims.Get(ctx, pvcName, metaV1.GetOptions{})
+		if isContextError(err) {
+			return false, err
+		}
+		if err != nil {
+			log.Printf("[WARN] Error fetching PVC status: %v", err)
+			return false, nil
+		}
+		if pvc.Status.Phase == corev1.ClaimBound {
+			return true, nil
+		}
+		if pvc.Status.Phase == corev1.ClaimLost {
+			return false, fmt.Errorf("%w: PVC %s lost its volume", errPVCNotBound, pvcName)
+		}
+
+		events, err = pvcEvents(ctx, clientSet, pvc)
+		if err != nil {
+			log.Printf("[WARN] Error fetching PVC events: %v", err)
+			return false, nil
+		}
+		for _, event := range events {
+			if strings.HasPrefix(event, "WaitForFirstConsumer:") {
+				log.Printf("[INFO] PVC %s binds when its first pod is scheduled", pvcName)
+				return true, nil
+			}
+		}
+		debugf(ctx, "PVC %s is %s", pvcName, pvc.Status.Phase)
+		return false, nil
+	})
+	// The poll's own deadline ends it with a context error too; only the caller's means cancelled.
+	if err != nil && !errors.Is(err, errPVCNotBound) && !isContextError(ctx.Err()) {
+		err = fmt.Errorf("%w: PVC %s still Pending after %s", errPVCNotBound, pvcName, timeout)
+		if len(events) > 0 {
+			err = fmt.Errorf("%w; events: %s", err, strings.Join(events, "; "))
+		}
+	}
+	return err
+}
+
+// pvcEvents returns the claim's events as "Reason: message", oldest first.
+func pvcEvents(ctx context.Context, clientSet *kubernetes.Clientset, pvc *corev1.PersistentVolumeClaim) ([]string, error) {
+	selector := fields.Set{
+		"involvedObject.kind": "PersistentVolumeClaim",
+		"involvedObject.name": pvc.Name,
+		"involvedObject.uid":  string(pvc.UID),
+	}.String()
+	list, err := clientSet.CoreV1().Events(pvc.Namespace).List(ctx, metaV1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].LastTimestamp.Before(&list.Items[j].LastTimestamp)
+	})
+	events := make([]string, 0, len(list.Items))
+	for _, event := range list.Items {
+		events = append(events, event.Reason+": "+event.Message)
+	}
+	return events, nil
+}
+
+// volumeResult is the outcome of creating one component's volume.
+type volumeResult struct {
+	What   string // The resource that failed, e.g. "MySQL PVC"
+	Note   string // What was done with an existing PVC, as returned by createPersistentVolumeClaim
+	Err    error
+	Status int
+}
+
+// componentTitle names a component in messages.
+func componentTitle(component string) string {
+	if component == componentDatabase {
+		return "MySQL"
+	}
+	return "WordPress"
+}
+
+// createStackVolume creates a component's hostPath PV, unless provisioned dynamically, and its
+// PVC, then waits for a provisioned PVC to bind.
+func createStackVolume(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, component string) volumeResult {
+
+	title := componentTitle(component)
+	pvName, pvcName, sizeGB := stackVolume(payload, names, component)
+	var pvNote, note string
+	if pvName != "" {
+		log.Printf("[INFO] Creating hostPath PV for %s: %s", title, pvName)
+		var err error
+		pvNote, err = createPersistentVolume(ctx, clientSet, payload.Namespace, pvName, hostPathFor(payload.Namespace, pvName),
+			sizeGB, stackLabels(pvName, names, component), payload.HostNode, corev1.HostPathType(payload.HostPathType),
+			payload.ReuseExistingPV)
+		if errors.Is(err, errPVConflict) {
+			return volumeResult{What: title + " PV", Err: err, Status: http.StatusConflict}
+		}
+		if err != nil {
+			return volumeResult{What: title + " PV", Err: err, Status: http.StatusInternalServerError}
+		}
+	}
+
+	log.Printf("[INFO] Creating PVC for %s: %s", title, pvcName)
+	pvc, err := buildStackPVC(payload, names, component)
+	if err == nil {
+		note, err = createPersistentVolumeClaim(ctx, clientSet, pvc, pvName, payload.RecreateStalePVC)
+	}
+	if pvNote != "" {
+		note = strings.TrimSuffix(pvNote+"; "+note, "; ")
+	}
+	if err == nil && payload.DynamicProvisioning {
+		err = waitForPVCBound(ctx, clientSet, payload.Namespace, pvcName, pvcBindTimeout(payload))
+	}
+	if err != nil {
+		return volumeResult{What: title + " PVC", Note: note, Err: err, Status: pvcErrorStatus(err)}
+	}
+	return volumeResult{Note: note}
+}
+
+// createStackVolumes creates the volumes of the given components and returns their results in
+// the same order. With parallel_volumes they are created concurrently, and the first failure
+// cancels the others; otherwise one after the other, stopping at the first failure.
+func createStackVolumes(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, components []string) []volumeResult {
+
+	results := make([]volumeResult, 0, len(components))
+	if !payload.ParallelVolumes {
+		for _, component := range components {
+			res := createStackVolume(ctx, clientSet, payload, names, component)
+			results = append(results, res)
+			if res.Err != nil {
+				break
+			}
+		}
+		return results
+	}
+
+	// The group's context is cancelled by the first volume to fail; the results, not Wait's
+	// error, say which one that was, see firstVolumeFailure.
+	group, groupCtx := errgroup.WithContext(ctx)
+	results = results[:len(components)]
+	for i, component := range components {
+		group.Go(func() error {
+			results[i] = createStackVolume(groupCtx, clientSet, payload, names, component)
+			return results[i].Err
+		})
+	}
+	_ = group.Wait()
+	return results
+}
+
+// firstVolumeFailure picks the failure to report from createStackVolumes. A volume cancelled
+// because another one failed is only reported if nothing else is, e.g. when the request itself
+// was cancelled.
+func firstVolumeFailure(ctx context.Context, results []volumeResult) (volumeResult, bool) {
+	var cancelled *volumeResult
+	for i, res := range results {
+		if res.Err == nil {
+			continue
+		}
+		if ctx.Err() == nil && isContextError(res.Err) {
+			if cancelled == nil {
+				cancelled = &results[i]
+			}
+			continue
+		}
+		return res, true
+	}
+	if cancelled != nil {
+		return *cancelled, true
+	}
+	return volumeResult{}, false
+}
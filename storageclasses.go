@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StorageClassSummary describes a StorageClass the storage_class fields may name.
+type StorageClassSummary struct {
+	Name                 string `json:"name"`
+	Provisioner          string `json:"provisioner"`
+	Default              bool   `json:"default"`                // Used by dynamic_provisioning without a storage_class
+	AllowVolumeExpansion bool   `json:"allow_volume_expansion"` // Whether /resize can grow claims of this class
+}
+
+// handleListStorageClasses lists the cluster's StorageClasses, so clients can pick a valid
+// storage_class. The optional "kubeconfig" query parameter selects the cluster, as in the
+// create request.
+func handleListStorageClasses(w http.ResponseWriter, r *http.Request) {
+	clientSet, err := InitKubeClient(r.URL.Query().Get("kubeconfig"), r.URL.Query().Get("context"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create Kubernetes client: %v", err)
+		status, message := kubeClientError(err)
+		w.WriteHeader(status)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	summaries, err := listStorageClasses(r.Context(), clientSet)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list storage classes: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		respondJSON(w, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, APIResponse{
+		Success:        true,
+		Message:        fmt.Sprintf("Found %d storage class(es)", len(summaries)),
+		StorageClasses: summaries,
+	})
+}
+
+// listStorageClasses returns every StorageClass of the cluster, sorted by name.
+func listStorageClasses(ctx context.Context, clientSet *kubernetes.Clientset) ([]StorageClassSummary, error) {
+	list, err := clientSet.StorageV1().StorageClasses().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list StorageClasses: %w", err)
+	}
+	summaries := make([]StorageClassSummary, 0, len(list.Items))
+	for _, class := range list.Items {
+		summaries = append(summaries, StorageClassSummary{
+			Name:                 class.Name,
+			Provisioner:          class.Provisioner,
+			Default:              isDefaultStorageClass(class),
+			AllowVolumeExpansion: class.AllowVolumeExpansion != nil && *class.AllowVolumeExpansion,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries, nil
+}
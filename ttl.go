@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// expiresAtAnnotation holds the RFC 3339 time after which a stack with ttl_seconds is deleted.
+const expiresAtAnnotation = "my-wordpress-deployer/expires-at"
+
+// Bounds of RequestPayload.TTLSeconds: at least a minute, at most 30 days.
+const (
+	minTTLSeconds = 60
+	maxTTLSeconds = 30 * 24 * 60 * 60
+)
+
+// stackAnnotations returns the annotations of the stack's resources: the expiry time when
+// the stack has a TTL, nil otherwise.
+func stackAnnotations(payload RequestPayload) map[string]string {
+	if payload.expiresAt.IsZero() {
+		return nil
+	}
+	return map[string]string{expiresAtAnnotation: payload.expiresAt.Format(time.RFC3339)}
+}
+
+// reaperInterval parses TTL_REAPER_INTERVAL. The reaper deletes stacks on its own, so it is
+// opt-in: empty or "0" leaves it off.
+func reaperInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("must not be negative, got %s", value)
+	}
+	return interval, nil
+}
+
+// parseReapOrphanedPVs parses REAP_ORPHANED_PVS; empty means false.
+func parseReapOrphanedPVs(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// runTTLReaper deletes expired stacks every interval, for as long as the process runs. With
+// reapPVs it also deletes PVs left behind by deleted namespaces, which lists every PV of the
+// deployer in the cluster, so that sweep is opt-in.
+// It uses the deployer's own cluster credentials (in-cluster or ~/.kube/config), so stacks
+// deployed with a per-request kubeconfig to another cluster are not reaped.
+func runTTLReaper(interval time.Duration, reapPVs bool) {
+	log.Printf("[INFO] TTL reaper running every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		clientSet, err := InitKubeClient("", "")
+		if err != nil {
+			log.Printf("[WARN] TTL reaper could not initialize Kubernetes client: %v", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := reapExpiredStacks(ctx, clientSet, time.Now()); err != nil {
+			log.Printf("[ERROR] TTL reaper: %v", err)
+		}
+		if reapPVs {
+			if _, err := deleteOrphanedPVs(ctx, clientSet); err != nil {
+				log.Printf("[ERROR] TTL reaper: %v", err)
+			}
+		}
+		cancel()
+	}
+}
+
+// reapExpiredStacks deletes every stack whose WordPress deployment carries an expiry before
+// now. A namespace created by namespace_per_deployment for the stack is deleted with it.
+func reapExpiredStacks(ctx context.Context, clientSet *kubernetes.Clientset, now time.Time) error {
+	selector := labels.Set{managedByLabel: managedByValue, componentLabel: componentWordPress}.String()
+	deployments, err := clientSet.AppsV1().Deployments(metaV1.NamespaceAll).List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("unable to list WordPress deployments: %w", err)
+	}
+
+	reaped := map[string]bool{} // namespace/stack ID; a canary shares its stack's ID
+	for _, d := range deployments.Items {
+		value, ok := d.Annotations[expiresAtAnnotation]
+		if !ok {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("[WARN] Ignoring invalid %s on %s/%s: %v", expiresAtAnnotation, d.Namespace, d.Name, err)
+			continue
+		}
+		id := d.Labels[stackLabel]
+		key := d.Namespace + "/" + id
+		if id == "" || reaped[key] || now.Before(expiresAt) {
+			continue
+		}
+		reaped[key] = true
+
+		log.Printf("[INFO] Stack %s in namespace %s expired at %s; deleting it", id, d.Namespace, value)
+		stackSelector := labels.Set{managedByLabel: managedByValue, stackLabel: id}.String()
+		results, err := deleteManagedResources(ctx, clientSet, d.Namespace, stackSelector, false)
+		if err != nil {
+			log.Printf("[ERROR] Failed to delete expired stack %s: %v", id, err)
+			continue
+		}
+		log.Printf("[INFO] Deleted expired stack %s: %d resource(s)", id, len(results))
+
+		deleteStackNamespace(ctx, clientSet, d.Namespace, id)
+	}
+	return nil
+}
+
+// deleteStackNamespace deletes the stack's namespace if namespace_per_deployment created it for
+// the stack with the given ID. A namespace that merely shares the stack's name, or that existed
+// before and only picked up the managed-by label, is left alone.
+func deleteStackNamespace(ctx context.Context, clientSet kubernetes.Interface, namespace, id string) {
+	ns, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metaV1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Printf("[ERROR] Failed to get namespace %s: %v", namespace, err)
+		}
+		return
+	}
+	if ns.Annotations[namespaceCreatedForAnnotation] != id {
+		return
+	}
+	if err := clientSet.CoreV1().Namespaces().Delete(ctx, namespace, metaV1.DeleteOptions{}); err != nil {
+		log.Printf("[ERROR] Failed to delete namespace %s: %v", namespace, err)
+		return
+	}
+	log.Printf("[INFO] Deleted namespace %s", namespace)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeleteStackNamespaceKeepsPreexistingNamespace(t *testing.T) {
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metaV1.ObjectMeta{Name: "blog"}})
+
+	// {"namespace":"blog","deployment_name":"blog","suffix_length":0} gives the stack ID "blog",
+	// and deploying labels the existing namespace as managed.
+	created, err := ensureNamespace(ctx, clientSet, "blog", nil, nil, "blog")
+	if err != nil || created {
+		t.Fatalf("ensureNamespace() = %v, %v; want the existing namespace reused", created, err)
+	}
+	ns, err := clientSet.CoreV1().Namespaces().Get(ctx, "blog", metaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Labels[managedByLabel] != managedByValue {
+		t.Fatalf("namespace labels = %v, want the managed-by label merged on", ns.Labels)
+	}
+	if _, ok := ns.Annotations[namespaceCreatedForAnnotation]; ok {
+		t.Fatalf("existing namespace was annotated %s", namespaceCreatedForAnnotation)
+	}
+
+	deleteStackNamespace(ctx, clientSet, "blog", "blog")
+	if _, err := clientSet.CoreV1().Namespaces().Get(ctx, "blog", metaV1.GetOptions{}); err != nil {
+		t.Fatalf("pre-existing namespace was deleted: %v", err)
+	}
+}
+
+func TestDeleteStackNamespaceDeletesGeneratedNamespace(t *testing.T) {
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset()
+	if created, err := ensureNamespace(ctx, clientSet, "wp-abc12", nil, map[string]string{"team": "web"}, "wp-abc12"); err != nil || !created {
+		t.Fatalf("ensureNamespace() = %v, %v; want it created", created, err)
+	}
+
+	deleteStackNamespace(ctx, clientSet, "wp-abc12", "wp-other")
+	if _, err := clientSet.CoreV1().Namespaces().Get(ctx, "wp-abc12", metaV1.GetOptions{}); err != nil {
+		t.Fatalf("namespace of another stack was deleted: %v", err)
+	}
+	deleteStackNamespace(ctx, clientSet, "wp-abc12", "wp-abc12")
+	if _, err := clientSet.CoreV1().Namespaces().Get(ctx, "wp-abc12", metaV1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("generated namespace still exists: %v", err)
+	}
+}
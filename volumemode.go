@@ -0,0 +1,43 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mysqlBlockDevicePath is where the database claim appears in the MySQL container with
+// volume_mode Block; mysql_config points InnoDB's raw partitions at it.
+const mysqlBlockDevicePath = "/dev/mysql-data"
+
+// volumeModes are the values of volume_mode.
+var volumeModes = []string{string(corev1.PersistentVolumeFilesystem), string(corev1.PersistentVolumeBlock)}
+
+// databaseVolumeMode returns the mode of the database claim, Filesystem unless Block is asked for.
+func databaseVolumeMode(payload RequestPayload) corev1.PersistentVolumeMode {
+	if payload.VolumeMode == string(corev1.PersistentVolumeBlock) {
+		return corev1.PersistentVolumeBlock
+	}
+	return corev1.PersistentVolumeFilesystem
+}
+
+// attachMySQLBlockDevice hands the database claim of spec to the MySQL container as a raw
+// device instead of mounting it. A block claim cannot be the data directory, so that moves
+// to an emptyDir; only what mysql_config places on the device outlives the pod.
+func attachMySQLBlockDevice(spec *corev1.PodSpec, payload RequestPayload) {
+	if databaseVolumeMode(payload) != corev1.PersistentVolumeBlock {
+		return
+	}
+	container := &spec.Containers[0]
+	for i := range container.VolumeMounts {
+		if container.VolumeMounts[i].Name == "mysql-persistent-storage" {
+			container.VolumeMounts[i] = corev1.VolumeMount{Name: "mysql-data", MountPath: payload.MySQLDataPath}
+		}
+	}
+	container.VolumeDevices = append(container.VolumeDevices, corev1.VolumeDevice{
+		Name:       "mysql-persistent-storage",
+		DevicePath: mysqlBlockDevicePath,
+	})
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         "mysql-data",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultWaitForDBImage provides the nc used by the wait-for-database init container.
+const defaultWaitForDBImage = "busybox:1.36"
+
+// waitForDBScript blocks until the database accepts TCP connections.
+const waitForDBScript = `until nc -z -w 2 "$DB_HOST" "$DB_PORT"; do
+  echo "waiting for $DB_HOST:$DB_PORT"
+  sleep 2
+done`
+
+// buildWaitForDBInitContainer returns an init container holding WordPress back until its
+// database answers, so a pod (re)started while MySQL is down waits instead of crashlooping.
+func buildWaitForDBInitContainer(payload RequestPayload, names stackNames) corev1.Container {
+	host, port := names.DBService, 3306
+	if ext := payload.ExternalDatabase; ext != nil {
+		host, port = ext.Host, ext.Port
+	}
+	return corev1.Container{
+		Name:            "wait-for-db",
+		Image:           payload.WaitForDBImage,
+		ImagePullPolicy: imagePullPolicy(payload, payload.WaitForDBImage),
+		Command:         []string{"sh", "-c", waitForDBScript},
+		Env: []corev1.EnvVar{
+			{Name: "DB_HOST", Value: host},
+			{Name: "DB_PORT", Value: strconv.Itoa(port)},
+		},
+	}
+}
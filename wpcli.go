@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultWPCLIImage runs wp-cli against the WordPress files on the shared PVC.
+const defaultWPCLIImage = "wordpress:cli"
+
+// wpCLISidecarScript keeps the sidecar idle until the pod stops, exiting promptly on SIGTERM.
+const wpCLISidecarScript = `trap 'exit 0' TERM; while true; do sleep 3600 & wait $!; done`
+
+// wwwDataUID is the www-data user of the Debian-based WordPress image, which owns the files
+// on the PVC. The Alpine-based wp-cli image uses a different UID, so Jobs must run as this one.
+const wwwDataUID = 33
+
+// wpInstallScript installs WordPress unless it already is, reading everything from env vars
+// so the admin password never appears in the Job spec.
+const wpInstallScript = `wp core is-installed --path="$WP_PATH" && exit 0
+wp core install --path="$WP_PATH" --url="$WP_URL" --title="$WP_TITLE" \
+  --admin_user="$WP_ADMIN_USER" --admin_password="$WP_ADMIN_PASSWORD" \
+  --admin_email="$WP_ADMIN_EMAIL" --skip-email`
+
+// wpExtensionsScript installs every plugin (activated) and theme listed in WP_PLUGINS/WP_THEMES,
+// printing one RESULT line per item so failures can be reported individually. With
+// WP_REDIS_ENABLE it also installs the Redis object cache drop-in, and reports the plugin as
+// failed again if that doesn't work; the last RESULT line of an item counts.
+const wpExtensionsScript = `for p in $WP_PLUGINS; do
+  if wp plugin install "$p" --activate --path="$WP_PATH"; then echo "RESULT plugin $p ok"; else echo "RESULT plugin $p failed"; fi
+done
+if [ -n "$WP_REDIS_ENABLE" ] && ! wp redis enable --path="$WP_PATH"; then echo "RESULT plugin ` + redisCachePlugin + ` failed"; fi
+for t in $WP_THEMES; do
+  if wp theme install "$t" --path="$WP_PATH"; then echo "RESULT theme $t ok"; else echo "RESULT theme $t failed"; fi
+done`
+
+// wpSlugPattern matches wordpress.org plugin/theme slugs. Slugs end up in a shell script,
+// so nothing outside this set is accepted.
+var wpSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,99}$`)
+
+// ExtensionResult reports the outcome of installing one plugin or theme.
+type ExtensionResult struct {
+	Type      string `json:"type"` // "plugin" or "theme"
+	Slug      string `json:"slug"`
+	Installed bool   `json:"installed"`
+}
+
+// AdminCredentials are the WordPress administrator details set by auto_install.
+type AdminCredentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+	LoginURL string `json:"login_url"`
+}
+
+// wpSiteURL returns the requested site URL, or the in-cluster service URL when none was given.
+func wpSiteURL(payload RequestPayload, names stackNames) string {
+	if payload.WPSiteURL != "" {
+		return payload.WPSiteURL
+	}
+	if payload.ServicePort != defaultServicePort {
+		return fmt.Sprintf("http://%s:%d", names.WPService, payload.ServicePort)
+	}
+	return "http://" + names.WPService
+}
+
+// buildWPAdminSecret returns the Secret holding the admin account and site settings
+// consumed by the install Job.
+func buildWPAdminSecret(payload RequestPayload, names stackNames) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        names.WPAdminSecret,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(names.WPAdminSecret, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"WP_ADMIN_USER":     payload.WPAdminUser,
+			"WP_ADMIN_PASSWORD": payload.WPAdminPassword,
+			"WP_ADMIN_EMAIL":    payload.WPAdminEmail,
+			"WP_TITLE":          payload.WPSiteTitle,
+			"WP_URL":            wpSiteURL(payload, names),
+		},
+	}
+}
+
+// buildWPCLIJob returns a Job that runs script with wp-cli against the stack's WordPress files,
+// with the DB credentials and any extra secrets loaded as environment variables.
+func buildWPCLIJob(payload RequestPayload, names stackNames, jobName, script string,
+	env []corev1.EnvVar, extraSecrets ...string) *batchv1.Job {
+
+	pvcName, subPath := stackClaim(payload, names, componentWordPress)
+	// wp-cli loads the same wp-config.php, so its settings must resolve here too.
+	env = append(env, wordPressConfigEnv(payload, names)...)
+	envFrom := []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: names.DBSecret}}},
+	}
+	for _, secret := range extraSecrets {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secret}},
+		})
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        jobName,
+			Namespace:   payload.Namespace,
+			Labels:      stackLabels(jobName, names, componentWordPress),
+			Annotations: stackAnnotations(payload),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32Ptr(3),
+			TTLSecondsAfterFinished: int32Ptr(600),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      stackLabels(jobName, names, componentWordPress),
+					Annotations: podAnnotations(payload),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: restartPolicyFor(workloadJob),
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsUser:  int64Ptr(wwwDataUID),
+						RunAsGroup: int64Ptr(wwwDataUID),
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "wp-cli",
+							Image:           payload.WPCLIImage,
+							ImagePullPolicy: imagePullPolicy(payload, payload.WPCLIImage),
+							Command:         []string{"sh", "-c", script},
+							Env: append([]corev1.EnvVar{
+								{Name: "WP_PATH", Value: payload.WordPressDataPath},
+								// wp-cli caches downloads under $HOME, which www-data cannot write by default.
+								{Name: "HOME", Value: "/tmp"},
+							}, env...),
+							EnvFrom: envFrom,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "wordpress-persistent-storage",
+									MountPath: payload.WordPressDataPath,
+									SubPath:   subPath,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "wordpress-persistent-storage",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: pvcName,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// The wp-cli pods talk to the same database, so they need the same resolvers and CA.
+	applyDNS(&job.Spec.Template.Spec, payload)
+	applyServiceAccount(&job.Spec.Template.Spec, payload, names)
+	mountDBCACert(&job.Spec.Template.Spec, payload, names)
+	return job
+}
+
+// buildWPCLISidecar returns an idle wp-cli container sharing the WordPress volume, so operators
+// can run `kubectl exec <pod> -c wp-cli -- wp ...` without starting a Job.
+func buildWPCLISidecar(payload RequestPayload, names stackNames) corev1.Container {
+	_, subPath := stackClaim(payload, names, componentWordPress)
+	env := append([]corev1.EnvVar{
+		{Name: "HOME", Value: "/tmp"},
+	}, wordPressConfigEnv(payload, names)...)
+	return corev1.Container{
+		Name:            "wp-cli",
+		Image:           payload.WPCLIImage,
+		ImagePullPolicy: imagePullPolicy(payload, payload.WPCLIImage),
+		Command:         []string{"sh", "-c", wpCLISidecarScript},
+		// wp-cli finds wp-config.php from the working directory, so no --path is needed.
+		WorkingDir: payload.WordPressDataPath,
+		Env:        env,
+		EnvFrom: []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: names.DBSecret}}},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  int64Ptr(wwwDataUID),
+			RunAsGroup: int64Ptr(wwwDataUID),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "wordpress-persistent-storage",
+				MountPath: payload.WordPressDataPath,
+				SubPath:   subPath,
+			},
+		},
+	}
+}
+
+// installWordPress stores the admin credentials and runs `wp core install` in a Job,
+// waiting for it to finish so the caller can report whether the site is ready to log in.
+func installWordPress(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, timeout time.Duration) error {
+
+	secret := buildWPAdminSecret(payload, names)
+	_, err := clientSet.CoreV1().Secrets(payload.Namespace).Create(ctx, secret, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create admin secret %s: %w", names.WPAdminSecret, err)
+	}
+
+	job := buildWPCLIJob(payload, names, names.WPInstallJob, wpInstallScript, nil, names.WPAdminSecret)
+	_, err = clientSet.BatchV1().Jobs(payload.Namespace).Create(ctx, job, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create install job %s: %w", names.WPInstallJob, err)
+	}
+
+	return waitForJobComplete(ctx, clientSet, payload.Namespace, names.WPInstallJob, timeout)
+}
+
+// buildWPExtensionsJob returns the Job installing the requested plugins and themes.
+func buildWPExtensionsJob(payload RequestPayload, names stackNames) *batchv1.Job {
+	env := []corev1.EnvVar{
+		{Name: "WP_PLUGINS", Value: strings.Join(payload.WPPlugins, " ")},
+		{Name: "WP_THEMES", Value: strings.Join(payload.WPThemes, " ")},
+	}
+	env = append(env, redisEnableEnv(payload)...)
+	return buildWPCLIJob(payload, names, names.WPExtensionsJob, wpExtensionsScript, env)
+}
+
+// installExtensions runs the plugin/theme Job and reports each item's outcome from its log.
+// Items without a RESULT line (e.g. the Job died midway) are reported as not installed.
+func installExtensions(ctx context.Context, clientSet *kubernetes.Clientset,
+	payload RequestPayload, names stackNames, timeout time.Duration) ([]ExtensionResult, error) {
+
+	results := make([]ExtensionResult, 0, len(payload.WPPlugins)+len(payload.WPThemes))
+	for _, slug := range payload.WPPlugins {
+		results = append(results, ExtensionResult{Type: "plugin", Slug: slug})
+	}
+	for _, slug := range payload.WPThemes {
+		results = append(results, ExtensionResult{Type: "theme", Slug: slug})
+	}
+
+	job := buildWPExtensionsJob(payload, names)
+	_, err := clientSet.BatchV1().Jobs(payload.Namespace).Create(ctx, job, metaV1.CreateOptions{})
+	if err != nil {
+		return results, fmt.Errorf("unable to create extensions job %s: %w", names.WPExtensionsJob, err)
+	}
+	if err := waitForJobComplete(ctx, clientSet, payload.Namespace, names.WPExtensionsJob, timeout); err != nil {
+		return results, err
+	}
+
+	logs, err := jobLogs(ctx, clientSet, payload.Namespace, names.WPExtensionsJob)
+	if err != nil {
+		return results, err
+	}
+	ok := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 4 && fields[0] == "RESULT" {
+			ok[fields[1]+"/"+fields[2]] = fields[3] == "ok"
+		}
+	}
+	for i := range results {
+		results[i].Installed = ok[results[i].Type+"/"+results[i].Slug]
+	}
+	return results, nil
+}
+
+// jobLogs returns the log of the most recent successful pod of a Job.
+func jobLogs(ctx context.Context, clientSet *kubernetes.Clientset, namespace, jobName string) (string, error) {
+	selector := labels.Set{"job-name": jobName}.String()
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", fmt.Errorf("unable to list pods of job %s: %w", jobName, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		raw, err := clientSet.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			return "", fmt.Errorf("unable to read logs of pod %s: %w", pod.Name, err)
+		}
+		return string(raw), nil
+	}
+	return "", fmt.Errorf("no completed pod found for job %s", jobName)
+}
+
+// waitForJobComplete polls the Job until it succeeds, fails, or the timeout expires.
+func waitForJobComplete(ctx context.Context, clientSet *kubernetes.Clientset,
+	namespace, jobName string, timeout time.Duration) error {
+
+	log.Printf("[INFO] Waiting for job: %s/%s", namespace, jobName)
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		job, err := clientSet.BatchV1().Jobs(namespace).Get(ctx, jobName, metaV1.GetOptions{})
+		if isContextError(err) {
+			return false, err
+		}
+		if err != nil {
+			log.Printf("[WARN] Error fetching job status: %v", err)
+			// Could be transient, keep retrying
+			return false, nil
+		}
+
+		for _, cond := range job.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+				return false, fmt.Errorf("job %s failed: %s", jobName, cond.Message)
+			}
+		}
+		if job.Status.Succeeded >= 1 {
+			return true, nil
+		}
+		debugf(ctx, "Job %s not complete yet. Active=%d, Failed=%d",
+			jobName, job.Status.Active, job.Status.Failed)
+		return false, nil
+	})
+	if isContextError(err) && ctx.Err() == nil {
+		return fmt.Errorf("job %s did not complete within %s", jobName, timeout)
+	}
+	return err
+}
+
+// int64Ptr is a simple helper for pointer values.
+func int64Ptr(i int64) *int64 {
+	return &i
+}